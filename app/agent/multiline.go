@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"io"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// MultilineWriter joins lines of multiline events (i.e. stack traces) and writes each event with a single Write call.
+// Lines of the event joined with "\n". Pending event written on Close.
+type MultilineWriter struct {
+	wr     io.WriteCloser
+	joiner *core.MultilineJoiner[string]
+}
+
+// NewMultilineWriter wraps writer with multiline joining for the rule
+func NewMultilineWriter(wr io.WriteCloser, rule core.MultilineRule) *MultilineWriter {
+	res := &MultilineWriter{wr: wr}
+	res.joiner = core.NewMultilineJoiner(rule, func(lines []string) {
+		if _, err := wr.Write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
+			log.Printf("[WARN] failed to write multiline event, %v", err)
+		}
+	})
+	return res
+}
+
+// Write splits p to lines and passes them to joiner. Always successful, as actual write is delayed.
+func (w *MultilineWriter) Write(p []byte) (n int, err error) {
+	for line := range strings.SplitSeq(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w.joiner.Add(line, line)
+	}
+	return len(p), nil
+}
+
+// Close flushes pending event and closes underlying writer
+func (w *MultilineWriter) Close() error {
+	w.joiner.Flush()
+	return w.wr.Close()
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestMultilineWriter(t *testing.T) {
+	rule, err := core.ParseMultilineRule(`cont=^\s+;wait=50ms`)
+	require.NoError(t, err)
+
+	wr := &mockWriteCloser{}
+	w := NewMultilineWriter(wr, rule)
+
+	n, err := w.Write([]byte("panic: boom\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 12, n)
+	_, err = w.Write([]byte("\tmain.go:5\n\tmain.go:10\nnext line\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"panic: boom\n\tmain.go:5\n\tmain.go:10\n"}, wr.get())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"panic: boom\n\tmain.go:5\n\tmain.go:10\n", "next line\n"}, wr.get(), "flushed after wait")
+
+	_, err = w.Write([]byte("last line"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "last line\n", wr.get()[2], "flushed on close")
+	assert.True(t, wr.closed)
+}
+
+type mockWriteCloser struct {
+	sync.Mutex
+	recs   []string
+	closed bool
+}
+
+func (m *mockWriteCloser) Write(p []byte) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.recs = append(m.recs, string(bytes.Clone(p)))
+	return len(p), nil
+}
+
+func (m *mockWriteCloser) Close() error {
+	m.closed = true
+	return nil
+}
+
+func (m *mockWriteCloser) get() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string{}, m.recs...)
+}
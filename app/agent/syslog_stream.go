@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StreamSyslogWriter sends each write as syslog message over tcp or tls with octet-counted framing (RFC 6587, RFC 5425),
+// so multiline message is not split by the server. Message has the same "<PRI>TIMESTAMP HOSTNAME TAG[PID]: MSG" format
+// as udp syslog writer. Failed connection re-established on the next write.
+type StreamSyslogWriter struct {
+	network   string // tcp, tcp4 or tcp6
+	addr      string
+	tlsConfig *tls.Config // plain tcp if nil
+	priority  syslog.Priority
+	tag       string
+	hostname  string
+
+	lock sync.Mutex
+	conn net.Conn
+}
+
+const streamSyslogTimeout = time.Second
+
+// NewStreamSyslogWriter makes writer and connects to syslog server, with tls if tlsConfig set
+func NewStreamSyslogWriter(network, addr string, tlsConfig *tls.Config, priority syslog.Priority, tag string) (*StreamSyslogWriter, error) {
+	res := &StreamSyslogWriter{network: network, addr: addr, tlsConfig: tlsConfig, priority: priority, tag: tag}
+	res.hostname, _ = os.Hostname()
+	res.lock.Lock()
+	defer res.lock.Unlock()
+	if err := res.connect(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Write sends p as a single syslog message, reconnects and retries once on failure
+func (w *StreamSyslogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	line := fmt.Sprintf("<%d>%s %s %s[%d]: %s", w.priority, time.Now().Format(time.RFC3339), w.hostname, w.tag, os.Getpid(), msg)
+	frame := fmt.Sprintf("%d %s", len(line), line)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.conn != nil {
+		if err := w.write(frame); err == nil {
+			return len(p), nil
+		}
+	}
+	if err := w.connect(); err != nil {
+		return 0, err
+	}
+	if err := w.write(frame); err != nil {
+		return 0, errors.Wrapf(err, "can't write to syslog %s", w.addr)
+	}
+	return len(p), nil
+}
+
+// Close connection to syslog server
+func (w *StreamSyslogWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// connect closes the current connection and dials a new one, has to be called under lock
+func (w *StreamSyslogWriter) connect() error {
+	if w.conn != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+	dialer := &net.Dialer{Timeout: streamSyslogTimeout}
+	var conn net.Conn
+	var err error
+	if w.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, w.network, w.addr, w.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(w.network, w.addr)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't connect to syslog %s", w.addr)
+	}
+	w.conn = conn
+	if w.hostname == "" {
+		w.hostname = conn.LocalAddr().String()
+	}
+	return nil
+}
+
+func (w *StreamSyslogWriter) write(frame string) error {
+	_ = w.conn.SetWriteDeadline(time.Now().Add(streamSyslogTimeout))
+	_, err := w.conn.Write([]byte(frame))
+	return err
+}
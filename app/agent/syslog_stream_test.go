@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log/syslog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamSyslogWriter_TLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler()) // provides certificate for 127.0.0.1
+	defer ts.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates, MinVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+	defer listener.Close() // nolint
+
+	frames := make(chan string, 10)
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, e := listener.Accept()
+			if e != nil {
+				return
+			}
+			conns <- conn
+			go readFrames(conn, frames)
+		}
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	cfg := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	w, err := NewStreamSyslogWriter("tcp", listener.Addr().String(), cfg, syslog.LOG_DAEMON|syslog.LOG_ERR, "docker/c1")
+	require.NoError(t, err)
+	defer w.Close() // nolint
+
+	n, err := w.Write([]byte("line 1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+	_, err = w.Write([]byte("line 2\nwith two lines"))
+	require.NoError(t, err)
+
+	f := <-frames
+	assert.True(t, strings.HasPrefix(f, "<27>"), f)
+	assert.Contains(t, f, " docker/c1[")
+	assert.True(t, strings.HasSuffix(f, "]: line 1"), f)
+	assert.True(t, strings.HasSuffix(<-frames, "]: line 2\nwith two lines"))
+
+	// server closed connection, writer reconnects
+	(<-conns).Close() // nolint
+	assert.Eventually(t, func() bool {
+		_, e := w.Write([]byte("line 3"))
+		require.NoError(t, e)
+		select {
+		case f = <-frames:
+			return strings.HasSuffix(f, "]: line 3")
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, w.Close())
+
+	// certificate not trusted
+	_, err = NewStreamSyslogWriter("tcp", listener.Addr().String(), &tls.Config{MinVersion: tls.VersionTLS12}, syslog.LOG_INFO, "c1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't connect to syslog")
+}
+
+func TestStreamSyslogWriter_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close() // nolint
+	frames := make(chan string, 10)
+	go func() {
+		conn, e := listener.Accept()
+		if e != nil {
+			return
+		}
+		readFrames(conn, frames)
+	}()
+
+	w, err := NewStreamSyslogWriter("tcp", listener.Addr().String(), nil, syslog.LOG_DAEMON|syslog.LOG_INFO, "docker/c1")
+	require.NoError(t, err)
+	defer w.Close() // nolint
+	_, err = w.Write([]byte("panic: boom\n\tmain.go:5\n"))
+	require.NoError(t, err)
+	f := <-frames
+	assert.True(t, strings.HasPrefix(f, "<30>"), f)
+	assert.True(t, strings.HasSuffix(f, "]: panic: boom\n\tmain.go:5"), f)
+
+	_, err = NewStreamSyslogWriter("tcp", "127.0.0.1:1", nil, syslog.LOG_INFO, "c1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't connect to syslog")
+}
+
+// readFrames reads octet-counted frames from connection
+func readFrames(conn net.Conn, frames chan<- string) {
+	rd := bufio.NewReader(conn)
+	for {
+		size, err := rd.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			return
+		}
+		buf := make([]byte, n)
+		if _, err = io.ReadFull(rd, buf); err != nil {
+			return
+		}
+		frames <- string(buf)
+	}
+}
@@ -25,14 +25,20 @@ import (
 type CLI struct {
 	DisplayParams
 	APIParams
+	noStream bool // server doesn't push entries, follow mode polls find
 }
 
+const streamTimeout = time.Minute // inactivity timeout of the follow mode stream, reconnected after
+
+var errNoStream = errors.New("server doesn't stream")
+
 // APIParams define how and where access remote endpoint
 type APIParams struct {
 	UpdateInterval   time.Duration
 	Client           *http.Client
 	API              string
 	RepeaterStrategy strategy.Interface
+	Token            string // optional, sent as bearer token
 }
 
 // DisplayParams customizes how records will be showed
@@ -44,6 +50,7 @@ type DisplayParams struct {
 	ShowSyslog bool           // show non-docker messages from syslog, off by default
 	Grep       []string       // filter the final output line
 	UnGrep     []string       // inverse filter for the final output line
+	Columns    []string       // fields to show as columns, "-" for missing
 	TimeZone   *time.Location // custom TZ, default is local
 	Out        io.Writer      // custom out stream, default is stdout
 }
@@ -53,6 +60,8 @@ var (
 	red    = color.New(color.FgRed).SprintFunc()
 	yellow = color.New(color.FgYellow).SprintFunc()
 	white  = color.New(color.FgWhite).SprintFunc()
+	hiRed  = color.New(color.FgHiRed).SprintFunc()
+	cyan   = color.New(color.FgCyan).SprintFunc()
 )
 
 // NewCLI makes cli client
@@ -67,8 +76,9 @@ func NewCLI(apiParams APIParams, displayParams DisplayParams) *CLI {
 	return res
 }
 
-// Activate shows tail-like, colorized output. For FollowMode will run endless loop
-// doesn't return error on context cancellation, but exit func.
+// Activate shows tail-like, colorized output. For FollowMode will run endless loop, caught up client
+// gets entries pushed by /stream, or polls /find if server doesn't stream.
+// Doesn't return error on context cancellation, but exit func.
 func (c *CLI) Activate(ctx context.Context, request core.Request) (req core.Request, err error) {
 
 	var items []core.LogEntry
@@ -91,17 +101,17 @@ func (c *CLI) Activate(ctx context.Context, request core.Request) (req core.Requ
 		}
 
 		for _, e := range items {
-			line, ok := c.makeOutLine(e)
-			if !ok {
-				continue
-			}
-			if (len(c.Grep) > 0 && !contains(line, c.Grep)) || (len(c.UnGrep) > 0 && contains(line, c.UnGrep)) {
-				continue
-			}
-			_, _ = fmt.Fprint(c.Out, line)
+			c.print(e)
 		}
 		request.LastID = id
 
+		if c.FollowMode && len(items) == 0 && !c.noStream { // caught up, wait for pushed entries
+			if request.LastID, err = c.stream(ctx, request); err != nil {
+				log.Printf("[DEBUG] stream failed, %v", err)
+				c.noStream = errors.Is(err, errNoStream)
+			}
+		}
+
 		select {
 		case <-ctx.Done():
 			log.Printf("[DEBUG] terminated, %v", ctx.Err())
@@ -114,6 +124,59 @@ func (c *CLI) Activate(ctx context.Context, request core.Request) (req core.Requ
 	return request, nil
 }
 
+// stream prints entries pushed by server after request.LastID until the stream closed.
+// Returns ID of the last received entry, errNoStream if server doesn't push entries.
+func (c *CLI) stream(ctx context.Context, request core.Request) (lastID string, err error) {
+	lastID = request.LastID
+	body := &bytes.Buffer{}
+	if err = json.NewEncoder(body).Encode(request); err != nil {
+		return lastID, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/stream?timeout=%v", c.API, streamTimeout), body)
+	if err != nil {
+		return lastID, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return lastID, err
+	}
+	defer func() { _ = resp.Body.Close() }() // nolint
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		return lastID, errNoStream
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		e := core.LogEntry{}
+		if err = dec.Decode(&e); err != nil {
+			return lastID, errors.Wrap(err, "can't decode streamed record")
+		}
+		c.print(e)
+		lastID = e.ID
+	}
+	return lastID, nil
+}
+
+// do sends request with auth token
+func (c *CLI) do(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.Client.Do(req)
+}
+
+// print entry if it passes grep and ungrep filters
+func (c *CLI) print(e core.LogEntry) {
+	line, ok := c.makeOutLine(e)
+	if !ok {
+		return
+	}
+	if (len(c.Grep) > 0 && !contains(line, c.Grep)) || (len(c.UnGrep) > 0 && contains(line, c.UnGrep)) {
+		return
+	}
+	_, _ = fmt.Fprint(c.Out, line)
+}
+
 func (c *CLI) resetCtxError(err error) error {
 	if err == context.Canceled {
 		return nil
@@ -135,7 +198,28 @@ func (c *CLI) makeOutLine(e core.LogEntry) (string, bool) {
 	if c.ShowTS {
 		ts = fmt.Sprintf(" - %s", e.TS.In(c.TimeZone).Format("2006-01-02 15:04:05.999999"))
 	}
-	line := fmt.Sprintf("%s:%s%s%s - %s\n", red(e.Host), green(e.Container), yellow(ts), yellow(pid), white(e.Msg))
+	cols := ""
+	if len(c.Columns) > 0 {
+		vals := make([]string, len(c.Columns))
+		for i, col := range c.Columns {
+			vals[i] = "-"
+			if v, ok := e.Fields[col]; ok && v != "" {
+				vals[i] = v
+			}
+		}
+		cols = " - " + strings.Join(vals, " ")
+	}
+
+	container := e.Container
+	if e.Group != "" {
+		container = e.Group + "/" + e.Container
+	}
+
+	msgColor := white
+	if e.HasPriority() && e.Severity <= core.SevErr { // err and more severe, i.e. container's stderr
+		msgColor = hiRed
+	}
+	line := fmt.Sprintf("%s:%s%s%s%s - %s\n", red(e.Host), green(container), yellow(ts), yellow(pid), cyan(cols), msgColor(e.Msg))
 	return line, true
 }
 
@@ -143,7 +227,11 @@ func (c *CLI) getLastID(ctx context.Context) (string, error) {
 
 	lastEntry := core.LogEntry{}
 	err := repeater.New(c.RepeaterStrategy).Do(ctx, func() (e error) {
-		resp, e := c.Client.Get(fmt.Sprintf("%s/last", c.API))
+		req, e := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/last", c.API), http.NoBody)
+		if e != nil {
+			return e
+		}
+		resp, e := c.do(req)
 		if e != nil {
 			return errors.Wrapf(e, "can't get last id")
 		}
@@ -177,7 +265,7 @@ func (c *CLI) getNext(ctx context.Context, request core.Request) (items []core.L
 
 	err = repeater.New(c.RepeaterStrategy).Do(ctx, func() error {
 		var resp *http.Response
-		resp, err = c.Client.Do(req)
+		resp, err = c.do(req)
 		if err != nil {
 			return err
 		}
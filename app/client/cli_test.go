@@ -8,10 +8,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fatih/color"
 	"github.com/go-pkgz/repeater/strategy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,6 +37,24 @@ func TestCli(t *testing.T) {
 	assert.Equal(t, "5ce8718aef1d7346a5443a6f", r1.LastID)
 }
 
+func TestCliWithToken(t *testing.T) {
+	auths := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.URL.Path+" "+r.Header.Get("Authorization"))
+		if r.URL.Path == "/v1/last" {
+			require.NoError(t, json.NewEncoder(w).Encode(core.LogEntry{ID: "id1"}))
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode([]core.LogEntry{}))
+	}))
+	defer ts.Close()
+
+	c := NewCLI(APIParams{API: ts.URL + "/v1", Client: &http.Client{}, Token: "secret"}, DisplayParams{Out: &bytes.Buffer{}, TailMode: true})
+	_, err := c.Activate(context.Background(), core.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/v1/last Bearer secret", "/v1/find Bearer secret"}, auths)
+}
+
 func TestCliWithPidAndTS(t *testing.T) {
 
 	ts := prepTestServer(t)
@@ -90,6 +110,77 @@ func TestCliWithUnGrep(t *testing.T) {
 	assert.Equal(t, "h1:c1 - msg1\nh1:c2 - msg2\nh2:c1 - msg3\nh1:c1 - msg4\nh2:c2 - msg6\n", out.String())
 }
 
+func TestCliWithGroup(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recs := []core.LogEntry{}
+		if atomic.AddInt64(&count, 1) > 1 {
+			require.NoError(t, json.NewEncoder(w).Encode(recs))
+			return
+		}
+		recs = []core.LogEntry{
+			{ID: "5ce8718aef1d7346a5443a1f", Host: "h1", Group: "db", Container: "c1", Msg: "msg1"},
+			{ID: "5ce8718aef1d7346a5443a2f", Host: "h1", Container: "c2", Msg: "msg2"},
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(recs))
+	}))
+	defer ts.Close()
+
+	out := bytes.Buffer{}
+	c := NewCLI(APIParams{API: ts.URL + "/v1", Client: &http.Client{}}, DisplayParams{Out: &out})
+	_, err := c.Activate(context.Background(), core.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, "h1:db/c1 - msg1\nh1:c2 - msg2\n", out.String())
+}
+
+func TestCliWithColumns(t *testing.T) {
+	var count int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recs := []core.LogEntry{}
+		if atomic.AddInt64(&count, 1) > 1 {
+			require.NoError(t, json.NewEncoder(w).Encode(recs))
+			return
+		}
+		recs = []core.LogEntry{
+			{ID: "5ce8718aef1d7346a5443a1f", Host: "h1", Container: "c1", Msg: "msg1",
+				Fields: map[string]string{"level": "error", "user_id": "42"}},
+			{ID: "5ce8718aef1d7346a5443a2f", Host: "h1", Container: "c2", Msg: "msg2",
+				Fields: map[string]string{"level": "info"}},
+			{ID: "5ce8718aef1d7346a5443a3f", Host: "h1", Container: "c2", Msg: "msg3"},
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(recs))
+	}))
+	defer ts.Close()
+
+	out := bytes.Buffer{}
+	c := NewCLI(APIParams{API: ts.URL + "/v1", Client: &http.Client{}}, DisplayParams{Out: &out, Columns: []string{"level", "user_id"}})
+	_, err := c.Activate(context.Background(), core.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, "h1:c1 - error 42 - msg1\nh1:c2 - info - - msg2\nh1:c2 - - - - msg3\n", out.String())
+}
+
+func TestCli_makeOutLineSeverityColor(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = noColor }()
+
+	c := NewCLI(APIParams{}, DisplayParams{Out: &bytes.Buffer{}})
+	tbl := []struct {
+		ent core.LogEntry
+		red bool
+	}{
+		{core.LogEntry{Host: "h1", Container: "c1", Msg: "msg1", Facility: 1, Severity: core.SevErr}, true},
+		{core.LogEntry{Host: "h1", Container: "c1", Msg: "msg1", Facility: 1, Severity: core.SevEmerg}, true},
+		{core.LogEntry{Host: "h1", Container: "c1", Msg: "msg1", Facility: 1, Severity: core.SevInfo}, false},
+		{core.LogEntry{Host: "h1", Container: "c1", Msg: "msg1"}, false}, // priority not set
+	}
+	for i, tt := range tbl {
+		line, ok := c.makeOutLine(tt.ent)
+		require.True(t, ok, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.red, strings.Contains(line, hiRed("msg1")), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
 func TestLastID(t *testing.T) {
 
 	var count int64
@@ -219,6 +310,46 @@ func TestCliFindFollow(t *testing.T) {
 	assert.Equal(t, int64(6), atomic.LoadInt64(&count), "called 6 times by repeater")
 }
 
+func TestCliFindFollowStream(t *testing.T) {
+	var finds, streams int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := core.Request{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		switch r.URL.Path {
+		case "/v1/find":
+			recs := []core.LogEntry{}
+			if atomic.AddInt64(&finds, 1) == 1 {
+				recs = append(recs, core.LogEntry{ID: "id1", Host: "h1", Container: "c1", Msg: "msg1"})
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(recs))
+		case "/v1/stream":
+			assert.Equal(t, "1m0s", r.URL.Query().Get("timeout"))
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			if atomic.AddInt64(&streams, 1) > 1 {
+				assert.Equal(t, "id3", req.LastID, "resumed after the last streamed")
+				<-r.Context().Done()
+				return
+			}
+			assert.Equal(t, "id1", req.LastID)
+			require.NoError(t, json.NewEncoder(w).Encode(core.LogEntry{ID: "id2", Host: "h1", Container: "c1", Msg: "msg2"}))
+			require.NoError(t, json.NewEncoder(w).Encode(core.LogEntry{ID: "id3", Host: "h2", Container: "c1", Msg: "msg3"}))
+		}
+	}))
+	defer ts.Close()
+
+	out := bytes.Buffer{}
+	c := NewCLI(APIParams{API: ts.URL + "/v1", Client: &http.Client{}, RepeaterStrategy: &strategy.Once{},
+		UpdateInterval: time.Millisecond}, DisplayParams{Out: &out, FollowMode: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*300, cancel)
+	req, err := c.Activate(ctx, core.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, "h1:c1 - msg1\nh1:c1 - msg2\nh2:c1 - msg3\n", out.String())
+	assert.Equal(t, int64(3), atomic.LoadInt64(&finds), "catch-up before each stream")
+	assert.Equal(t, int64(2), atomic.LoadInt64(&streams))
+	assert.Equal(t, "id3", req.LastID)
+}
+
 func TestCliFindFollowWithDelay(t *testing.T) {
 	var count int64
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -3,8 +3,10 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"log/syslog"
 	"os"
 	"strings"
 	"time"
@@ -17,6 +19,7 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/umputun/dkll/app/agent"
+	"github.com/umputun/dkll/app/core"
 )
 
 // AgentOpts holds all flags and env for agent mode
@@ -26,7 +29,12 @@ type AgentOpts struct {
 	EnableSyslog bool   `long:"syslog" env:"LOG_SYSLOG" description:"enable logging to syslog"`
 	SyslogHost   string `long:"syslog-host" env:"SYSLOG_HOST" default:"127.0.0.1:514" description:"syslog host"`
 	SyslogPrefix string `long:"syslog-prefix" env:"SYSLOG_PREFIX" default:"docker/" description:"syslog prefix"`
-	SyslogProt   string `long:"syslog-proto" env:"SYSLOG_PROTO" default:"udp4" description:"syslog protocol"`
+	SyslogProt   string `long:"syslog-proto" env:"SYSLOG_PROTO" default:"udp4" description:"syslog protocol, udp4, tcp or tls"`
+
+	SyslogTLSCA         string `long:"syslog-tls-ca" env:"SYSLOG_TLS_CA" description:"CA bundle to verify syslog server certificate"`
+	SyslogTLSCert       string `long:"syslog-tls-cert" env:"SYSLOG_TLS_CERT" description:"syslog client certificate file"`
+	SyslogTLSKey        string `long:"syslog-tls-key" env:"SYSLOG_TLS_KEY" description:"syslog client certificate key file"`
+	SyslogTLSSkipVerify bool   `long:"syslog-tls-skip-verify" env:"SYSLOG_TLS_SKIP_VERIFY" description:"skip syslog server certificate verification"`
 
 	EnableFiles   bool   `long:"files" env:"LOG_FILES" description:"enable logging to files"`
 	MaxFileSize   int    `long:"max-size" env:"MAX_SIZE" default:"10" description:"size of log triggering rotation (MB)"`
@@ -40,6 +48,7 @@ type AgentOpts struct {
 	ExtJSON      bool          `short:"j" long:"json" env:"JSON" description:"wrap message with JSON envelope"`
 	DemoMode     bool          `long:"demo" env:"DEMO" description:"demo mode, generates simulated log entries"`
 	DemoRecEvery time.Duration `long:"demo-every" env:"DEMO_EVERY" default:"3s" description:"demo interval"`
+	Multiline    []string      `long:"multiline" env:"MULTILINE" description:"multiline rule, container=name;start=regex;cont=regex;wait=1s"`
 }
 
 // AgentCmd wraps agent mode
@@ -60,6 +69,16 @@ func (a AgentCmd) Run(ctx context.Context) error {
 		log.Printf("[WARN] running agent in demo mode")
 	}
 
+	if _, err := makeMultilineRules(a.Multiline); err != nil {
+		return err
+	}
+
+	if a.EnableSyslog && a.SyslogProt == "tls" {
+		if _, err := makeClientTLS(a.SyslogTLSCA, a.SyslogTLSCert, a.SyslogTLSKey, a.SyslogTLSSkipVerify); err != nil {
+			return errors.Wrap(err, "bad syslog tls options")
+		}
+	}
+
 	loop, err := a.makeEventLoop(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to make event loop")
@@ -122,7 +141,7 @@ func (a AgentCmd) makeLogWriters(_ context.Context, containerName, group string)
 	}
 
 	if a.EnableSyslog {
-		syslogWriter, errFileWriter, err := a.makeSyslogWriters(containerName)
+		syslogWriter, errFileWriter, err := a.makeSyslogWriters(containerName, group)
 
 		if err != nil {
 			syslogErr = err
@@ -147,9 +166,31 @@ func (a AgentCmd) makeLogWriters(_ context.Context, containerName, group string)
 		return nil, nil, errors.Errorf("all log writers failed, %+v", errs.Error())
 	}
 
+	rules, err := makeMultilineRules(a.Multiline)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rule, ok := core.FindMultilineRule(rules, containerName); ok {
+		log.Printf("[DEBUG] multiline joining enabled for %s", containerName)
+		return agent.NewMultilineWriter(lw, rule), agent.NewMultilineWriter(ew, rule), nil
+	}
+
 	return lw, ew, nil
 }
 
+// makeMultilineRules parses multiline rules, shared by agent and server
+func makeMultilineRules(rules []string) ([]core.MultilineRule, error) {
+	res := make([]core.MultilineRule, 0, len(rules))
+	for _, m := range rules {
+		rule, err := core.ParseMultilineRule(m)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad multiline rule")
+		}
+		res = append(res, rule)
+	}
+	return res, nil
+}
+
 func (a AgentCmd) makeFileWriters(containerName, group string) (logWriter, errWriter io.WriteCloser, err error) {
 	logDir := a.FilesLocation
 	if group != "" {
@@ -189,12 +230,42 @@ func (a AgentCmd) makeFileWriters(containerName, group string) (logWriter, errWr
 	return logFileWriter, errFileWriter, nil
 }
 
-func (a AgentCmd) makeSyslogWriters(containerName string) (logWriter, errWriter io.WriteCloser, err error) {
+// makeSyslogWriters creates syslog writers for out and err. Tag is prefix+group/container, i.e. "docker/db/mongo",
+// or prefix+container if group not defined. Protocols "tcp" and "tls" send octet-counted messages (RFC 6587, RFC 5425),
+// so multiline events are not split by the server.
+func (a AgentCmd) makeSyslogWriters(containerName, group string) (logWriter, errWriter io.WriteCloser, err error) {
+	tag := a.SyslogPrefix + containerName
+	if group != "" {
+		tag = a.SyslogPrefix + group + "/" + containerName
+	}
+
+	if a.SyslogProt == "tls" || strings.HasPrefix(a.SyslogProt, "tcp") {
+		network := a.SyslogProt
+		var tlsConfig *tls.Config
+		if a.SyslogProt == "tls" {
+			network = "tcp"
+			if tlsConfig, err = makeClientTLS(a.SyslogTLSCA, a.SyslogTLSCert, a.SyslogTLSKey, a.SyslogTLSSkipVerify); err != nil {
+				return nil, nil, err
+			}
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+			}
+		}
+		if logWriter, err = agent.NewStreamSyslogWriter(network, a.SyslogHost, tlsConfig, syslog.LOG_DAEMON|syslog.LOG_INFO, tag); err != nil {
+			return nil, nil, err
+		}
+		if errWriter, err = agent.NewStreamSyslogWriter(network, a.SyslogHost, tlsConfig, syslog.LOG_DAEMON|syslog.LOG_ERR, tag); err != nil {
+			_ = logWriter.Close()
+			return nil, nil, err
+		}
+		return logWriter, errWriter, nil
+	}
+
 	errs := new(multierror.Error)
-	logWriter, err = gsyslog.DialLogger(a.SyslogProt, a.SyslogHost, gsyslog.LOG_INFO, "DAEMON", a.SyslogPrefix+containerName)
+	logWriter, err = gsyslog.DialLogger(a.SyslogProt, a.SyslogHost, gsyslog.LOG_INFO, "DAEMON", tag)
 	errs = multierror.Append(errs, err)
 
-	errWriter, err = gsyslog.DialLogger(a.SyslogProt, a.SyslogHost, gsyslog.LOG_ERR, "DAEMON", a.SyslogPrefix+containerName)
+	errWriter, err = gsyslog.DialLogger(a.SyslogProt, a.SyslogHost, gsyslog.LOG_ERR, "DAEMON", tag)
 	errs = multierror.Append(errs, err)
 	return logWriter, errWriter, errs.ErrorOrNil()
 }
@@ -1,19 +1,22 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"log"
-	"net"
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
-	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+	"github.com/umputun/dkll/app/server"
 )
 
 func Test_Run(t *testing.T) {
@@ -86,6 +89,33 @@ func Test_makeLogWriters(t *testing.T) {
 	assert.NoError(t, errWr.Close())
 }
 
+func Test_makeLogWritersMultiline(t *testing.T) {
+	defer os.RemoveAll("/tmp/logger.test")
+
+	opts := AgentOpts{FilesLocation: "/tmp/logger.test", EnableFiles: true, MaxFileSize: 1, MaxFilesCount: 10,
+		Multiline: []string{"container=container1;cont=^\\s+;wait=50ms"}}
+	a := AgentCmd{AgentOpts: opts}
+	stdWr, errWr, err := a.makeLogWriters(context.Background(), "container1", "gr1")
+	require.NoError(t, err)
+
+	_, err = stdWr.Write([]byte("panic: boom\n"))
+	assert.NoError(t, err)
+	_, err = stdWr.Write([]byte("\tmain.go:5\n"))
+	assert.NoError(t, err)
+	_, err = stdWr.Write([]byte("next line\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, stdWr.Close())
+	assert.NoError(t, errWr.Close())
+
+	r, err := os.ReadFile("/tmp/logger.test/gr1/container1.log")
+	assert.NoError(t, err)
+	assert.Equal(t, "panic: boom\n\tmain.go:5\nnext line\n", string(r))
+
+	a.Multiline = []string{"container=container1"}
+	_, _, err = a.makeLogWriters(context.Background(), "container1", "gr1")
+	assert.EqualError(t, err, `bad multiline rule: multiline rule "container=container1" needs start or cont regex`)
+}
+
 func Test_makeLogWritersMixed(t *testing.T) {
 	defer os.RemoveAll("/tmp/logger.test")
 
@@ -169,122 +199,89 @@ func Test_makeLogWritersSyslogPassed(t *testing.T) {
 func Test_makeLogWritersSyslogTCP(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	time.AfterFunc(1*time.Second, cancel)
 
-	var buf syncedBuffer
-	var wg sync.WaitGroup
-	startTCPServer(ctx, t, 5514, &wg, &buf)
+	s := server.Syslog{Port: 5514}
+	ch, err := s.Go(ctx)
+	require.NoError(t, err)
 
-	opts := AgentOpts{EnableSyslog: true, SyslogHost: "127.0.0.1:5514", SyslogProt: "tcp", SyslogPrefix: "docker/"}
+	opts := AgentOpts{EnableSyslog: true, SyslogHost: "127.0.0.1:5514", SyslogProt: "tcp", SyslogPrefix: "docker/",
+		Multiline: []string{"container=container1;cont=^\\s+;wait=50ms"}}
 	a := AgentCmd{AgentOpts: opts}
 
 	stdWr, errWr, err := a.makeLogWriters(ctx, "container1", "gr1")
 	require.NoError(t, err)
 	assert.NotEqual(t, stdWr, errWr, "not the same writer for out and err in syslog")
+	defer stdWr.Close() // nolint
+	defer errWr.Close() // nolint
+
+	next := func() server.RawMessage {
+		select {
+		case msg := <-ch:
+			return msg
+		case <-time.After(time.Second):
+			require.Fail(t, "no message")
+		}
+		return server.RawMessage{}
+	}
 
-	// write to out writer
 	_, err = stdWr.Write([]byte("abc line 1\n"))
-	assert.NoError(t, err)
-	_, err = stdWr.Write([]byte("xxx123 line 2\n"))
-	assert.NoError(t, err)
+	require.NoError(t, err)
+	msg := next()
+	assert.True(t, strings.HasPrefix(msg.Line, "<30>"), msg.Line)
+	assert.Contains(t, msg.Line, "docker/gr1/container1[")
+	assert.True(t, strings.HasSuffix(msg.Line, ": abc line 1"), msg.Line)
 
-	time.Sleep(100 * time.Millisecond)
+	// multiline event delivered as a single message
+	_, err = errWr.Write([]byte("panic: boom\n\tmain.go:5\n\tmain.go:10\nnext line\n"))
+	require.NoError(t, err)
+	msg = next()
+	assert.True(t, strings.HasPrefix(msg.Line, "<27>"), msg.Line)
+	assert.True(t, strings.HasSuffix(msg.Line, ": panic: boom\n\tmain.go:5\n\tmain.go:10"), msg.Line)
+	ent, err := core.NewEntry(msg.Line, time.Local)
+	require.NoError(t, err)
+	assert.Equal(t, "panic: boom\n\tmain.go:5\n\tmain.go:10", ent.Msg)
+	assert.Equal(t, "container1", ent.Container)
+	assert.True(t, strings.HasSuffix(next().Line, ": next line"), "pending event flushed on wait")
+}
 
-	// write to err writer
-	_, err = errWr.Write([]byte("err line 1\n"))
-	assert.NoError(t, err)
-	_, err = errWr.Write([]byte("err xxx123 line 2345\n"))
-	assert.NoError(t, err)
+func Test_makeLogWritersSyslogTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	wg.Wait()
-	t.Log(buf.String())
-	res := strings.Split(buf.String(), "\n")
-	assert.Equal(t, 5, len(res), "4 messages + final eol")
-	assert.Contains(t, res[0], "<30>")
-	assert.Contains(t, res[0], "docker/container1")
-	assert.Contains(t, res[0], ": abc line 1")
-	assert.Contains(t, res[3], "<27>")
-	assert.Contains(t, res[3], "docker/container1")
-	assert.Contains(t, res[3], ": err xxx123 line 2345")
-}
+	ts := httptest.NewTLSServer(http.NotFoundHandler()) // provides certificate for 127.0.0.1
+	defer ts.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600))
 
-func startTCPServer(ctx context.Context, t *testing.T, port int, wg *sync.WaitGroup, buf *syncedBuffer) {
+	s := server.Syslog{Port: 15512, TLSPort: 16515, TLS: &tls.Config{Certificates: ts.TLS.Certificates, MinVersion: tls.VersionTLS12}}
+	ch, err := s.Go(ctx)
+	require.NoError(t, err)
 
-	closed := func() bool {
-		select {
-		case <-ctx.Done():
-			return true
-		default:
-			return false
-		}
-	}
+	opts := AgentOpts{EnableSyslog: true, SyslogHost: "127.0.0.1:16515", SyslogProt: "tls", SyslogPrefix: "docker/", SyslogTLSCA: caFile}
+	a := AgentCmd{AgentOpts: opts}
+	stdWr, errWr, err := a.makeLogWriters(ctx, "container1", "gr1")
+	require.NoError(t, err)
+	defer stdWr.Close() // nolint
+	defer errWr.Close() // nolint
 
-	log.Print("start test server on ", port)
-
-	wg.Go(func() {
-		ts, err := net.Listen("tcp4", fmt.Sprintf("localhost:%d", port))
-		require.NoError(t, err)
-		log.Print("test server listen on ", port)
-
-		go func() {
-			<-ctx.Done()
-			e := ts.Close()
-			require.NoError(t, e)
-		}()
-
-		for {
-			var conn net.Conn
-			if closed() {
-				ts.Close() // nolint
-				return
-			}
-			log.Print("listen to accept")
-			if conn, err = ts.Accept(); err != nil {
-				break
-			}
-
-			log.Printf("connection accepted from %v", conn.RemoteAddr())
-			go func(conn net.Conn) {
-				defer conn.Close() // nolint
-				for {
-					select {
-					case <-ctx.Done():
-						log.Print("canceled test server")
-						return
-					case <-time.After(1 * time.Millisecond):
-						require.NoError(t, conn.SetDeadline(time.Now().Add(time.Millisecond*100)))
-						b := make([]byte, 1500)
-
-						l, err := conn.Read(b)
-						if err != nil {
-							continue
-						}
-						if _, e := buf.Write(b[:l]); e == nil {
-							log.Printf("> wrote %s", string(b[:l]))
-						} else {
-							log.Printf("! write failed %s, %v", string(b[:l]), e)
-						}
-					}
-				}
-			}(conn)
-		}
-	})
-	time.Sleep(50 * time.Millisecond)
-}
+	_, err = stdWr.Write([]byte("abc line 1\n"))
+	require.NoError(t, err)
+	msg := <-ch
+	assert.True(t, strings.HasPrefix(msg.Line, "<30>"), msg.Line)
+	assert.Contains(t, msg.Line, "docker/gr1/container1[")
+	assert.True(t, strings.HasSuffix(msg.Line, ": abc line 1"), msg.Line)
 
-type syncedBuffer struct {
-	buff bytes.Buffer
-	sync.Mutex
-}
+	_, err = errWr.Write([]byte("err line 1\n"))
+	require.NoError(t, err)
+	msg = <-ch
+	assert.True(t, strings.HasPrefix(msg.Line, "<27>"), msg.Line)
+	assert.True(t, strings.HasSuffix(msg.Line, ": err line 1"), msg.Line)
 
-func (b *syncedBuffer) Write(data []byte) (int, error) {
-	b.Lock()
-	defer b.Unlock()
-	return b.buff.Write(data)
-}
+	a.SyslogTLSCA = ""
+	_, _, err = a.makeSyslogWriters("container1", "gr1")
+	require.Error(t, err, "server certificate not trusted")
+	assert.Contains(t, err.Error(), "certificate signed by unknown authority")
 
-func (b *syncedBuffer) String() string {
-	b.Lock()
-	defer b.Unlock()
-	return b.buff.String()
+	a.SyslogTLSCert = caFile
+	assert.EqualError(t, a.Run(ctx), "bad syslog tls options: both tls certificate and key required")
 }
@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
+	"os"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
 
 	"github.com/umputun/dkll/app/client"
 	"github.com/umputun/dkll/app/core"
@@ -14,17 +18,28 @@ import (
 // ClientOpts holds all flags and env for client mode
 type ClientOpts struct {
 	API        string   `short:"a" long:"api" env:"DKLL_API" required:"true" description:"API endpoint (client)"`
+	Token      string   `long:"token" env:"DKLL_TOKEN" description:"API token"`
+	TLSCA      string   `long:"tls-ca" env:"DKLL_TLS_CA" description:"CA bundle to verify server certificate"`
+	TLSCert    string   `long:"tls-cert" env:"DKLL_TLS_CERT" description:"client certificate file"`
+	TLSKey     string   `long:"tls-key" env:"DKLL_TLS_KEY" description:"client certificate key file"`
+	SkipVerify bool     `long:"tls-skip-verify" env:"DKLL_TLS_SKIP_VERIFY" description:"skip server certificate verification"`
 	Containers []string `short:"c" description:"show container(s) only"`
 	Hosts      []string `short:"h" description:"show host(s) only"`
 	Excludes   []string `short:"x" description:"exclude container(s)"`
+	Groups     []string `long:"group" description:"show group(s) only"`
 	ShowTS     bool     `short:"m" description:"show syslog timestamp"`
 	ShowPid    bool     `short:"p" description:"show pid"`
 	ShowSyslog bool     `short:"s" description:"show syslog messages"`
 	FollowMode bool     `short:"f" description:"follow mode"`
 	TailMode   bool     `short:"t" description:"tail mode"`
 	MaxRecs    int      `short:"n" description:"show N records"`
-	Grep       []string `short:"g" description:"grep on entire record"`
-	UnGrep     []string `short:"G" description:"un-grep on entire record"`
+	Grep       []string `short:"g" description:"grep on message, substring or /regex/"`
+	UnGrep     []string `short:"G" description:"un-grep on message, substring or /regex/"`
+	Search     string   `long:"search" description:"full text search on message"`
+	Query      string   `short:"q" long:"query" description:"query, i.e. host=~/^web/ container!=monit since:1h"`
+	Severity   string   `long:"severity" description:"show records with this or higher severity only, i.e. err or warning"`
+	Where      []string `short:"w" long:"where" description:"field filter, i.e. level=error, user_id!=42 or level=/err/"`
+	Columns    []string `long:"col" description:"show field(s) as columns"`
 	TimeZone   string   `long:"tz"  default:"Local" description:"time zone"`
 }
 
@@ -47,11 +62,41 @@ func (c ClientCmd) Run(ctx context.Context) error {
 		return time.Local
 	}
 
+	if c.Severity != "" {
+		if _, err := core.ParseSeverity(c.Severity); err != nil {
+			return err
+		}
+	}
+
+	for _, g := range append(append([]string{}, c.Grep...), c.UnGrep...) {
+		if _, err := core.GrepPattern(g); err != nil {
+			return err
+		}
+	}
+
+	for _, w := range c.Where {
+		if _, err := core.ParseFieldFilter(w); err != nil {
+			return err
+		}
+	}
+
 	request := core.Request{
-		Limit:      c.MaxRecs,
-		Containers: c.Containers,
-		Hosts:      c.Hosts,
-		Excludes:   c.Excludes,
+		Limit:       c.MaxRecs,
+		Containers:  c.Containers,
+		Hosts:       c.Hosts,
+		Excludes:    c.Excludes,
+		Groups:      c.Groups,
+		MinSeverity: c.Severity,
+		Fields:      c.Where,
+		Grep:        c.Grep,
+		UnGrep:      c.UnGrep,
+		Search:      c.Search,
+	}
+	if c.Query != "" {
+		var err error
+		if request, err = request.WithQuery(c.Query); err != nil {
+			return err
+		}
 	}
 
 	display := client.DisplayParams{
@@ -60,17 +105,60 @@ func (c ClientCmd) Run(ctx context.Context) error {
 		FollowMode: c.FollowMode,
 		TailMode:   c.TailMode,
 		ShowSyslog: c.ShowSyslog,
-		Grep:       c.Grep,
-		UnGrep:     c.UnGrep,
+		Columns:    c.Columns,
 		TimeZone:   tz(),
 	}
 
+	tlsConfig, err := makeClientTLS(c.TLSCA, c.TLSCert, c.TLSKey, c.SkipVerify)
+	if err != nil {
+		return err
+	}
+	httpClient := &http.Client{}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient.Transport = transport
+	}
+
 	api := client.APIParams{
 		API:            c.API,
 		UpdateInterval: time.Second,
-		Client:         &http.Client{},
+		Client:         httpClient,
+		Token:          c.Token,
 	}
 	cli := client.NewCLI(api, display)
-	_, err := cli.Activate(ctx, request)
+	_, err = cli.Activate(ctx, request)
 	return err
 }
+
+// makeClientTLS makes tls config with optional CA bundle and client certificate, nil if nothing set.
+// Shared by client and agent.
+func makeClientTLS(caFile, certFile, keyFile string, skipVerify bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" && !skipVerify {
+		return nil, nil
+	}
+	res := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: skipVerify} //nolint:gosec // skip verify by user's request
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile) //nolint:gosec // file name from options
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read CA %s", caFile)
+		}
+		res.RootCAs = x509.NewCertPool()
+		if !res.RootCAs.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates in CA %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("both tls certificate and key required")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't load certificate %s", certFile)
+		}
+		res.Certificates = []tls.Certificate{cert}
+	}
+	return res, nil
+}
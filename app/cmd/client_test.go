@@ -3,10 +3,13 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -48,6 +51,87 @@ func TestClient(t *testing.T) {
 	assert.Equal(t, exp, string(out))
 }
 
+func TestClientGrepPushDown(t *testing.T) {
+	var req core.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+
+	c := ClientCmd{ClientOpts{API: ts.URL + "/v1", Grep: []string{"req-123", "/err.*/"}, UnGrep: []string{"health"}, Search: "timeout"}}
+	require.NoError(t, c.Run(context.Background()))
+	assert.Equal(t, []string{"req-123", "/err.*/"}, req.Grep)
+	assert.Equal(t, []string{"health"}, req.UnGrep)
+	assert.Equal(t, "timeout", req.Search)
+
+	c = ClientCmd{ClientOpts{API: ts.URL + "/v1", Grep: []string{"/[bad/"}}}
+	assert.Error(t, c.Run(context.Background()))
+}
+
+func TestClientQuery(t *testing.T) {
+	var req core.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+
+	c := ClientCmd{ClientOpts{API: ts.URL + "/v1", Hosts: []string{"h1"}, Query: `host=~/^web/ container!=monit msg:"time out"`}}
+	require.NoError(t, c.Run(context.Background()))
+	assert.Equal(t, []string{"h1", "/^web/"}, req.Hosts)
+	assert.Equal(t, []string{"monit"}, req.Excludes)
+	assert.Equal(t, []string{"time out"}, req.Grep)
+	assert.Equal(t, "", req.Query, "query applied by client")
+
+	c = ClientCmd{ClientOpts{API: ts.URL + "/v1", Query: "host=h1 since:bad"}}
+	err := c.Run(context.Background())
+	assert.EqualError(t, err, `query error at 14: bad time "bad" for since, expected duration (1h, 2d) or time (2006-01-02, RFC3339)`)
+}
+
+func TestClientTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	caFile, badFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "bad.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600))
+	require.NoError(t, os.WriteFile(badFile, []byte("bad"), 0o600))
+
+	lgr.Setup(lgr.Out(io.Discard))
+	defer lgr.Setup(lgr.Out(os.Stdout))
+
+	tbl := []struct {
+		opts ClientOpts
+		err  string
+	}{
+		{ClientOpts{TLSCA: caFile}, ""},
+		{ClientOpts{SkipVerify: true}, ""},
+		{ClientOpts{TLSCA: filepath.Join(dir, "nope.pem")}, "can't read CA"},
+		{ClientOpts{TLSCA: badFile}, "no certificates in CA"},
+		{ClientOpts{TLSCA: caFile, TLSCert: caFile}, "both tls certificate and key required"},
+		{ClientOpts{TLSCA: caFile, TLSCert: badFile, TLSKey: badFile}, "can't load certificate"},
+	}
+
+	for i, tt := range tbl {
+		tt.opts.API = ts.URL + "/v1"
+		err := ClientCmd{tt.opts}.Run(context.Background())
+		if tt.err != "" {
+			require.Error(t, err, fmt.Sprintf("mismatch in #%d", i))
+			assert.Contains(t, err.Error(), tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	// server's certificate not trusted without CA, client retries till ctx done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, ClientCmd{ClientOpts{API: ts.URL + "/v1"}}.Run(ctx))
+}
+
 func prepTestServer(t *testing.T) *httptest.Server {
 	var count int64
 
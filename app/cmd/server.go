@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
@@ -22,16 +24,58 @@ import (
 type ServerOpts struct {
 	Port               int           `long:"api-port" env:"API_PORT" default:"8080" description:"rest server port"`
 	SyslogPort         int           `long:"syslog-port" env:"SYSLOG_PORT" default:"5514" description:"syslog server port"`
-	MongoURL           string        `long:"mongo" env:"MONGO" required:"true" description:"mongo URL"`
+	SyslogMaxSize      int           `long:"syslog-max-size" env:"SYSLOG_MAX_SIZE" default:"65536" description:"max syslog message size, longer truncated"`
+	GelfPort           int           `long:"gelf-port" env:"GELF_PORT" description:"gelf udp and tcp port, disabled if not set"`
+	GelfMaxSize        int           `long:"gelf-max-size" env:"GELF_MAX_SIZE" default:"1048576" description:"max gelf message size, longer rejected"`
+	FluentPort         int           `long:"fluent-port" env:"FLUENT_PORT" description:"fluent forward tcp port, disabled if not set"`
+	FluentMaxSize      int           `long:"fluent-max-size" env:"FLUENT_MAX_SIZE" default:"16777216" description:"max fluent forward message size, longer rejected"`
+	Store              string        `long:"store" env:"STORE" default:"mongo" description:"store, mongo, memory or local:/path"`
+	MongoURL           string        `long:"mongo" env:"MONGO" description:"mongo URL, required for mongo store"`
 	MongoTimeout       time.Duration `long:"mongo-timeout" env:"MONGO_TIMEOUT" default:"5s" description:"mongo timeout"`
 	MongoMaxSize       int           `long:"mongo-size" env:"MONGO_SIZE" default:"10000000000" description:"max collection size"`
 	MongoMaxDocs       int           `long:"mongo-docs" env:"MONGO_DOCS" default:"50000000" description:"max docs in collection"`
 	FileBackupLocation string        `long:"backup" default:"" env:"BACK_LOG" description:"backup log files location"`
 	EnableMerged       bool          `long:"merged"  env:"BACK_MRG" description:"enable merged log file"`
+	QueueSize          int           `long:"queue-size" env:"QUEUE_SIZE" default:"10000" description:"size of syslog and forwarder queues"`
+	Overflow           string        `long:"overflow" env:"OVERFLOW" default:"block" description:"full queue policy, block, drop-newest or drop-oldest"`
+	Multiline          []string      `long:"multiline" env:"MULTILINE" description:"multiline rule, container=name;start=regex;cont=regex;wait=1s"`
+	IngestMaxSize      int           `long:"ingest-max-size" env:"INGEST_MAX_SIZE" default:"10" description:"max body size of ingest api, in megabytes"`
 	LogLimits          struct {
 		Container LogLimit `group:"container" namespace:"container" env-namespace:"CONTAINER" description:"container limits"`
 		Merged    LogLimit `group:"merged" namespace:"merged" env-namespace:"MERGED" description:"merged log limits"`
 	} `group:"limit" namespace:"limit" env-namespace:"LIMIT"`
+	Local struct {
+		MaxSize     int           `long:"max-size" env:"MAX_SIZE" default:"10000" description:"max size of local store, in megabytes"`
+		MaxAge      time.Duration `long:"max-age" env:"MAX_AGE" default:"0s" description:"max age of local store records, 0 for unlimited"`
+		SegmentSize int           `long:"segment-size" env:"SEGMENT_SIZE" default:"64" description:"local store segment size, in megabytes"`
+	} `group:"local" namespace:"local" env-namespace:"LOCAL"`
+	Memory struct {
+		MaxRecords int `long:"max-records" env:"MAX_RECORDS" default:"100000" description:"max records in memory store"`
+	} `group:"memory" namespace:"memory" env-namespace:"MEMORY"`
+	Spool struct {
+		Path    string `long:"path" env:"PATH" description:"spool directory for records failed to publish, disabled if empty"`
+		MaxSize int    `long:"max-size" env:"MAX_SIZE" default:"1024" description:"max spool size, in megabytes"`
+	} `group:"spool" namespace:"spool" env-namespace:"SPOOL"`
+	Auth struct {
+		File string `long:"file" env:"FILE" description:"file with users and tokens, api requires auth if set"`
+	} `group:"auth" namespace:"auth" env-namespace:"AUTH"`
+	TLS struct {
+		Cert     string `long:"cert" env:"CERT" description:"certificate file, api served over https if set"`
+		Key      string `long:"key" env:"KEY" description:"certificate key file"`
+		ClientCA string `long:"client-ca" env:"CLIENT_CA" description:"CA bundle to verify client certificates, required if set"`
+	} `group:"tls" namespace:"tls" env-namespace:"TLS"`
+	SyslogTLS struct {
+		Port     int    `long:"port" env:"PORT" default:"6514" description:"syslog over tls port"`
+		Cert     string `long:"cert" env:"CERT" description:"certificate file, syslog over tls enabled if set"`
+		Key      string `long:"key" env:"KEY" description:"certificate key file"`
+		ClientCA string `long:"client-ca" env:"CLIENT_CA" description:"CA bundle to verify client certificates, required if set"`
+	} `group:"syslog-tls" namespace:"syslog-tls" env-namespace:"SYSLOG_TLS"`
+	Sender struct {
+		Allow   []string `long:"allow" env:"ALLOW" env-delim:"," description:"allowed syslog, gelf and fluent sender networks, i.e. 10.0.0.0/8"`
+		Deny    []string `long:"deny" env:"DENY" env-delim:"," description:"denied syslog, gelf and fluent sender networks"`
+		Unknown string   `long:"unknown" env:"UNKNOWN" default:"reject" description:"what to do with sender not allowed, reject or tag"`
+		Resolve bool     `long:"resolve" env:"RESOLVE" description:"replace missing or localhost host with reverse dns of sender"`
+	} `group:"sender" namespace:"sender" env-namespace:"SENDER"`
 }
 
 // LogLimit hold params limiting log size and age
@@ -58,47 +102,176 @@ func (s ServerCmd) Run(ctx context.Context) error {
 		return err
 	}
 
-	mclient, ex, err := makeMongoClient(s.MongoURL, s.MongoTimeout)
+	multiline, err := makeMultilineRules(s.Multiline)
 	if err != nil {
-		return errors.Wrap(err, "can't make mongo client")
+		return err
 	}
-	if _, ok := ex["db"].(string); !ok {
-		return errors.New("can't find db in mongo url")
+
+	overflow, err := server.ParseOverflowPolicy(s.Overflow)
+	if err != nil {
+		return err
 	}
-	if _, ok := ex["collection"].(string); !ok {
-		return errors.New("can't find collection in mongo url")
+
+	senders, err := s.makeSenderFilter()
+	if err != nil {
+		return err
 	}
-	mgParams := server.MongoParams{DBName: ex["db"].(string), Collection: ex["collection"].(string),
-		MaxDocs: s.MongoMaxDocs, MaxCollectionSize: s.MongoMaxSize}
-	mg, err := server.NewMongo(mclient, mgParams)
+
+	store, err := s.makeStore()
 	if err != nil {
 		return err
 	}
-	log.Printf("[DEBUG] mongo prepared")
+	if closer, ok := store.(io.Closer); ok {
+		defer func() {
+			if e := closer.Close(); e != nil {
+				log.Printf("[WARN] failed to close store, %v", e)
+			}
+		}()
+	}
+
+	syslogTLS, err := makeServerTLS(ctx, s.SyslogTLS.Cert, s.SyslogTLS.Key, s.SyslogTLS.ClientCA)
+	if err != nil {
+		return errors.Wrap(err, "syslog tls")
+	}
+
+	stats, hub := &server.Stats{}, server.NewHub(0)
+	forwarder := server.Forwarder{
+		Publisher: store,
+		Syslog: &server.Syslog{Port: s.SyslogPort, TLSPort: s.SyslogTLS.Port, TLS: syslogTLS,
+			MaxMessageSize: s.SyslogMaxSize, QueueSize: s.QueueSize, Overflow: overflow, Stats: stats, Senders: senders},
+		FileWriter: server.NewFileLogger(containerLogFactory, mergeLogWriter),
+		Multiline:  multiline,
+		QueueSize:  s.QueueSize,
+		Overflow:   overflow,
+		Stats:      stats,
+		Hub:        hub,
+		Resolve:    s.Sender.Resolve,
+	}
+	if s.GelfPort != 0 {
+		forwarder.Inputs = append(forwarder.Inputs, &server.Gelf{Port: s.GelfPort, MaxMessageSize: s.GelfMaxSize,
+			QueueSize: s.QueueSize, Overflow: overflow, Stats: stats, Senders: senders})
+	}
+	if s.FluentPort != 0 {
+		forwarder.Inputs = append(forwarder.Inputs, &server.Fluent{Port: s.FluentPort, MaxMessageSize: s.FluentMaxSize,
+			QueueSize: s.QueueSize, Overflow: overflow, Stats: stats, Senders: senders})
+	}
+
+	if s.Spool.Path != "" {
+		if forwarder.Spool, err = server.NewSpool(server.SpoolParams{Path: s.Spool.Path,
+			MaxSize: int64(s.Spool.MaxSize) * 1024 * 1024}); err != nil {
+			return err
+		}
+	}
 
 	restServer := server.RestServer{
-		Port:        s.Port,
-		DataService: mg,
-		Limit:       100,
-		Version:     s.Revision,
+		Port:          s.Port,
+		DataService:   store,
+		Limit:         100,
+		Version:       s.Revision,
+		Stats:         stats,
+		Hub:           hub,
+		Ingest:        &forwarder,
+		IngestMaxSize: int64(s.IngestMaxSize) * 1024 * 1024,
 	}
+	if s.Auth.File != "" {
+		if restServer.Auth, err = server.LoadAuth(s.Auth.File); err != nil {
+			return err
+		}
+	}
+	if restServer.TLS, err = makeServerTLS(ctx, s.TLS.Cert, s.TLS.Key, s.TLS.ClientCA); err != nil {
+		return errors.Wrap(err, "api tls")
+	}
+	forwarder.Rejects = server.NewMemoryRejects(0) // memory and local stores don't keep rejected lines
+	if rs, ok := store.(server.RejectStore); ok {
+		forwarder.Rejects = rs
+	}
+	restServer.Rejects = &forwarder
 	go func() {
 		if httpErr := restServer.Run(ctx); httpErr != nil {
 			log.Printf("[WARN] rest server terminated, %v", httpErr)
 		}
 	}()
 
-	forwarder := server.Forwarder{
-		Publisher:  mg,
-		Syslog:     &server.Syslog{Port: s.SyslogPort},
-		FileWriter: server.NewFileLogger(containerLogFactory, mergeLogWriter),
-	}
-
 	log.Printf("[WARN] forwarder terminated, %v", forwarder.Run(ctx)) // blocking on forwarder
 
 	return nil
 }
 
+// makeSenderFilter makes filter of syslog senders, nil if no networks allowed or denied
+func (s ServerCmd) makeSenderFilter() (*server.SenderFilter, error) {
+	policy, err := server.ParseSenderPolicy(s.Sender.Unknown)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Sender.Allow) == 0 && len(s.Sender.Deny) == 0 {
+		return nil, nil
+	}
+	res := &server.SenderFilter{Unknown: policy}
+	if res.Allow, err = server.ParseCIDRs(s.Sender.Allow); err != nil {
+		return nil, errors.Wrap(err, "bad allowed senders")
+	}
+	if res.Deny, err = server.ParseCIDRs(s.Sender.Deny); err != nil {
+		return nil, errors.Wrap(err, "bad denied senders")
+	}
+	return res, nil
+}
+
+// makeServerTLS makes tls config for api or syslog, nil if no certificate set
+func makeServerTLS(ctx context.Context, cert, key, clientCA string) (*tls.Config, error) {
+	if cert == "" && key == "" {
+		if clientCA != "" {
+			return nil, errors.New("tls client CA requires certificate and key")
+		}
+		return nil, nil
+	}
+	if cert == "" || key == "" {
+		return nil, errors.New("both tls certificate and key required")
+	}
+	return server.NewServerTLS(ctx, server.TLSParams{CertFile: cert, KeyFile: key, ClientCAFile: clientCA})
+}
+
+// dataStore used by forwarder to publish records and by rest server to find them
+type dataStore interface {
+	server.Publisher
+	server.DataService
+}
+
+// makeStore makes store defined by --store, mongo, memory or local:/path
+func (s ServerCmd) makeStore() (dataStore, error) {
+	switch {
+	case s.Store == "" || s.Store == "mongo":
+		mclient, ex, err := makeMongoClient(s.MongoURL, s.MongoTimeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't make mongo client")
+		}
+		if _, ok := ex["db"].(string); !ok {
+			return nil, errors.New("can't find db in mongo url")
+		}
+		if _, ok := ex["collection"].(string); !ok {
+			return nil, errors.New("can't find collection in mongo url")
+		}
+		mgParams := server.MongoParams{DBName: ex["db"].(string), Collection: ex["collection"].(string),
+			MaxDocs: s.MongoMaxDocs, MaxCollectionSize: s.MongoMaxSize}
+		mg, err := server.NewMongo(mclient, mgParams)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[DEBUG] mongo prepared")
+		return mg, nil
+	case s.Store == "memory":
+		return server.NewMemory(s.Memory.MaxRecords), nil
+	case strings.HasPrefix(s.Store, "local:"):
+		localPath := strings.TrimPrefix(s.Store, "local:")
+		if localPath == "" {
+			return nil, errors.New("no path for local store, expected local:/path")
+		}
+		return server.NewLocal(server.LocalParams{Path: localPath, MaxSize: int64(s.Local.MaxSize) * 1024 * 1024,
+			MaxAge: s.Local.MaxAge, SegmentSize: int64(s.Local.SegmentSize) * 1024 * 1024})
+	default:
+		return nil, errors.Errorf("unknown store %q, expected mongo, memory or local:/path", s.Store)
+	}
+}
+
 func makeMongoClient(mongoURL string, timeout time.Duration) (*mdrv.Client, map[string]any, error) {
 	log.Printf("[DEBUG] make mongo client for %q", mongoURL)
 	if mongoURL == "" {
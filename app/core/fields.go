@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jsonEnvelope is the message wrapper made by agent in --json mode
+type jsonEnvelope struct {
+	Msg       *string   `json:"msg"`
+	Container string    `json:"container"`
+	Group     string    `json:"group"`
+	TS        time.Time `json:"ts"`
+	Host      string    `json:"host"`
+}
+
+// ParseFields extracts key/value fields from JSON object or logfmt message.
+// Nested JSON objects and arrays kept as compact JSON strings. Returns nil if message is neither.
+func ParseFields(msg string) map[string]string {
+	msg = strings.TrimSpace(msg)
+	if strings.HasPrefix(msg, "{") && strings.HasSuffix(msg, "}") {
+		return parseJSONFields(msg)
+	}
+	return parseLogfmtFields(msg)
+}
+
+func parseJSONFields(msg string) map[string]string {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(msg), &obj); err != nil || len(obj) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(obj))
+	for k, raw := range obj {
+		if v, ok := jsonFieldValue(raw); ok {
+			res[fieldKey(k)] = v
+		}
+	}
+	return res
+}
+
+// jsonFieldValue makes field value from json value, string as-is, null as empty string,
+// numbers, bools, objects and arrays as compact json
+func jsonFieldValue(raw json.RawMessage) (string, bool) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	if string(raw) == "null" {
+		return "", true
+	}
+	buf := bytes.Buffer{}
+	if err := json.Compact(&buf, raw); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// parseLogfmtFields parses `k1=v1 k2="v 2"` messages. Every token has to be key=value pair,
+// and at least two pairs required to avoid false positives on plain text like "retry=5 times".
+func parseLogfmtFields(msg string) map[string]string {
+	res := map[string]string{}
+	pos := 0
+	for pos < len(msg) {
+		if msg[pos] == ' ' {
+			pos++
+			continue
+		}
+		eq := strings.IndexAny(msg[pos:], ` ="`)
+		if eq <= 0 || msg[pos+eq] != '=' {
+			return nil
+		}
+		key := msg[pos : pos+eq]
+		pos += eq + 1
+
+		if pos < len(msg) && msg[pos] == '"' { // quoted value
+			val := strings.Builder{}
+			closed := false
+			for pos++; pos < len(msg) && !closed; pos++ {
+				switch {
+				case msg[pos] == '\\' && pos+1 < len(msg):
+					val.WriteByte(msg[pos+1])
+					pos++
+				case msg[pos] == '"':
+					closed = true
+				default:
+					val.WriteByte(msg[pos])
+				}
+			}
+			if !closed || (pos < len(msg) && msg[pos] != ' ') {
+				return nil
+			}
+			res[fieldKey(key)] = val.String()
+			continue
+		}
+
+		end := strings.IndexByte(msg[pos:], ' ')
+		if end < 0 {
+			end = len(msg) - pos
+		}
+		val := msg[pos : pos+end]
+		if strings.ContainsAny(val, `="`) {
+			return nil
+		}
+		res[fieldKey(key)] = val
+		pos += end
+	}
+	if len(res) < 2 {
+		return nil
+	}
+	return res
+}
+
+// fieldKey makes key safe for mongo documents, "." and "$" not allowed
+func fieldKey(k string) string {
+	return strings.NewReplacer(".", "_", "$", "_").Replace(k)
+}
+
+// unwrapJSONEnvelope extracts message from agent's json envelope, i.e. {"msg":"...","container":"c1","group":"g1",...}
+// returns false if msg is not the envelope.
+func unwrapJSONEnvelope(msg string) (env jsonEnvelope, ok bool) {
+	if !strings.HasPrefix(msg, "{") || !strings.Contains(msg, `"msg"`) {
+		return env, false
+	}
+	dec := json.NewDecoder(strings.NewReader(msg))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&env); err != nil || env.Msg == nil || env.TS.IsZero() {
+		return env, false
+	}
+	return env, true
+}
+
+// FieldFilter is a predicate on entry's fields, made from "key=value" or "key!=value".
+// Value can be a regex in form of /regex/
+type FieldFilter struct {
+	Key    string
+	Value  string
+	Negate bool
+}
+
+// ParseFieldFilter makes FieldFilter from "key=value" or "key!=value" string
+func ParseFieldFilter(s string) (FieldFilter, error) {
+	eq := strings.Index(s, "=")
+	if eq <= 0 {
+		return FieldFilter{}, fmt.Errorf("bad field filter %q, expected key=value or key!=value", s)
+	}
+	res := FieldFilter{Key: s[:eq], Value: s[eq+1:]}
+	if strings.HasSuffix(res.Key, "!") {
+		res.Key, res.Negate = strings.TrimSuffix(res.Key, "!"), true
+	}
+	if res.Key == "" || strings.ContainsAny(res.Key, " .$") {
+		return FieldFilter{}, fmt.Errorf("bad field name in filter %q", s)
+	}
+	return res, nil
+}
+
+// IsRegex checks if Value defined as /regex/
+func (f FieldFilter) IsRegex() bool {
+	return len(f.Value) > 1 && strings.HasPrefix(f.Value, "/") && strings.HasSuffix(f.Value, "/")
+}
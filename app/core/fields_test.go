@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFields(t *testing.T) {
+	tbl := []struct {
+		inp string
+		out map[string]string
+	}{
+		{`{"level":"error","user_id":42,"ok":true,"nothing":null}`,
+			map[string]string{"level": "error", "user_id": "42", "ok": "true", "nothing": ""}},
+		{`{"req": {"path": "/api", "code": 200}, "tags": ["a", "b"], "a.b": "c"}`,
+			map[string]string{"req": `{"path":"/api","code":200}`, "tags": `["a","b"]`, "a_b": "c"}},
+		{`  {"level":"info"}  `, map[string]string{"level": "info"}},
+		{`time="2017-05-30T18:03:27-04:00" level=info msg="Firewalld running: false"`,
+			map[string]string{"time": "2017-05-30T18:03:27-04:00", "level": "info", "msg": "Firewalld running: false"}},
+		{`level=warn user_id=42 empty=`, map[string]string{"level": "warn", "user_id": "42", "empty": ""}},
+		{`level=warn msg="with \"quotes\""`, map[string]string{"level": "warn", "msg": `with "quotes"`}},
+		{`level=warn`, nil},
+		{`retry=5 times`, nil},
+		{`level=warn msg="unterminated`, nil},
+		{`level=warn msg="abc"def`, nil},
+		{`a=b=c d=e`, nil},
+		{`2017/10/02 04:05:24.509511 [INFO] logger.go:106: REST GET`, nil},
+		{`{"level":"info"`, nil},
+		{`{}`, nil},
+		{`{not json}`, nil},
+		{``, nil},
+	}
+
+	for i, tt := range tbl {
+		assert.Equal(t, tt.out, ParseFields(tt.inp), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestParseFieldFilter(t *testing.T) {
+	tbl := []struct {
+		inp string
+		out FieldFilter
+		err bool
+	}{
+		{"level=error", FieldFilter{Key: "level", Value: "error"}, false},
+		{"user_id!=42", FieldFilter{Key: "user_id", Value: "42", Negate: true}, false},
+		{"level=/err.*/", FieldFilter{Key: "level", Value: "/err.*/"}, false},
+		{"k=", FieldFilter{Key: "k", Value: ""}, false},
+		{"k=a=b", FieldFilter{Key: "k", Value: "a=b"}, false},
+		{"level", FieldFilter{}, true},
+		{"=error", FieldFilter{}, true},
+		{"!=error", FieldFilter{}, true},
+		{"a.b=c", FieldFilter{}, true},
+		{"$where=c", FieldFilter{}, true},
+	}
+
+	for i, tt := range tbl {
+		res, err := ParseFieldFilter(tt.inp)
+		if tt.err {
+			assert.Error(t, err, fmt.Sprintf("expects error in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("unexpected error in #%d", i))
+		assert.Equal(t, tt.out, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	assert.True(t, FieldFilter{Value: "/err/"}.IsRegex())
+	assert.False(t, FieldFilter{Value: "/"}.IsRegex())
+	assert.False(t, FieldFilter{Value: "err"}.IsRegex())
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// record keys set by docker's fluentd logging driver
+const (
+	fluentLogKey       = "log"
+	fluentContainerKey = "container_name"
+	fluentSourceKey    = "source" // stdout or stderr
+	fluentContainer    = "fluent" // container of events without container_name and tag
+)
+
+// NewEntryFluent makes the LogEntry from fluent forward event, i.e. sent by docker's fluentd logging driver.
+// container_name mapped to container, tag used if missing, and source to severity, info for stdout and err for stderr.
+// Message taken from "log" or "message" key, the whole record as json if none. Host taken from "host" or "hostname"
+// if set, empty otherwise. Other keys, like container_id and source, kept in Fields along with json or logfmt fields
+// of the message, tag kept as "tag" field.
+func NewEntryFluent(tag string, ts time.Time, record map[string]string) LogEntry {
+	entry := LogEntry{Host: record["host"], Container: fluentContainer, TS: ts, CreatedTS: time.Now(),
+		Facility: defFacility, Severity: defSeverity}
+	if entry.Host == "" {
+		entry.Host = record["hostname"]
+	}
+
+	msgKey := ""
+	for _, k := range []string{fluentLogKey, "message"} {
+		if _, ok := record[k]; ok {
+			msgKey = k
+			break
+		}
+	}
+	if msgKey != "" {
+		entry.Msg = strings.TrimRight(record[msgKey], " \t\r\n")
+	} else {
+		data, _ := json.Marshal(record) // can't fail on map of strings
+		entry.Msg = string(data)
+	}
+
+	switch {
+	case record[fluentContainerKey] != "":
+		entry.Container = strings.TrimPrefix(record[fluentContainerKey], "/")
+	case tag != "":
+		entry.Container = tag
+	}
+	switch record[fluentSourceKey] {
+	case "stdout":
+		entry.Severity = SevInfo
+	case "stderr":
+		entry.Severity = SevErr
+	}
+
+	entry.Fields = ParseFields(entry.Msg)
+	for k, v := range record {
+		if k == msgKey || k == fluentContainerKey {
+			continue
+		}
+		if entry.Fields == nil {
+			entry.Fields = map[string]string{}
+		}
+		entry.Fields[fieldKey(k)] = v
+	}
+	if tag != "" {
+		if entry.Fields == nil {
+			entry.Fields = map[string]string{}
+		}
+		entry.Fields["tag"] = tag
+	}
+	return entry
+}
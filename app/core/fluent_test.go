@@ -0,0 +1,51 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEntryFluent(t *testing.T) {
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.UTC)
+
+	tbl := []struct {
+		tag    string
+		record map[string]string
+		out    LogEntry
+	}{
+		{
+			"docker.abc123",
+			map[string]string{"log": "msg1\n", "container_name": "/nginx", "container_id": "abc123", "source": "stdout"},
+			LogEntry{Container: "nginx", Msg: "msg1", TS: ts, Facility: 1, Severity: SevInfo,
+				Fields: map[string]string{"container_id": "abc123", "source": "stdout", "tag": "docker.abc123"}},
+		},
+		{
+			"api",
+			map[string]string{"log": "level=error user=u1", "source": "stderr", "host": "h1", "user": "u2"},
+			LogEntry{Host: "h1", Container: "api", Msg: "level=error user=u1", TS: ts, Facility: 1, Severity: SevErr,
+				Fields: map[string]string{"level": "error", "user": "u2", "source": "stderr", "host": "h1", "tag": "api"}},
+		},
+		{
+			"",
+			map[string]string{"message": "msg2", "hostname": "h2", "req.id": "12"},
+			LogEntry{Host: "h2", Container: "fluent", Msg: "msg2", TS: ts, Facility: 1, Severity: SevNotice,
+				Fields: map[string]string{"hostname": "h2", "req_id": "12"}},
+		},
+		{
+			"app",
+			map[string]string{"status": "200", "path": "/"},
+			LogEntry{Container: "app", Msg: `{"path":"/","status":"200"}`, TS: ts, Facility: 1, Severity: SevNotice,
+				Fields: map[string]string{"status": "200", "path": "/", "tag": "app"}},
+		},
+	}
+
+	for i, tt := range tbl {
+		entry := NewEntryFluent(tt.tag, ts, tt.record)
+		assert.False(t, entry.CreatedTS.IsZero(), fmt.Sprintf("mismatch in #%d", i))
+		entry.CreatedTS = time.Time{}
+		assert.Equal(t, tt.out, entry, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gelfMessage is GELF 1.1 payload, see https://go2docs.graylog.org/current/getting_in_log_data/gelf.html
+type gelfMessage struct {
+	Host         string   `json:"host"`
+	ShortMessage string   `json:"short_message"`
+	FullMessage  string   `json:"full_message"`
+	Timestamp    *float64 `json:"timestamp"` // seconds since epoch with optional decimal places
+	Level        *int     `json:"level"`     // syslog severity, 1 (alert) if missing
+}
+
+const (
+	gelfDefLevel     = SevAlert // GELF default level
+	gelfContainerKey = "_container_name"
+	gelfContainer    = "gelf" // container of messages without _container_name
+)
+
+// NewEntryGELF makes the LogEntry from GELF message, i.e. sent by docker's gelf logging driver.
+// host, _container_name and level mapped to host, container and severity, full_message preferred over short_message.
+// Missing timestamp set to ref. Additional "_" fields kept in Fields without "_" prefix, along with json or logfmt
+// fields of the message.
+func NewEntryGELF(data []byte, ref time.Time) (entry LogEntry, err error) {
+	var msg gelfMessage
+	if err = json.Unmarshal(data, &msg); err != nil {
+		return entry, errors.Wrap(err, "can't parse gelf message")
+	}
+	if msg.Host == "" {
+		return entry, errors.New("no host in gelf message")
+	}
+	if msg.ShortMessage == "" {
+		return entry, errors.New("no short_message in gelf message")
+	}
+
+	entry = LogEntry{Host: msg.Host, Container: gelfContainer, Msg: msg.ShortMessage, TS: ref, CreatedTS: time.Now(),
+		Facility: defFacility, Severity: gelfDefLevel}
+	if msg.FullMessage != "" {
+		entry.Msg = msg.FullMessage
+	}
+	entry.Msg = strings.TrimRight(entry.Msg, " \t\r\n")
+	if msg.Timestamp != nil {
+		sec, frac := math.Modf(*msg.Timestamp)
+		entry.TS = time.Unix(int64(sec), int64(frac*1e9)).Round(time.Microsecond)
+	}
+	if msg.Level != nil {
+		if *msg.Level < SevEmerg || *msg.Level > SevDebug {
+			return entry, errors.Errorf("invalid level %d in gelf message", *msg.Level)
+		}
+		entry.Severity = *msg.Level
+	}
+
+	var obj map[string]json.RawMessage
+	if err = json.Unmarshal(data, &obj); err != nil {
+		return entry, errors.Wrap(err, "can't parse gelf message")
+	}
+	if raw, ok := obj[gelfContainerKey]; ok {
+		if container, ok := jsonFieldValue(raw); ok && container != "" {
+			entry.Container = strings.TrimPrefix(container, "/")
+		}
+	}
+
+	entry.Fields = ParseFields(entry.Msg)
+	for k, raw := range obj {
+		if !strings.HasPrefix(k, "_") || k == gelfContainerKey || k == "_id" { // _id reserved by GELF
+			continue
+		}
+		v, ok := jsonFieldValue(raw)
+		if !ok {
+			continue
+		}
+		if entry.Fields == nil {
+			entry.Fields = map[string]string{}
+		}
+		entry.Fields[fieldKey(k[1:])] = v
+	}
+	return entry, nil
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEntryGELF(t *testing.T) {
+	ref := time.Date(2019, 5, 24, 20, 54, 30, 0, time.UTC)
+	ts := time.Unix(1558731270, 123000000).Round(time.Microsecond)
+
+	tbl := []struct {
+		inp string
+		out LogEntry
+		err string
+	}{
+		{
+			`{"version":"1.1","host":"h1","short_message":"msg1","timestamp":1558731270.123,"level":6,` +
+				`"_container_name":"nginx","_container_id":"abc123","_image_name":"nginx:latest","_tag":"abc123","_created":"2019-05-24T20:00:00Z"}`,
+			LogEntry{Host: "h1", Container: "nginx", Msg: "msg1", TS: ts, Facility: 1, Severity: 6,
+				Fields: map[string]string{"container_id": "abc123", "image_name": "nginx:latest", "tag": "abc123", "created": "2019-05-24T20:00:00Z"}},
+			"",
+		},
+		{
+			`{"version":"1.1","host":"h1","short_message":"error","full_message":"error\n\tat main.go:10\n","_request.id":12,"_ok":true,"_id":"x"}`,
+			LogEntry{Host: "h1", Container: "gelf", Msg: "error\n\tat main.go:10", TS: ref, Facility: 1, Severity: 1,
+				Fields: map[string]string{"request_id": "12", "ok": "true"}},
+			"",
+		},
+		{
+			`{"host":"h1","short_message":"level=warn user=u1","_container_name":"/api","_user":"u2","level":4}`,
+			LogEntry{Host: "h1", Container: "api", Msg: "level=warn user=u1", TS: ref, Facility: 1, Severity: 4,
+				Fields: map[string]string{"level": "warn", "user": "u2"}},
+			"",
+		},
+		{`{"short_message":"msg1"}`, LogEntry{}, "no host in gelf message"},
+		{`{"host":"h1","short_message":""}`, LogEntry{}, "no short_message in gelf message"},
+		{`{"host":"h1","short_message":"msg1","level":8}`, LogEntry{}, "invalid level 8 in gelf message"},
+		{`{"host":"h1",`, LogEntry{}, "can't parse gelf message: unexpected end of JSON input"},
+	}
+
+	for i, tt := range tbl {
+		entry, err := NewEntryGELF([]byte(tt.inp), ref)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.False(t, entry.CreatedTS.IsZero(), fmt.Sprintf("mismatch in #%d", i))
+		entry.CreatedTS = time.Time{}
+		assert.Equal(t, tt.out, entry, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
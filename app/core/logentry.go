@@ -12,18 +12,61 @@ import (
 
 // LogEntry represents a single event for forwarder and rest server and client
 type LogEntry struct {
-	ID        string    `json:"id"`
-	Host      string    `json:"host"`
-	Container string    `json:"container"`
-	Pid       int       `json:"pid"`
-	Msg       string    `json:"msg"`
-	TS        time.Time `json:"ts"`
-	CreatedTS time.Time `json:"cts"`
+	ID         string                       `json:"id"`
+	Host       string                       `json:"host"`
+	Sender     string                       `json:"sender,omitempty"` // ip of syslog client, can differ from host
+	Container  string                       `json:"container"`
+	Group      string                       `json:"group,omitempty"` // container's group, i.e. app, db or system
+	Pid        int                          `json:"pid"`
+	Msg        string                       `json:"msg"`
+	TS         time.Time                    `json:"ts"`
+	CreatedTS  time.Time                    `json:"cts"`
+	StructData map[string]map[string]string `json:"sd,omitempty"`     // rfc5424 structured data, keyed by SD-ID
+	Facility   int                          `json:"facility"`         // syslog facility from <PRI>
+	Severity   int                          `json:"severity"`         // syslog severity from <PRI>, 0 (emerg) - 7 (debug)
+	Fields     map[string]string            `json:"fields,omitempty"` // keys of json or logfmt message
 }
 
 // NewEntry makes the LogEntry from a log line.
 // example:	"Oct 19 15:29:43 host-1 docker/mongo[888]: 2015-10-19T19:29:43 blah blah blah"
+// lines in rfc5424 format, i.e. "1 2019-10-19T15:29:43.003Z host-1 docker/mongo 888 - - blah blah blah" detected and parsed as well.
+// optional "<PRI>" prefix sets facility and severity, lines without it get user.notice.
+// message wrapped by agent's json envelope unwrapped, json and logfmt messages parsed to Fields.
+// year of bsd timestamp (without year) inferred from the current time, see NewEntryWithRef.
 func NewEntry(line string, tz *time.Location) (entry LogEntry, err error) {
+	return NewEntryWithRef(line, tz, time.Now())
+}
+
+// NewEntryWithRef makes the LogEntry from a log line, like NewEntry, with the year of bsd timestamp inferred
+// from the reference time. Timestamp more than 3 days ahead of ref goes to the previous year, i.e. "Dec 31" line
+// parsed on Jan 1. Replay and import tools can pass the time lines were received or the date of the file.
+func NewEntryWithRef(line string, tz *time.Location, ref time.Time) (entry LogEntry, err error) {
+	facility, severity, line, ok := parsePriority(line)
+	if !ok {
+		facility, severity = defFacility, defSeverity
+	}
+	entry, err = newEntry(line, tz, ref)
+	entry.Facility, entry.Severity = facility, severity
+	if err != nil {
+		return entry, err
+	}
+
+	if env, ok := unwrapJSONEnvelope(entry.Msg); ok {
+		entry.Msg = strings.TrimRight(*env.Msg, " \t\r\n")
+		if entry.Group == "" {
+			entry.Group = env.Group
+		}
+	}
+	entry.Fields = ParseFields(entry.Msg)
+	return entry, nil
+}
+
+// newEntry makes the LogEntry from a log line without <PRI>
+func newEntry(line string, tz *time.Location, ref time.Time) (entry LogEntry, err error) {
+
+	if isRFC5424(line) { // rfc5424 header with NILVALUEs can be shorter than bsd timestamp
+		return newEntryRFC5424(line, tz)
+	}
 
 	if len(line) < 16 { // 16 is minimal size of "Jan _2 15:04:05" timestamp
 		return entry, fmt.Errorf("line is too short, line=[%s]", line)
@@ -31,7 +74,7 @@ func NewEntry(line string, tz *time.Location) (entry LogEntry, err error) {
 
 	entry = LogEntry{Container: "syslog", Pid: 0, CreatedTS: time.Now()}
 
-	entry.TS, line, err = parseTime(line, tz)
+	entry.TS, line, err = parseTime(line, tz, ref)
 	if err != nil {
 		return entry, err
 	}
@@ -48,36 +91,45 @@ func NewEntry(line string, tz *time.Location) (entry LogEntry, err error) {
 	if !ok {
 		return entry, fmt.Errorf("no message in line=[%s]", line)
 	}
-	serviceContainerPidElems := strings.Split(serviceContainerPid, "/")
-	if strings.HasPrefix(serviceContainerPidElems[0], "docker") && len(serviceContainerPidElems) > 1 { // skip non-docker msgs
-		containerAndPid := serviceContainerPidElems[1]
-		pidElems := strings.Split(containerAndPid, "[")
-		entry.Container = pidElems[0]
-		if len(pidElems) > 1 {
-			pidStr := strings.TrimSuffix(pidElems[1], ":")
-			pidStr = strings.TrimSuffix(pidStr, "]")
-			if pid, err := strconv.Atoi(pidStr); err == nil {
-				entry.Pid = pid
-			}
-		}
+	if group, container, pid, ok := parseDockerTag(serviceContainerPid); ok { // skip non-docker msgs
+		entry.Group, entry.Container, entry.Pid = group, container, pid
 	}
 
-	entry.Msg = strings.TrimSpace(msg)
+	entry.Msg = strings.TrimRight(msg, " \t\r\n") // leading spaces kept, i.e. for stack traces
 	return entry, nil
 }
 
+// parseDockerTag extracts group, container and pid from syslog tag like "docker/mongo[888]:" or "docker/db/mongo[888]:".
+// returns false for non-docker tags.
+func parseDockerTag(tag string) (group, container string, pid int, ok bool) {
+	elems := strings.Split(tag, "/")
+	if !strings.HasPrefix(elems[0], "docker") || len(elems) < 2 {
+		return "", "", 0, false
+	}
+	group = strings.Join(elems[1:len(elems)-1], "/")
+	pidElems := strings.Split(elems[len(elems)-1], "[")
+	container = pidElems[0]
+	if len(pidElems) > 1 {
+		pidStr := strings.TrimSuffix(pidElems[1], ":")
+		pidStr = strings.TrimSuffix(pidStr, "]")
+		if p, err := strconv.Atoi(pidStr); err == nil {
+			pid = p
+		}
+	}
+	return group, container, pid, true
+}
+
 // parseTime gets date-time part of the log line and extracts. Returns tx and trimmed line
-// supports "2006 Jan _2 15:04:05" and RFC3339 layouts
-func parseTime(line string, tz *time.Location) (ts time.Time, trimmedLine string, err error) {
+// supports "Jan _2 15:04:05" with year inferred from ref, and RFC3339 layouts
+func parseTime(line string, tz *time.Location, ref time.Time) (ts time.Time, trimmedLine string, err error) {
 
 	if len(line) < 16 {
 		return ts, trimmedLine, errors.Errorf("line %q too short to extract time", line)
 	}
-	tsy := fmt.Sprintf("%d %s", time.Now().Year(), line[0:15]) // try ts like "Oct 19 15:29:43"
-	ts, err = time.ParseInLocation("2006 Jan _2 15:04:05", tsy, tz)
+	ts, err = time.ParseInLocation("Jan _2 15:04:05", line[0:15], tz) // try ts like "Oct 19 15:29:43"
 	if err == nil {
 		trimmedLine = line[16:]
-		return ts, trimmedLine, nil
+		return inferYear(ts, ref.In(tz)), trimmedLine, nil
 	}
 
 	// try RFC3339
@@ -91,6 +143,31 @@ func parseTime(line string, tz *time.Location) (ts time.Time, trimmedLine string
 	return ts.In(tz), rest, nil
 }
 
+// yearRollWindow is how far bsd timestamp can be ahead of reference time before rolling to the previous year
+const yearRollWindow = 3 * 24 * time.Hour
+
+// inferYear sets year for ts without it (year 0) based on ref. Timestamp more than yearRollWindow ahead of ref
+// moved to the previous year, more than a year minus yearRollWindow behind to the next one.
+func inferYear(ts, ref time.Time) time.Time {
+	withYear := func(year int) time.Time {
+		return time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), ts.Location())
+	}
+	res := withYear(ref.Year())
+	switch {
+	case res.After(ref.Add(yearRollWindow)):
+		return withYear(ref.Year() - 1)
+	case res.Before(ref.AddDate(-1, 0, 0).Add(yearRollWindow)):
+		return withYear(ref.Year() + 1)
+	}
+	return res
+}
+
 func (entry LogEntry) String() string {
 	return fmt.Sprintf("%s : %s/%s [%d] - %s", entry.TS.In(time.Local), entry.Host, entry.Container, entry.Pid, entry.Msg)
 }
+
+// HasPriority checks if facility and severity set. Zero ones (kern.emerg) mean entry made without priority,
+// i.e. by old server or other clients, such entry treated as notice. Kernel emergencies don't come from containers.
+func (entry LogEntry) HasPriority() bool {
+	return entry.Facility != 0 || entry.Severity != 0
+}
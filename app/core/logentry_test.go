@@ -47,6 +47,42 @@ func TestNewEntry(t *testing.T) {
 			nil,
 		},
 
+		{
+			"Oct 19 15:29:43 mgd-server-1 docker/db/mongo[888]: some message",
+			LogEntry{Host: "mgd-server-1", Group: "db", Container: "mongo", Pid: 888,
+				TS: time.Date(time.Now().Year(), 10, 19, 15, 29, 43, 0, tz), Msg: "some message"},
+			nil,
+		},
+
+		{
+			"Oct 19 15:29:43 mgd-server-1 docker/infra/db/mongo[888]: some message",
+			LogEntry{Host: "mgd-server-1", Group: "infra/db", Container: "mongo", Pid: 888,
+				TS: time.Date(time.Now().Year(), 10, 19, 15, 29, 43, 0, tz), Msg: "some message"},
+			nil,
+		},
+
+		{
+			`Oct 19 15:29:43 h1 docker/app/rest[888]: {"level":"error","user_id":42}`,
+			LogEntry{Host: "h1", Group: "app", Container: "rest", Pid: 888, TS: time.Date(time.Now().Year(), 10, 19, 15, 29, 43, 0, tz),
+				Msg: `{"level":"error","user_id":42}`, Fields: map[string]string{"level": "error", "user_id": "42"}},
+			nil,
+		},
+
+		{ // agent's json envelope unwrapped
+			`Oct 19 15:29:43 h1 docker/rest[888]: {"msg":"level=error user_id=42\n","container":"rest","group":"app","ts":"2019-05-24T20:54:30Z","host":"h1"}`,
+			LogEntry{Host: "h1", Group: "app", Container: "rest", Pid: 888, TS: time.Date(time.Now().Year(), 10, 19, 15, 29, 43, 0, tz),
+				Msg: "level=error user_id=42", Fields: map[string]string{"level": "error", "user_id": "42"}},
+			nil,
+		},
+
+		{ // not an envelope, has extra fields
+			`Oct 19 15:29:43 h1 docker/rest[888]: {"msg":"blah","container":"rest","ts":"2019-05-24T20:54:30Z","other":1}`,
+			LogEntry{Host: "h1", Container: "rest", Pid: 888, TS: time.Date(time.Now().Year(), 10, 19, 15, 29, 43, 0, tz),
+				Msg:    `{"msg":"blah","container":"rest","ts":"2019-05-24T20:54:30Z","other":1}`,
+				Fields: map[string]string{"msg": "blah", "container": "rest", "ts": "2019-05-24T20:54:30Z", "other": "1"}},
+			nil,
+		},
+
 		{
 			"May 30 16:49:03 host-dev dhclient: ",
 			LogEntry{Host: "host-dev", Container: "syslog", Pid: 0, TS: time.Date(time.Now().Year(), 5, 30, 16, 49, 3, 0, tz),
@@ -71,7 +107,8 @@ func TestNewEntry(t *testing.T) {
 		{
 			`May 30 18:03:27 dev-1 docker[1187]: time="2017-05-30T18:03:27-04:00" level=info msg="Firewalld running: false"`,
 			LogEntry{Host: "dev-1", Container: "syslog", Pid: 0, TS: time.Date(year, 5, 30, 18, 3, 27, 0, tz),
-				Msg: `time="2017-05-30T18:03:27-04:00" level=info msg="Firewalld running: false"`},
+				Msg:    `time="2017-05-30T18:03:27-04:00" level=info msg="Firewalld running: false"`,
+				Fields: map[string]string{"time": "2017-05-30T18:03:27-04:00", "level": "info", "msg": "Firewalld running: false"}},
 			nil,
 		},
 
@@ -85,7 +122,7 @@ func TestNewEntry(t *testing.T) {
 
 	for n, tt := range tbl {
 		t.Run(strconv.Itoa(n), func(t *testing.T) {
-			logEntry, err := NewEntry(tt.inp, tz)
+			logEntry, err := NewEntryWithRef(tt.inp, tz, time.Date(year, 12, 1, 0, 0, 0, 0, tz)) // all bsd stamps in the ref year
 			if tt.err != nil {
 				assert.NotNil(t, err, fmt.Sprintf("expects error in #%d", n))
 				assert.EqualError(t, err, tt.err.Error())
@@ -96,17 +133,122 @@ func TestNewEntry(t *testing.T) {
 			tt.out.ID = "5927d382b2035078e61816a5"
 			assert.Equal(t, tt.out.Host, logEntry.Host, fmt.Sprintf("mismatch in #%d", n))
 			assert.Equal(t, tt.out.Container, logEntry.Container, fmt.Sprintf("mismatch in #%d", n))
+			assert.Equal(t, tt.out.Group, logEntry.Group, fmt.Sprintf("mismatch in #%d", n))
 			assert.Equal(t, tt.out.Msg, logEntry.Msg, fmt.Sprintf("mismatch in #%d", n))
 			assert.Equal(t, tt.out.Pid, logEntry.Pid, fmt.Sprintf("mismatch in #%d", n))
+			assert.Equal(t, tt.out.Fields, logEntry.Fields, fmt.Sprintf("mismatch in #%d", n))
 			assert.Equal(t, tt.out.ID, logEntry.ID, fmt.Sprintf("mismatch in #%d", n))
 			assert.Equal(t, tt.out.TS.Format(time.RFC3339), logEntry.TS.Format(time.RFC3339), fmt.Sprintf("mismatch in #%d", n))
 		})
 	}
 }
 
+func TestNewEntryWithRef_Year(t *testing.T) {
+	tz, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	tbl := []struct {
+		line string
+		ref  time.Time
+		ts   time.Time
+	}{
+		{"Dec 31 23:59:58 h1 docker/c1[1]: msg", time.Date(2020, 1, 1, 0, 0, 5, 0, tz), time.Date(2019, 12, 31, 23, 59, 58, 0, tz)},
+		{"Jan  1 00:00:01 h1 docker/c1[1]: msg", time.Date(2019, 12, 31, 23, 59, 58, 0, tz), time.Date(2020, 1, 1, 0, 0, 1, 0, tz)},
+		{"Oct 19 15:29:43 h1 docker/c1[1]: msg", time.Date(2019, 10, 19, 15, 0, 0, 0, tz), time.Date(2019, 10, 19, 15, 29, 43, 0, tz)},
+		{"Oct 21 15:29:43 h1 docker/c1[1]: msg", time.Date(2019, 10, 19, 15, 0, 0, 0, tz), time.Date(2019, 10, 21, 15, 29, 43, 0, tz)},
+		{"Oct 23 15:29:43 h1 docker/c1[1]: msg", time.Date(2019, 10, 19, 15, 0, 0, 0, tz), time.Date(2018, 10, 23, 15, 29, 43, 0, tz)},
+		{"Mar 10 10:00:00 h1 docker/c1[1]: msg", time.Date(2019, 10, 19, 15, 0, 0, 0, tz), time.Date(2019, 3, 10, 10, 0, 0, 0, tz)},
+		{"Feb 29 10:00:00 h1 docker/c1[1]: msg", time.Date(2020, 3, 2, 0, 0, 0, 0, tz), time.Date(2020, 2, 29, 10, 0, 0, 0, tz)},
+		{"Oct 19 15:29:43 h1 docker/c1[1]: msg", time.Date(2019, 10, 19, 15, 0, 0, 0, time.UTC), time.Date(2019, 10, 19, 15, 29, 43, 0, tz)},
+		{"2017-05-30T16:13:35-04:00 h1 docker/c1[1]: msg", time.Date(2015, 1, 1, 0, 0, 0, 0, tz),
+			time.Date(2017, 5, 30, 15, 13, 35, 0, tz)}, // rfc3339 has the year
+	}
+
+	for i, tt := range tbl {
+		entry, err := NewEntryWithRef(tt.line, tz, tt.ref)
+		require.NoError(t, err, fmt.Sprintf("unexpected error in #%d", i))
+		assert.Equal(t, tt.ts.Format(time.RFC3339), entry.TS.Format(time.RFC3339), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
 func TestLogEntry_String(t *testing.T) {
 	entry := LogEntry{Host: "server-1", Container: "mongo", Pid: 888,
 		TS: time.Date(2019, 5, 24, 15, 29, 43, 0, time.UTC), Msg: "some message 123"}
 	r := entry.String()
 	assert.Equal(t, "2019-05-24 10:29:43 -0500 CDT : server-1/mongo [888] - some message 123", r)
 }
+
+func TestNewEntry_RFC5424(t *testing.T) {
+	tz, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	tbl := []struct {
+		inp   string
+		out   LogEntry
+		nilTS bool // TIMESTAMP is NILVALUE, ts expected to be the same as creation time
+		err   string
+	}{
+		{
+			inp: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application"] ` +
+				"\ufeffAn application event log entry",
+			out: LogEntry{Host: "mymachine.example.com", Container: "evntslog", Facility: 20, Severity: 5,
+				TS:         time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC).In(tz),
+				Msg:        "An application event log entry",
+				StructData: map[string]map[string]string{"exampleSDID@32473": {"iut": "3", "eventSource": "Application"}}},
+		},
+		{
+			inp: "1 2019-05-30T16:13:35-04:00 BigMac.local docker/test123 63415 - - 2017/05/30 16:13:35 tail-dynamic 0a7aed6",
+			out: LogEntry{Facility: 1, Severity: 5, Host: "BigMac.local", Container: "test123", Pid: 63415, TS: time.Date(2019, 5, 30, 20, 13, 35, 0, time.UTC).In(tz),
+				Msg: "2017/05/30 16:13:35 tail-dynamic 0a7aed6"},
+		},
+		{
+			inp: "1 2019-05-30T16:13:35-04:00 BigMac.local docker/db/test123 63415 - - some msg",
+			out: LogEntry{Facility: 1, Severity: 5, Host: "BigMac.local", Group: "db", Container: "test123", Pid: 63415,
+				TS: time.Date(2019, 5, 30, 20, 13, 35, 0, time.UTC).In(tz), Msg: "some msg"},
+		},
+		{
+			inp: `1 2019-05-30T16:13:35Z host1 - - - [a@1 k="v \"q\" \] \\"][b@2] msg`,
+			out: LogEntry{Facility: 1, Severity: 5, Host: "host1", Container: "syslog", TS: time.Date(2019, 5, 30, 16, 13, 35, 0, time.UTC).In(tz), Msg: "msg",
+				StructData: map[string]map[string]string{"a@1": {"k": `v "q" ] \`}, "b@2": {}}},
+		},
+		{
+			inp: "1 2019-05-30T16:13:35Z host1 sshd abc - -",
+			out: LogEntry{Facility: 1, Severity: 5, Host: "host1", Container: "sshd", TS: time.Date(2019, 5, 30, 16, 13, 35, 0, time.UTC).In(tz)},
+		},
+		{inp: "1 - host app - - -", out: LogEntry{Facility: 1, Severity: 5, Host: "host", Container: "app"}, nilTS: true},
+		{inp: "1 - - - - - -", out: LogEntry{Facility: 1, Severity: 5, Container: "syslog"}, nilTS: true},
+		{inp: "<11>1 - host app - - - boom", out: LogEntry{Facility: 1, Severity: 3, Host: "host", Container: "app", Msg: "boom"}, nilTS: true},
+		{inp: "1 - - - - - - msg", out: LogEntry{Facility: 1, Severity: 5, Container: "syslog", Msg: "msg"}, nilTS: true},
+		{inp: "1 2019-05-30T16:13:35Z host1 sshd", err: "incomplete rfc5424 header in line=[1 2019-05-30T16:13:35Z host1 sshd]"},
+		{inp: "1 2019-05-30X16:13:35Z host1 sshd - - - msg", err: `can't extract time from "1 2019-05-30X16:13:35Z host1 sshd - - - msg": ` +
+			`parsing time "2019-05-30X16:13:35Z" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "X16:13:35Z" as "T"`},
+		{inp: `1 2019-05-30T16:13:35Z host1 sshd - - [a@1 k="v] msg`,
+			err: `can't parse structured data in line=[1 2019-05-30T16:13:35Z host1 sshd - - [a@1 k="v] msg]: unterminated value for k`},
+		{inp: `1 2019-05-30T16:13:35Z host1 sshd - - blah msg`,
+			err: `can't parse structured data in line=[1 2019-05-30T16:13:35Z host1 sshd - - blah msg]: unexpected structured data "blah msg"`},
+		{inp: `1 2019-05-30T16:13:35Z host1 sshd - - [a@1 foo][b@2 k="v"] msg`,
+			err: `can't parse structured data in line=[1 2019-05-30T16:13:35Z host1 sshd - - [a@1 foo][b@2 k="v"] msg]: bad SD-PARAM at 5`},
+		{inp: `1 2019-05-30T16:13:35Z host1 sshd - - [a@1 k"x="v"] msg`,
+			err: `can't parse structured data in line=[1 2019-05-30T16:13:35Z host1 sshd - - [a@1 k"x="v"] msg]: bad SD-PARAM at 5`},
+		{inp: `1 2019-05-30T16:13:35Z host1 sshd - - [a@1]msg`,
+			err: `can't parse structured data in line=[1 2019-05-30T16:13:35Z host1 sshd - - [a@1]msg]: no space after structured data at 5`},
+	}
+
+	for n, tt := range tbl {
+		entry, err := NewEntry(tt.inp, tz)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("expects error in #%d", n))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("unexpected error in #%d", n))
+
+		assert.WithinDuration(t, time.Now(), entry.CreatedTS, time.Second, fmt.Sprintf("mismatch in #%d", n))
+		if tt.nilTS {
+			assert.True(t, entry.TS.Equal(entry.CreatedTS), fmt.Sprintf("nil ts not set to creation time in #%d", n))
+			assert.Equal(t, tz, entry.TS.Location(), fmt.Sprintf("mismatch in #%d", n))
+			entry.TS = time.Time{}
+		}
+		entry.CreatedTS = time.Time{}
+		assert.Equal(t, tt.out, entry, fmt.Sprintf("mismatch in #%d", n))
+	}
+}
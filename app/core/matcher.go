@@ -0,0 +1,279 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Matcher checks entries against request in memory, with the same semantics as mongo query.
+// Used by stores without query engine and by live streaming. LastID and Limit not checked, it is up to the caller.
+type Matcher struct {
+	req         Request
+	hosts       listMatcher
+	excHosts    listMatcher
+	containers  listMatcher
+	excludes    listMatcher
+	groups      listMatcher
+	excGroups   listMatcher
+	scopeHosts  listMatcher
+	scopeConts  listMatcher
+	grep        []*regexp.Regexp
+	ungrep      []*regexp.Regexp
+	fields      []fieldMatcher
+	minSeverity int
+	search      searchMatcher
+}
+
+// listMatcher matches value against list of exact strings and /regexes/
+type listMatcher struct {
+	exact map[string]bool
+	res   []*regexp.Regexp
+}
+
+type fieldMatcher struct {
+	FieldFilter
+	re *regexp.Regexp
+}
+
+// searchMatcher is a simplified mongo $text search. Entry matches if it has none of -words and all "phrases",
+// or any of words if no phrases. Case-insensitive, negated words alone match nothing.
+type searchMatcher struct {
+	words, negated, phrases []string
+}
+
+// NewMatcher makes Matcher for request, fails on bad regexes, severity or field filters
+func NewMatcher(req Request) (m *Matcher, err error) {
+	m = &Matcher{req: req, minSeverity: -1}
+	lists := []struct {
+		elems []string
+		res   *listMatcher
+	}{
+		{req.Hosts, &m.hosts}, {req.ExcludeHosts, &m.excHosts}, {req.Containers, &m.containers},
+		{req.Excludes, &m.excludes}, {req.Groups, &m.groups}, {req.ExcludeGroups, &m.excGroups}, {req.ScopeHosts, &m.scopeHosts},
+		{req.ScopeContainers, &m.scopeConts},
+	}
+	for _, l := range lists {
+		if *l.res, err = newListMatcher(l.elems); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.MinSeverity != "" {
+		if m.minSeverity, err = ParseSeverity(req.MinSeverity); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.grep, err = grepRegexes(req.Grep); err != nil {
+		return nil, err
+	}
+	if m.ungrep, err = grepRegexes(req.UnGrep); err != nil {
+		return nil, err
+	}
+
+	for _, f := range req.Fields {
+		ff, e := ParseFieldFilter(f)
+		if e != nil {
+			return nil, e
+		}
+		fm := fieldMatcher{FieldFilter: ff}
+		if ff.IsRegex() {
+			if fm.re, e = regexp.Compile(ff.Value[1 : len(ff.Value)-1]); e != nil {
+				return nil, errors.Wrapf(e, "bad regex in field filter %q", f)
+			}
+		}
+		m.fields = append(m.fields, fm)
+	}
+
+	m.search = newSearchMatcher(req.Search)
+	return m, nil
+}
+
+// Match checks if entry matches all filters of the request
+func (m *Matcher) Match(e LogEntry) bool {
+	if !m.MatchTime(e.TS, e.TS) || !m.MatchHost(e.Host) || !m.MatchContainer(e.Container) {
+		return false
+	}
+	if len(m.req.Groups) > 0 && !m.groups.match(e.Group) {
+		return false
+	}
+	if e.Group != "" && m.excGroups.match(e.Group) {
+		return false
+	}
+	if m.minSeverity >= 0 {
+		sev := e.Severity
+		if !e.HasPriority() {
+			sev = defSeverity // the same as mongo's records stored before severity was added
+		}
+		if sev > m.minSeverity {
+			return false
+		}
+	}
+	if len(m.grep) > 0 && !matchAnyRegex(m.grep, e.Msg) {
+		return false
+	}
+	if matchAnyRegex(m.ungrep, e.Msg) {
+		return false
+	}
+	for _, f := range m.fields {
+		if !f.match(e.Fields) {
+			return false
+		}
+	}
+	return m.search.match(e.Msg)
+}
+
+// MatchHost checks host against hosts, excluded hosts and scope
+func (m *Matcher) MatchHost(host string) bool {
+	if len(m.req.Hosts) > 0 && !m.hosts.match(host) {
+		return false
+	}
+	if len(m.req.ScopeHosts) > 0 && !m.scopeHosts.match(host) {
+		return false
+	}
+	return !m.excHosts.match(host)
+}
+
+// MatchContainer checks container against containers, excludes and scope
+func (m *Matcher) MatchContainer(container string) bool {
+	if len(m.req.Containers) > 0 && !m.containers.match(container) {
+		return false
+	}
+	if len(m.req.ScopeContainers) > 0 && !m.scopeConts.match(container) {
+		return false
+	}
+	return !m.excludes.match(container)
+}
+
+// MatchTime checks if [from, to] time range overlaps with [FromTS, ToTS) of the request.
+// For a single entry from and to are the same.
+func (m *Matcher) MatchTime(from, to time.Time) bool {
+	if !m.req.FromTS.IsZero() && to.Before(m.req.FromTS) {
+		return false
+	}
+	if !m.req.ToTS.IsZero() && !from.Before(m.req.ToTS) {
+		return false
+	}
+	return true
+}
+
+func newListMatcher(elems []string) (res listMatcher, err error) {
+	res.exact = map[string]bool{}
+	for _, elem := range elems {
+		if len(elem) > 1 && strings.HasPrefix(elem, "/") && strings.HasSuffix(elem, "/") {
+			re, e := regexp.Compile(elem[1 : len(elem)-1])
+			if e != nil {
+				return listMatcher{}, errors.Wrapf(e, "bad regex %q", elem)
+			}
+			res.res = append(res.res, re)
+			continue
+		}
+		res.exact[elem] = true
+	}
+	return res, nil
+}
+
+func (l listMatcher) match(val string) bool {
+	return l.exact[val] || matchAnyRegex(l.res, val)
+}
+
+func (f fieldMatcher) match(fields map[string]string) bool {
+	val, ok := fields[f.Key]
+	var matched bool
+	switch {
+	case !ok:
+		matched = false
+	case f.re != nil:
+		matched = f.re.MatchString(val)
+	default:
+		matched = val == f.Value
+	}
+	return matched != f.Negate
+}
+
+func grepRegexes(elems []string) (res []*regexp.Regexp, err error) {
+	for _, elem := range elems {
+		pattern, e := GrepPattern(elem)
+		if e != nil {
+			return nil, e
+		}
+		res = append(res, regexp.MustCompile(pattern))
+	}
+	return res, nil
+}
+
+func matchAnyRegex(res []*regexp.Regexp, val string) bool {
+	for _, re := range res {
+		if re.MatchString(val) {
+			return true
+		}
+	}
+	return false
+}
+
+func newSearchMatcher(s string) (res searchMatcher) {
+	for {
+		start := strings.Index(s, `"`)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start+1:], `"`)
+		if end < 0 {
+			break
+		}
+		if phrase := strings.ToLower(s[start+1 : start+1+end]); strings.TrimSpace(phrase) != "" {
+			res.phrases = append(res.phrases, phrase)
+		}
+		s = s[:start] + " " + s[start+end+2:]
+	}
+	for f := range strings.FieldsSeq(s) {
+		negate := strings.HasPrefix(f, "-")
+		for _, w := range searchWords(f) {
+			if negate {
+				res.negated = append(res.negated, w)
+				continue
+			}
+			res.words = append(res.words, w)
+		}
+	}
+	return res
+}
+
+func (s searchMatcher) match(msg string) bool {
+	if len(s.words) == 0 && len(s.phrases) == 0 && len(s.negated) == 0 {
+		return true
+	}
+	lmsg := strings.ToLower(msg)
+	for _, p := range s.phrases {
+		if !strings.Contains(lmsg, p) {
+			return false
+		}
+	}
+	words := map[string]bool{}
+	for _, w := range searchWords(lmsg) {
+		words[w] = true
+	}
+	for _, w := range s.negated {
+		if words[w] {
+			return false
+		}
+	}
+	if len(s.phrases) > 0 {
+		return true // words optional with phrases, like in mongo
+	}
+	for _, w := range s.words {
+		if words[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// searchWords splits string to lowercase words by any non-letter and non-digit characters, like text index
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcher_Match(t *testing.T) {
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	entry := LogEntry{Host: "web-1", Container: "nginx", Group: "proxy", Msg: "GET /api/v1 status=500 Timeout reached",
+		TS: ts, Severity: SevErr, Fields: map[string]string{"status": "500", "path": "/api/v1"}}
+
+	tbl := []struct {
+		req Request
+		res bool
+	}{
+		{Request{}, true},
+		{Request{Hosts: []string{"web-1"}}, true},
+		{Request{Hosts: []string{"web"}}, false},
+		{Request{Hosts: []string{"/^web/"}}, true},
+		{Request{Hosts: []string{"/^web/"}, ExcludeHosts: []string{"/-1$/"}}, false},
+		{Request{ExcludeHosts: []string{"web-2"}}, true},
+		{Request{Containers: []string{"other", "nginx"}}, true},
+		{Request{Containers: []string{"/ngi/"}, Excludes: []string{"nginx"}}, false},
+		{Request{Excludes: []string{"/^n/"}}, false},
+		{Request{Groups: []string{"proxy"}}, true},
+		{Request{Groups: []string{"/^db/"}}, false},
+		{Request{ExcludeGroups: []string{"proxy"}}, false},
+		{Request{ExcludeGroups: []string{"/^db/"}}, true},
+		{Request{FromTS: ts}, true},
+		{Request{FromTS: ts.Add(time.Second)}, false},
+		{Request{ToTS: ts}, false},
+		{Request{ToTS: ts.Add(time.Second)}, true},
+		{Request{MinSeverity: "err"}, true},
+		{Request{MinSeverity: "crit"}, false},
+		{Request{Fields: []string{"status=500", "path=/^\\/api/"}}, true},
+		{Request{Fields: []string{"status!=500"}}, false},
+		{Request{Fields: []string{"missing!=1"}}, true},
+		{Request{Fields: []string{"missing=1"}}, false},
+		{Request{Fields: []string{"status!=/^5/"}}, false},
+		{Request{Grep: []string{"nothing", "/status=5\\d\\d/"}}, true},
+		{Request{Grep: []string{"nothing"}}, false},
+		{Request{UnGrep: []string{"Timeout"}}, false},
+		{Request{UnGrep: []string{"timeout"}}, true},
+		{Request{Search: "timeout"}, true},
+		{Request{Search: "other timeout"}, true},
+		{Request{Search: "other"}, false},
+		{Request{Search: `"timeout reached" other`}, true},
+		{Request{Search: `"reached timeout"`}, false},
+		{Request{Search: "timeout -api"}, false},
+		{Request{Search: "-other"}, false},
+		{Request{Search: "time"}, false},
+	}
+
+	for i, tt := range tbl {
+		m, err := NewMatcher(tt.req)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.res, m.Match(entry), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestMatcher_MatchNoPriority(t *testing.T) {
+	entry := LogEntry{Host: "h1", Container: "c1", Msg: "msg1"} // no facility and severity, treated as notice
+	tbl := []struct {
+		sev string
+		res bool
+	}{
+		{"", true}, {"debug", true}, {"notice", true}, {"warning", false}, {"emerg", false},
+	}
+	for i, tt := range tbl {
+		m, err := NewMatcher(Request{MinSeverity: tt.sev})
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.res, m.Match(entry), fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	m, err := NewMatcher(Request{MinSeverity: "emerg"})
+	require.NoError(t, err)
+	assert.True(t, m.Match(LogEntry{Host: "h1", Container: "c1", Facility: 1, Severity: SevEmerg}))
+}
+
+func TestMatcher_Bad(t *testing.T) {
+	tbl := []Request{
+		{Hosts: []string{"/[bad/"}},
+		{Excludes: []string{"/(bad/"}},
+		{MinSeverity: "bad"},
+		{Grep: []string{"/[bad/"}},
+		{Fields: []string{"bad"}},
+		{Fields: []string{"k=/[bad/"}},
+	}
+	for i, req := range tbl {
+		_, err := NewMatcher(req)
+		assert.Error(t, err, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestMatcher_MatchTime(t *testing.T) {
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	m, err := NewMatcher(Request{FromTS: ts, ToTS: ts.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.True(t, m.MatchTime(ts.Add(-time.Hour), ts), "overlaps at from")
+	assert.False(t, m.MatchTime(ts.Add(-time.Hour), ts.Add(-time.Second)))
+	assert.True(t, m.MatchTime(ts.Add(time.Minute), ts.Add(2*time.Hour)))
+	assert.False(t, m.MatchTime(ts.Add(time.Hour), ts.Add(2*time.Hour)), "to excluded")
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defMultilineWait     = time.Second
+	defMultilineMaxLines = 1000 // joined event flushed on this size to prevent endless grow
+)
+
+// MultilineRule defines how lines of a container joined into a single event, i.e. stack traces.
+// A line is continuation of the previous one if it matches Cont, or if Cont not defined and line doesn't match Start.
+// Pending event flushed on the next non-continuation line or after Wait without new lines.
+type MultilineRule struct {
+	Container string         // container name or /regex/, empty for all containers
+	Start     *regexp.Regexp // first line of event, optional if Cont defined
+	Cont      *regexp.Regexp // continuation line, optional if Start defined
+	Wait      time.Duration  // max wait for the next line
+}
+
+// ParseMultilineRule makes rule from "container=name;start=regex;cont=regex;wait=duration" string.
+// all elements optional, but at least one of start or cont has to be defined.
+func ParseMultilineRule(s string) (rule MultilineRule, err error) {
+	rule.Wait = defMultilineWait
+	for elem := range strings.SplitSeq(s, ";") {
+		if strings.TrimSpace(elem) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(elem, "=")
+		if !ok {
+			return MultilineRule{}, fmt.Errorf("bad multiline element %q in %q, expected key=value", elem, s)
+		}
+		switch strings.TrimSpace(k) {
+		case "container":
+			rule.Container = v
+		case "start":
+			if rule.Start, err = regexp.Compile(v); err != nil {
+				return MultilineRule{}, errors.Wrapf(err, "bad start regex in %q", s)
+			}
+		case "cont":
+			if rule.Cont, err = regexp.Compile(v); err != nil {
+				return MultilineRule{}, errors.Wrapf(err, "bad cont regex in %q", s)
+			}
+		case "wait":
+			if rule.Wait, err = time.ParseDuration(v); err != nil || rule.Wait <= 0 {
+				return MultilineRule{}, fmt.Errorf("bad wait %q in %q", v, s)
+			}
+		default:
+			return MultilineRule{}, fmt.Errorf("unknown multiline key %q in %q", k, s)
+		}
+	}
+	if rule.Start == nil && rule.Cont == nil {
+		return MultilineRule{}, fmt.Errorf("multiline rule %q needs start or cont regex", s)
+	}
+	return rule, nil
+}
+
+// MatchContainer checks if rule defined for container
+func (r MultilineRule) MatchContainer(container string) bool {
+	if r.Container == "" {
+		return true
+	}
+	if len(r.Container) > 1 && strings.HasPrefix(r.Container, "/") && strings.HasSuffix(r.Container, "/") {
+		matched, err := regexp.MatchString(r.Container[1:len(r.Container)-1], container)
+		return err == nil && matched
+	}
+	return r.Container == container
+}
+
+// IsContinuation checks if line continues the previous one
+func (r MultilineRule) IsContinuation(line string) bool {
+	if r.Cont != nil {
+		return r.Cont.MatchString(line)
+	}
+	return !r.Start.MatchString(line)
+}
+
+// FindMultilineRule returns the first rule defined for container
+func FindMultilineRule(rules []MultilineRule, container string) (MultilineRule, bool) {
+	for _, r := range rules {
+		if r.MatchContainer(container) {
+			return r, true
+		}
+	}
+	return MultilineRule{}, false
+}
+
+// MultilineJoiner collects items (lines or entries) of multiline events and emits them as a group.
+// Emit called with lock held, sequentially and in order. Thread safe.
+type MultilineJoiner[T any] struct {
+	rule    MultilineRule
+	emit    func(items []T)
+	lock    sync.Mutex
+	pending []T
+	timer   *time.Timer
+	gen     int // incremented on each add, prevents flush by stale timer
+}
+
+// NewMultilineJoiner makes joiner for the rule, emit called for each completed group
+func NewMultilineJoiner[T any](rule MultilineRule, emit func(items []T)) *MultilineJoiner[T] {
+	if rule.Wait <= 0 {
+		rule.Wait = defMultilineWait
+	}
+	return &MultilineJoiner[T]{rule: rule, emit: emit}
+}
+
+// Add item with its text line. Continuation appended to pending group, any other line flushes it and starts the new one.
+func (j *MultilineJoiner[T]) Add(item T, line string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if len(j.pending) == 0 || !j.rule.IsContinuation(line) || len(j.pending) >= defMultilineMaxLines {
+		j.flush()
+	}
+	j.pending = append(j.pending, item)
+
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	j.gen++
+	gen := j.gen
+	j.timer = time.AfterFunc(j.rule.Wait, func() {
+		j.lock.Lock()
+		defer j.lock.Unlock()
+		if gen == j.gen {
+			j.flush()
+		}
+	})
+}
+
+// Flush emits pending group, if any
+func (j *MultilineJoiner[T]) Flush() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.flush()
+}
+
+func (j *MultilineJoiner[T]) flush() {
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	if len(j.pending) == 0 {
+		return
+	}
+	items := j.pending
+	j.pending = nil
+	j.emit(items)
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMultilineRule(t *testing.T) {
+	tbl := []struct {
+		inp                   string
+		container, start, cnt string
+		wait                  time.Duration
+		err                   string
+	}{
+		{inp: `container=app;start=^\d{4}-;wait=2s`, container: "app", start: `^\d{4}-`, wait: 2 * time.Second},
+		{inp: `cont=^\s+`, cnt: `^\s+`, wait: time.Second},
+		{inp: `container=/^java/;start=^[A-Z];cont=^\s+at ;`, container: "/^java/", start: "^[A-Z]", cnt: `^\s+at `, wait: time.Second},
+		{inp: `container=app`, err: `multiline rule "container=app" needs start or cont regex`},
+		{inp: ``, err: `multiline rule "" needs start or cont regex`},
+		{inp: `start=[bad`, err: "bad start regex in \"start=[bad\": error parsing regexp: missing closing ]: `[bad`"},
+		{inp: `cont=(bad`, err: "bad cont regex in \"cont=(bad\": error parsing regexp: missing closing ): `(bad`"},
+		{inp: `start=^a;wait=abc`, err: `bad wait "abc" in "start=^a;wait=abc"`},
+		{inp: `start=^a;wait=-1s`, err: `bad wait "-1s" in "start=^a;wait=-1s"`},
+		{inp: `start=^a;blah`, err: `bad multiline element "blah" in "start=^a;blah", expected key=value`},
+		{inp: `start=^a;foo=bar`, err: `unknown multiline key "foo" in "start=^a;foo=bar"`},
+	}
+
+	for i, tt := range tbl {
+		rule, err := ParseMultilineRule(tt.inp)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("unexpected error in #%d", i))
+		assert.Equal(t, tt.container, rule.Container, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.wait, rule.Wait, fmt.Sprintf("mismatch in #%d", i))
+		if tt.start != "" {
+			assert.Equal(t, tt.start, rule.Start.String(), fmt.Sprintf("mismatch in #%d", i))
+		}
+		if tt.cnt != "" {
+			assert.Equal(t, tt.cnt, rule.Cont.String(), fmt.Sprintf("mismatch in #%d", i))
+		}
+	}
+}
+
+func TestMultilineRule_Match(t *testing.T) {
+	rules := []MultilineRule{}
+	for _, s := range []string{"container=app;start=^[0-9]", "container=/^java/;cont=^\\s+at ", "cont=^\\s"} {
+		r, err := ParseMultilineRule(s)
+		require.NoError(t, err)
+		rules = append(rules, r)
+	}
+
+	r, ok := FindMultilineRule(rules, "app")
+	require.True(t, ok)
+	assert.Equal(t, "app", r.Container)
+	assert.True(t, r.IsContinuation("panic: boom"))
+	assert.False(t, r.IsContinuation("2019-05-24 started"))
+
+	r, ok = FindMultilineRule(rules, "java-svc")
+	require.True(t, ok)
+	assert.Equal(t, "/^java/", r.Container)
+	assert.True(t, r.IsContinuation("\tat com.example.Main(Main.java:5)"))
+	assert.False(t, r.IsContinuation("Exception in thread main"))
+
+	r, ok = FindMultilineRule(rules, "nginx")
+	require.True(t, ok)
+	assert.Equal(t, "", r.Container, "catch-all rule")
+
+	_, ok = FindMultilineRule(rules[:2], "nginx")
+	assert.False(t, ok)
+}
+
+func TestMultilineJoiner(t *testing.T) {
+	rule, err := ParseMultilineRule(`start=^\d{4}-;wait=50ms`)
+	require.NoError(t, err)
+
+	var res []string
+	lock := sync.Mutex{}
+	j := NewMultilineJoiner(rule, func(items []string) {
+		lock.Lock()
+		res = append(res, strings.Join(items, "|"))
+		lock.Unlock()
+	})
+	get := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, res...)
+	}
+
+	for _, line := range []string{"continuation without start", "2019-05-24 line1", "2019-05-24 panic: boom", "goroutine 1",
+		"\tmain.go:5", "2019-05-24 line2"} {
+		j.Add(line, line)
+	}
+	assert.Equal(t, []string{"continuation without start", "2019-05-24 line1", "2019-05-24 panic: boom|goroutine 1|\tmain.go:5"}, get())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 4, len(get()), "pending flushed after wait")
+	assert.Equal(t, "2019-05-24 line2", get()[3])
+
+	j.Add("2019-05-24 line3", "2019-05-24 line3")
+	j.Add("more", "more")
+	j.Flush()
+	assert.Equal(t, "2019-05-24 line3|more", get()[4])
+	j.Flush()
+	assert.Equal(t, 5, len(get()), "nothing to flush")
+}
+
+func TestMultilineJoiner_MaxLines(t *testing.T) {
+	rule, err := ParseMultilineRule(`cont=^\s`)
+	require.NoError(t, err)
+
+	var res [][]int
+	j := NewMultilineJoiner(rule, func(items []int) { res = append(res, items) })
+	j.Add(0, "start")
+	for i := 1; i < defMultilineMaxLines+10; i++ {
+		j.Add(i, " cont")
+	}
+	j.Flush()
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, defMultilineMaxLines, len(res[0]))
+	assert.Equal(t, 10, len(res[1]))
+	assert.Equal(t, defMultilineMaxLines, res[1][0])
+}
@@ -0,0 +1,296 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryError reports problem in query with position (byte offset) of the failed term or value
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query error at %d: %s", e.Pos, e.Msg)
+}
+
+// ParseQuery makes Request from query string, i.e. `host=~/^web/ container!=monit msg:"timeout" since:1h`
+// terms separated by spaces. Different keys have to match all, repeated host=, container=, group= and msg: terms
+// match any of them, the same as lists of Request. Excluding terms and field predicates have to match all.
+// Supported terms:
+//
+//	host=h1, host=~/regex/, host!=h1                 - hosts and excluded hosts
+//	container=c1, container=~/regex/, container!=c1  - containers and excluded containers
+//	group=g1, group=~/regex/, group!=g1              - groups and excluded groups
+//	msg:"substring", msg:/regex/, -msg:"substring"   - grep (any of) and un-grep (none of) on message
+//	search:"words"                                   - full text search on message
+//	since:1h, since:2d, until:2019-05-24T10:00:00Z   - time range, duration back from now or time
+//	severity:err                                     - this and more severe levels
+//	limit:100                                        - max number of records
+//	key=value, key!=value, key=~/regex/              - any other key is a field predicate
+//
+// values with spaces can be quoted, i.e. msg:"connection refused".
+func ParseQuery(q string) (Request, error) {
+	return parseQuery(q, time.Now())
+}
+
+// WithQuery returns copy of request with query terms added. Lists extended, scalar values replaced.
+// Query field cleared as it's already applied.
+func (r Request) WithQuery(q string) (Request, error) {
+	qr, err := ParseQuery(q)
+	if err != nil {
+		return r, err
+	}
+	res := r
+	res.Query = ""
+	res.Hosts = append(res.Hosts, qr.Hosts...)
+	res.ExcludeHosts = append(res.ExcludeHosts, qr.ExcludeHosts...)
+	res.Containers = append(res.Containers, qr.Containers...)
+	res.Excludes = append(res.Excludes, qr.Excludes...)
+	res.Groups = append(res.Groups, qr.Groups...)
+	res.ExcludeGroups = append(res.ExcludeGroups, qr.ExcludeGroups...)
+	res.Fields = append(res.Fields, qr.Fields...)
+	res.Grep = append(res.Grep, qr.Grep...)
+	res.UnGrep = append(res.UnGrep, qr.UnGrep...)
+	if qr.Search != "" {
+		res.Search = qr.Search
+	}
+	if !qr.FromTS.IsZero() {
+		res.FromTS = qr.FromTS
+	}
+	if !qr.ToTS.IsZero() {
+		res.ToTS = qr.ToTS
+	}
+	if qr.MinSeverity != "" {
+		res.MinSeverity = qr.MinSeverity
+	}
+	if qr.Limit != 0 {
+		res.Limit = qr.Limit
+	}
+	return res, nil
+}
+
+type queryTerm struct {
+	pos, valPos int
+	negate      bool // -key:value
+	key, op     string
+	value       string
+	regex       bool // value in /regex/ form
+}
+
+func parseQuery(q string, now time.Time) (req Request, err error) {
+	pos := 0
+	for pos < len(q) {
+		if q[pos] == ' ' || q[pos] == '\t' {
+			pos++
+			continue
+		}
+		term, end, err := parseQueryTerm(q, pos)
+		if err != nil {
+			return Request{}, err
+		}
+		if err = req.applyQueryTerm(term, now); err != nil {
+			return Request{}, err
+		}
+		pos = end
+	}
+	return req, nil
+}
+
+// parseQueryTerm parses single key-op-value term started at pos, returns position after the term
+func parseQueryTerm(q string, pos int) (term queryTerm, end int, err error) {
+	term.pos = pos
+	if q[pos] == '-' {
+		term.negate = true
+		pos++
+	}
+	keyStart := pos
+	for pos < len(q) && isQueryKeyChar(q[pos]) {
+		pos++
+	}
+	if pos == keyStart {
+		return term, 0, &QueryError{Pos: pos, Msg: fmt.Sprintf("expected key, got %q", q[pos:])}
+	}
+	term.key = q[keyStart:pos]
+
+	for _, op := range []string{"!=", "=~", "=", ":"} {
+		if strings.HasPrefix(q[pos:], op) {
+			term.op = op
+			break
+		}
+	}
+	if term.op == "" {
+		return term, 0, &QueryError{Pos: pos, Msg: fmt.Sprintf("expected =, !=, =~ or : after %q", term.key)}
+	}
+	pos += len(term.op)
+	term.valPos = pos
+
+	if term.value, term.regex, pos, err = parseQueryValue(q, pos); err != nil {
+		return term, 0, err
+	}
+	if pos < len(q) && q[pos] != ' ' && q[pos] != '\t' {
+		return term, 0, &QueryError{Pos: pos, Msg: fmt.Sprintf("unexpected %q after value", q[pos:])}
+	}
+	return term, pos, nil
+}
+
+// parseQueryValue reads quoted, /regex/ or bare value. Regex returned with slashes, quotes removed.
+func parseQueryValue(q string, pos int) (val string, regex bool, end int, err error) {
+	if pos >= len(q) {
+		return "", false, pos, nil
+	}
+
+	switch q[pos] {
+	case '"':
+		sb := strings.Builder{}
+		for i := pos + 1; i < len(q); i++ {
+			switch {
+			case q[i] == '\\' && i+1 < len(q):
+				sb.WriteByte(q[i+1])
+				i++
+			case q[i] == '"':
+				return sb.String(), false, i + 1, nil
+			default:
+				sb.WriteByte(q[i])
+			}
+		}
+		return "", false, 0, &QueryError{Pos: pos, Msg: "unterminated quoted value"}
+	case '/':
+		for i := pos + 1; i < len(q); i++ {
+			switch q[i] {
+			case '\\':
+				i++
+			case '/':
+				return q[pos : i+1], true, i + 1, nil
+			}
+		}
+		return "", false, 0, &QueryError{Pos: pos, Msg: "unterminated regex"}
+	}
+
+	end = strings.IndexAny(q[pos:], " \t")
+	if end < 0 {
+		end = len(q) - pos
+	}
+	return q[pos : pos+end], false, pos + end, nil
+}
+
+func isQueryKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.'
+}
+
+func (r *Request) applyQueryTerm(t queryTerm, now time.Time) error {
+	valErr := func(format string, args ...any) error {
+		return &QueryError{Pos: t.valPos, Msg: fmt.Sprintf(format, args...)}
+	}
+	if t.negate && (t.key != "msg" || t.op != ":") {
+		return &QueryError{Pos: t.pos, Msg: fmt.Sprintf("negation with - supported for msg: only, got %q", "-"+t.key+t.op)}
+	}
+	if t.op == "=~" && !t.regex {
+		return valErr("expected /regex/ for %s=~", t.key)
+	}
+
+	switch t.key {
+	case "host", "container", "group":
+		if t.op == ":" {
+			return &QueryError{Pos: t.pos, Msg: fmt.Sprintf("use %s=, %s!= or %s=~ instead of %s:", t.key, t.key, t.key, t.key)}
+		}
+		if t.value == "" {
+			return valErr("empty value for %s", t.key)
+		}
+		switch {
+		case t.key == "host" && t.op == "!=":
+			r.ExcludeHosts = append(r.ExcludeHosts, t.value)
+		case t.key == "host":
+			r.Hosts = append(r.Hosts, t.value)
+		case t.key == "container" && t.op == "!=":
+			r.Excludes = append(r.Excludes, t.value)
+		case t.key == "container":
+			r.Containers = append(r.Containers, t.value)
+		case t.op == "!=":
+			r.ExcludeGroups = append(r.ExcludeGroups, t.value)
+		default:
+			r.Groups = append(r.Groups, t.value)
+		}
+		return nil
+	case "msg", "search", "since", "until", "severity", "limit":
+		if t.op != ":" {
+			return &QueryError{Pos: t.pos, Msg: fmt.Sprintf("use %s: instead of %s%s", t.key, t.key, t.op)}
+		}
+		if t.value == "" {
+			return valErr("empty value for %s", t.key)
+		}
+		return r.applyQueryOption(t, now, valErr)
+	}
+
+	if t.op == ":" {
+		return &QueryError{Pos: t.pos, Msg: fmt.Sprintf("unknown key %q", t.key)}
+	}
+	op := t.op
+	if op == "=~" {
+		op = "="
+	}
+	ff, err := ParseFieldFilter(t.key + op + t.value)
+	if err != nil {
+		return &QueryError{Pos: t.pos, Msg: err.Error()}
+	}
+	r.Fields = append(r.Fields, fmt.Sprintf("%s%s%s", ff.Key, op, ff.Value))
+	return nil
+}
+
+// applyQueryOption sets "key:value" terms
+func (r *Request) applyQueryOption(t queryTerm, now time.Time, valErr func(format string, args ...any) error) error {
+	switch t.key {
+	case "msg":
+		if _, err := GrepPattern(t.value); err != nil {
+			return valErr("%v", err)
+		}
+		if t.negate {
+			r.UnGrep = append(r.UnGrep, t.value)
+			return nil
+		}
+		r.Grep = append(r.Grep, t.value)
+	case "search":
+		r.Search = strings.TrimSpace(r.Search + " " + t.value)
+	case "since", "until":
+		ts, err := parseQueryTime(t.value, now)
+		if err != nil {
+			return valErr("bad time %q for %s, expected duration (1h, 2d) or time (2006-01-02, RFC3339)", t.value, t.key)
+		}
+		if t.key == "since" {
+			r.FromTS = ts
+			return nil
+		}
+		r.ToTS = ts
+	case "severity":
+		if _, err := ParseSeverity(t.value); err != nil {
+			return valErr("%v", err)
+		}
+		r.MinSeverity = t.value
+	case "limit":
+		n, err := strconv.Atoi(t.value)
+		if err != nil || n <= 0 {
+			return valErr("bad limit %q", t.value)
+		}
+		r.Limit = n
+	}
+	return nil
+}
+
+// parseQueryTime gets time from duration back from now (1h, 30m, 2d) or absolute time (RFC3339 or 2006-01-02)
+func parseQueryTime(s string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if ts, err := time.Parse(time.RFC3339, s); err == nil {
+		return ts, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
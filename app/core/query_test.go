@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2019, 5, 24, 20, 54, 30, 0, time.UTC)
+
+	tbl := []struct {
+		inp string
+		out Request
+		err string
+	}{
+		{"", Request{}, ""},
+		{"   ", Request{}, ""},
+		{`host=~/^web/ container!=monit msg:"timeout" since:1h`,
+			Request{Hosts: []string{"/^web/"}, Excludes: []string{"monit"}, Grep: []string{"timeout"}, FromTS: now.Add(-time.Hour)}, ""},
+		{`host=h1 host=h2 host!=h3 container=c1 group=db group=~/^app/`,
+			Request{Hosts: []string{"h1", "h2"}, ExcludeHosts: []string{"h3"}, Containers: []string{"c1"}, Groups: []string{"db", "/^app/"}}, ""},
+		{`group!=db group!=/^sys/ group=app`, Request{Groups: []string{"app"}, ExcludeGroups: []string{"db", "/^sys/"}}, ""},
+		{`msg:/err(or)?\/x/ -msg:"health check" -msg:ping`,
+			Request{Grep: []string{`/err(or)?\/x/`}, UnGrep: []string{"health check", "ping"}}, ""},
+		{`msg:"say \"hi\""`, Request{Grep: []string{`say "hi"`}}, ""},
+		{`search:"req-123" search:timeout`, Request{Search: "req-123 timeout"}, ""},
+		{`since:2d until:30m`, Request{FromTS: now.AddDate(0, 0, -2), ToTS: now.Add(-30 * time.Minute)}, ""},
+		{`since:2019-05-20T10:00:00Z until:2019-05-21T10:00:00Z`,
+			Request{FromTS: time.Date(2019, 5, 20, 10, 0, 0, 0, time.UTC), ToTS: time.Date(2019, 5, 21, 10, 0, 0, 0, time.UTC)}, ""},
+		{`since:2019-05-20`, Request{FromTS: time.Date(2019, 5, 20, 0, 0, 0, 0, time.Local)}, ""},
+		{`severity:err limit:100`, Request{MinSeverity: "err", Limit: 100}, ""},
+		{`level=error user_id!=42 path=~/^\/api/ empty=`,
+			Request{Fields: []string{"level=error", "user_id!=42", `path=/^\/api/`, "empty="}}, ""},
+		{"\tcontainer=c1\t msg:x ", Request{Containers: []string{"c1"}, Grep: []string{"x"}}, ""},
+
+		{`host=h1 foo`, Request{}, `query error at 11: expected =, !=, =~ or : after "foo"`},
+		{`host=h1 =x`, Request{}, `query error at 8: expected key, got "=x"`},
+		{`msg:"abc`, Request{}, `query error at 4: unterminated quoted value`},
+		{`host=~/abc`, Request{}, `query error at 6: unterminated regex`},
+		{`host=~abc`, Request{}, `query error at 6: expected /regex/ for host=~`},
+		{`msg:"abc"def`, Request{}, `query error at 9: unexpected "def" after value`},
+		{`host:h1`, Request{}, `query error at 0: use host=, host!= or host=~ instead of host:`},
+		{`container=c1 host=`, Request{}, `query error at 18: empty value for host`},
+		{`c=1 msg=abc`, Request{}, `query error at 4: use msg: instead of msg=`},
+		{`-host=h1`, Request{}, `query error at 0: negation with - supported for msg: only, got "-host="`},
+		{`foo:bar`, Request{}, `query error at 0: unknown key "foo"`},
+		{`a.b=c`, Request{}, `query error at 0: bad field name in filter "a.b=c"`},
+		{`since:yesterday`, Request{},
+			`query error at 6: bad time "yesterday" for since, expected duration (1h, 2d) or time (2006-01-02, RFC3339)`},
+		{`severity:bad`, Request{}, `query error at 9: unknown severity "bad"`},
+		{`limit:-1`, Request{}, `query error at 6: bad limit "-1"`},
+		{`msg:/[bad/`, Request{}, "query error at 4: bad regex \"/[bad/\": error parsing regexp: missing closing ]: `[bad`"},
+		{`search:`, Request{}, `query error at 7: empty value for search`},
+	}
+
+	for i, tt := range tbl {
+		res, err := parseQuery(tt.inp, now)
+		if tt.err != "" {
+			require.Error(t, err, fmt.Sprintf("expects error in #%d", i))
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			qerr, ok := err.(*QueryError)
+			require.True(t, ok, fmt.Sprintf("expects QueryError in #%d", i))
+			assert.True(t, qerr.Pos >= 0 && qerr.Pos <= len(tt.inp), fmt.Sprintf("bad pos in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("unexpected error in #%d", i))
+		assert.Equal(t, tt.out, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestRequest_WithQuery(t *testing.T) {
+	r := Request{Hosts: []string{"h1"}, Limit: 10, MinSeverity: "info", Query: "host=h2"}
+
+	res, err := r.WithQuery(`host=h2 container=c1 group!=db severity:err`)
+	require.NoError(t, err)
+	assert.Equal(t, Request{Hosts: []string{"h1", "h2"}, Containers: []string{"c1"}, ExcludeGroups: []string{"db"}, Limit: 10,
+		MinSeverity: "err"}, res)
+	assert.Equal(t, []string{"h1"}, r.Hosts, "original request not changed")
+
+	res, err = r.WithQuery(`host=h2 container=`)
+	assert.EqualError(t, err, "query error at 18: empty value for container")
+	assert.Equal(t, r, res)
+
+	res, err = ParseQuery("since:1h")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), res.FromTS, time.Second)
+}
@@ -0,0 +1,13 @@
+package core
+
+import "time"
+
+// Rejected is a raw line failed to parse into LogEntry, kept for inspection and replay
+type Rejected struct {
+	ID         string    `json:"id"`
+	Line       string    `json:"line"`
+	Sender     string    `json:"sender"` // remote address, ip:port
+	ReceivedTS time.Time `json:"received_ts"`
+	Error      string    `json:"error"`    // parsing error
+	Replayed   bool      `json:"replayed"` // parsed and published by replay
+}
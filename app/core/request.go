@@ -2,20 +2,36 @@ package core
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // Request with filters and params for store queries
 // Every filter is optional. If not defined means "any"
 type Request struct {
-	LastID     string    `json:"id"`
-	Limit      int       `json:"max"`                  // max size of response, i.e. number of messages one request can return
-	Hosts      []string  `json:"hosts,omitempty"`      // list of hosts, can be exact match or regex in from of /regex/
-	Containers []string  `json:"containers,omitempty"` // list of containers, can be regex as well
-	Excludes   []string  `json:"excludes,omitempty"`   // list of excluded containers, can be regex
-	FromTS     time.Time `json:"from_ts"`
-	ToTS       time.Time `json:"to_ts"`
+	LastID        string    `json:"id"`
+	Limit         int       `json:"max"`                      // max size of response, i.e. number of messages one request can return
+	Hosts         []string  `json:"hosts,omitempty"`          // list of hosts, can be exact match or regex in from of /regex/
+	ExcludeHosts  []string  `json:"exclude_hosts,omitempty"`  // list of excluded hosts, can be regex
+	Containers    []string  `json:"containers,omitempty"`     // list of containers, can be regex as well
+	Excludes      []string  `json:"excludes,omitempty"`       // list of excluded containers, can be regex
+	Groups        []string  `json:"groups,omitempty"`         // list of container groups, can be regex as well
+	ExcludeGroups []string  `json:"exclude_groups,omitempty"` // list of excluded groups, can be regex. Records without group kept
+	FromTS        time.Time `json:"from_ts"`
+	ToTS          time.Time `json:"to_ts"`
+	MinSeverity   string    `json:"min_severity,omitempty"` // name (err, warning, ...) or number, matches this and more severe levels
+	Fields        []string  `json:"fields,omitempty"`       // field predicates, i.e. level=error, user_id!=42 or level=/err.*/
+	Grep          []string  `json:"grep,omitempty"`         // message contains any of substrings, can be regex as well
+	UnGrep        []string  `json:"ungrep,omitempty"`       // message contains none of substrings, can be regex as well
+	Search        string    `json:"search,omitempty"`       // full text search on message, words and "phrases"
+	Query         string    `json:"q,omitempty"`            // query string, see ParseQuery. Applied on top of other fields
+
+	// scope of the caller, set by server and can't be passed by client. Records have to match scope and all other filters
+	ScopeHosts      []string `json:"-"` // allowed hosts, can be regex
+	ScopeContainers []string `json:"-"` // allowed containers, can be regex
 }
 
 func (r Request) String() string {
@@ -27,6 +43,48 @@ func (r Request) String() string {
 	if !r.ToTS.IsZero() {
 		elems = append(elems, "to="+r.ToTS.Format(time.RFC3339))
 	}
+	if len(r.ExcludeHosts) > 0 {
+		elems = append(elems, fmt.Sprintf("exclude-hosts=%s", r.ExcludeHosts))
+	}
+	if len(r.Groups) > 0 {
+		elems = append(elems, fmt.Sprintf("groups=%s", r.Groups))
+	}
+	if len(r.ExcludeGroups) > 0 {
+		elems = append(elems, fmt.Sprintf("exclude-groups=%s", r.ExcludeGroups))
+	}
+	if r.MinSeverity != "" {
+		elems = append(elems, "min-severity="+r.MinSeverity)
+	}
+	if len(r.Fields) > 0 {
+		elems = append(elems, fmt.Sprintf("fields=%s", r.Fields))
+	}
+	if len(r.Grep) > 0 {
+		elems = append(elems, fmt.Sprintf("grep=%s", r.Grep))
+	}
+	if len(r.UnGrep) > 0 {
+		elems = append(elems, fmt.Sprintf("ungrep=%s", r.UnGrep))
+	}
+	if len(r.ScopeHosts) > 0 || len(r.ScopeContainers) > 0 {
+		elems = append(elems, fmt.Sprintf("scope=%s/%s", r.ScopeHosts, r.ScopeContainers))
+	}
+	if r.Search != "" {
+		elems = append(elems, fmt.Sprintf("search=%q", r.Search))
+	}
+	if r.Query != "" {
+		elems = append(elems, fmt.Sprintf("q=%q", r.Query))
+	}
 	elems = append(elems, "last-id="+r.LastID)
 	return strings.Join(elems, ", ")
 }
+
+// GrepPattern makes regex pattern for grep string. Substring quoted, /regex/ checked and returned without slashes
+func GrepPattern(s string) (string, error) {
+	if len(s) > 1 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re := s[1 : len(s)-1]
+		if _, err := regexp.Compile(re); err != nil {
+			return "", errors.Wrapf(err, "bad regex %q", s)
+		}
+		return re, nil
+	}
+	return regexp.QuoteMeta(s), nil
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -19,3 +20,52 @@ func TestRequest_String(t *testing.T) {
 	}
 	assert.Equal(t, "hosts=[h1, h2], containers=[c1 c2 c3], excludes=[monit], max=1000, from=2019-05-25T02:57:45Z, to=2019-05-25T06:57:45Z, last-id=111", r.String())
 }
+
+func TestRequest_StringWithSeverity(t *testing.T) {
+	r := Request{Containers: []string{"c1"}, MinSeverity: "err"}
+	assert.Equal(t, "hosts=[], containers=[c1], excludes=[], max=0, min-severity=err, last-id=", r.String())
+}
+
+func TestRequest_StringWithGroups(t *testing.T) {
+	r := Request{Containers: []string{"c1"}, Groups: []string{"db", "/app.*/"}, ExcludeGroups: []string{"sys"}}
+	assert.Equal(t, "hosts=[], containers=[c1], excludes=[], max=0, groups=[db /app.*/], exclude-groups=[sys], last-id=", r.String())
+}
+
+func TestRequest_StringWithFields(t *testing.T) {
+	r := Request{Containers: []string{"c1"}, Fields: []string{"level=error", "user_id!=42"}}
+	assert.Equal(t, "hosts=[], containers=[c1], excludes=[], max=0, fields=[level=error user_id!=42], last-id=", r.String())
+}
+
+func TestRequest_StringWithGrep(t *testing.T) {
+	r := Request{Grep: []string{"abc", "/err.*/"}, UnGrep: []string{"health"}, Search: "req-123 timeout"}
+	assert.Equal(t, `hosts=[], containers=[], excludes=[], max=0, grep=[abc /err.*/], ungrep=[health], search="req-123 timeout", last-id=`,
+		r.String())
+}
+
+func TestGrepPattern(t *testing.T) {
+	tbl := []struct {
+		inp, out string
+		err      bool
+	}{
+		{"abc", "abc", false},
+		{"a.b [c]", `a\.b \[c\]`, false},
+		{"/err.*/", "err.*", false},
+		{"/", "/", false},
+		{"//", "", false},
+		{"/[bad/", "", true},
+	}
+	for i, tt := range tbl {
+		res, err := GrepPattern(tt.inp)
+		if tt.err {
+			assert.Error(t, err, fmt.Sprintf("expects error in #%d", i))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("unexpected error in #%d", i))
+		assert.Equal(t, tt.out, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestRequest_StringWithQuery(t *testing.T) {
+	r := Request{ExcludeHosts: []string{"h2"}, Query: `msg:"abc"`}
+	assert.Equal(t, `hosts=[], containers=[], excludes=[], max=0, exclude-hosts=[h2], q="msg:\"abc\"", last-id=`, r.String())
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const nilValue = "-" // rfc5424 NILVALUE
+
+// isRFC5424 detects rfc5424 header (with <PRI> already removed), i.e. "1 2019-10-19T15:29:43.003Z host ..."
+func isRFC5424(line string) bool {
+	if len(line) < 3 || !strings.HasPrefix(line, "1 ") {
+		return false
+	}
+	return line[2] == '-' || (line[2] >= '0' && line[2] <= '9')
+}
+
+// newEntryRFC5424 makes LogEntry from rfc5424 line, without <PRI> prefix.
+// format: VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+// app-name mapped to group and container (docker/ prefix removed), procid to pid. Structured data kept in StructData.
+func newEntryRFC5424(line string, tz *time.Location) (entry LogEntry, err error) {
+	entry = LogEntry{Container: "syslog", Pid: 0, CreatedTS: time.Now()}
+
+	hdr := strings.SplitN(line, " ", 7)
+	if len(hdr) < 7 {
+		return entry, fmt.Errorf("incomplete rfc5424 header in line=[%s]", line)
+	}
+	tsStr, host, appName, procID, rest := hdr[1], hdr[2], hdr[3], hdr[4], hdr[6]
+
+	entry.TS = entry.CreatedTS.In(tz)
+	if tsStr != nilValue {
+		ts, e := time.Parse(time.RFC3339Nano, tsStr)
+		if e != nil {
+			return entry, errors.Wrapf(e, "can't extract time from %q", line)
+		}
+		entry.TS = ts.In(tz)
+	}
+
+	if host != nilValue {
+		entry.Host = host
+	}
+
+	if appName != nilValue {
+		entry.Container = appName
+		if group, container, _, ok := parseDockerTag(appName); ok {
+			entry.Group, entry.Container = group, container
+		}
+	}
+
+	if pid, e := strconv.Atoi(procID); e == nil {
+		entry.Pid = pid
+	}
+
+	sd, msg, err := parseStructData(rest)
+	if err != nil {
+		return entry, errors.Wrapf(err, "can't parse structured data in line=[%s]", line)
+	}
+	entry.StructData = sd
+	entry.Msg = strings.TrimRight(strings.TrimPrefix(strings.TrimPrefix(msg, " "), "\ufeff"), " \t\r\n") // msg may start with utf8 BOM
+	return entry, nil
+}
+
+// parseStructData parses STRUCTURED-DATA part, i.e. `[id1 k1="v1" k2="v2"][id2 k="v"] message`
+// returns parsed elements and the rest of the line (message)
+func parseStructData(inp string) (sd map[string]map[string]string, msg string, err error) {
+	if inp == nilValue || strings.HasPrefix(inp, nilValue+" ") {
+		return nil, strings.TrimPrefix(inp, nilValue), nil
+	}
+
+	sd = map[string]map[string]string{}
+	pos := 0
+	for pos < len(inp) && inp[pos] == '[' {
+		end, id, params, e := parseSDElement(inp, pos)
+		if e != nil {
+			return nil, "", e
+		}
+		sd[id] = params
+		pos = end
+	}
+	if len(sd) == 0 {
+		return nil, "", fmt.Errorf("unexpected structured data %q", inp)
+	}
+	if pos < len(inp) && inp[pos] != ' ' { // structured data has to be followed by space or end of line
+		return nil, "", fmt.Errorf("no space after structured data at %d", pos)
+	}
+	return sd, inp[pos:], nil
+}
+
+// parseSDElement parses single "[id k="v" ...]" element started at pos, returns position after closing bracket
+func parseSDElement(inp string, pos int) (end int, id string, params map[string]string, err error) {
+	pos++ // skip [
+	idEnd := strings.IndexAny(inp[pos:], " ]")
+	if idEnd <= 0 {
+		return 0, "", nil, fmt.Errorf("no SD-ID at %d", pos)
+	}
+	id = inp[pos : pos+idEnd]
+	pos += idEnd
+	params = map[string]string{}
+
+	for pos < len(inp) {
+		switch inp[pos] {
+		case ']':
+			return pos + 1, id, params, nil
+		case ' ':
+			pos++
+			continue
+		}
+
+		// PARAM-NAME can't contain space, "=", "]" or quote and has to be followed by ="
+		eq := strings.IndexAny(inp[pos:], ` =]"`)
+		if eq <= 0 || inp[pos+eq] != '=' || !strings.HasPrefix(inp[pos+eq:], `="`) {
+			return 0, "", nil, fmt.Errorf("bad SD-PARAM at %d", pos)
+		}
+		name := inp[pos : pos+eq]
+		pos += eq + 2 // skip name and ="
+
+		val := strings.Builder{}
+		closed := false
+		for pos < len(inp) && !closed {
+			c := inp[pos]
+			switch {
+			case c == '\\' && pos+1 < len(inp) && strings.IndexByte(`"\]`, inp[pos+1]) >= 0:
+				val.WriteByte(inp[pos+1])
+				pos += 2
+			case c == '"':
+				closed = true
+				pos++
+			default:
+				val.WriteByte(c)
+				pos++
+			}
+		}
+		if !closed {
+			return 0, "", nil, fmt.Errorf("unterminated value for %s", name)
+		}
+		params[name] = val.String()
+	}
+	return 0, "", nil, fmt.Errorf("unterminated SD element %s", id)
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// syslog severity levels, rfc5424 section 6.2.1
+const (
+	SevEmerg = iota
+	SevAlert
+	SevCrit
+	SevErr
+	SevWarning
+	SevNotice
+	SevInfo
+	SevDebug
+)
+
+// default priority for lines without <PRI>, "user.notice" as suggested by rfc3164 section 4.3.3
+const (
+	defFacility = 1
+	defSeverity = SevNotice
+)
+
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// SeverityName returns short name of severity level, i.e. "err" for 3
+func SeverityName(sev int) string {
+	if sev < 0 || sev >= len(severityNames) {
+		return strconv.Itoa(sev)
+	}
+	return severityNames[sev]
+}
+
+// ParseSeverity gets severity level from the name (err, warning, ...) or number (0-7)
+func ParseSeverity(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if n, err := strconv.Atoi(s); err == nil && n >= SevEmerg && n <= SevDebug {
+		return n, nil
+	}
+	for i, name := range severityNames {
+		if s == name {
+			return i, nil
+		}
+	}
+	switch s { // common aliases
+	case "error":
+		return SevErr, nil
+	case "warn":
+		return SevWarning, nil
+	}
+	return 0, fmt.Errorf("unknown severity %q", s)
+}
+
+// parsePriority extracts facility and severity from the "<PRI>" prefix and returns the rest of the line.
+// ok is false if line doesn't start with valid <PRI>.
+func parsePriority(line string) (facility, severity int, rest string, ok bool) {
+	if !strings.HasPrefix(line, "<") {
+		return 0, 0, line, false
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 { // PRI is 1-3 digits
+		return 0, 0, line, false
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, 0, line, false
+	}
+	return pri / 8, pri % 8, line[end+1:], true
+}
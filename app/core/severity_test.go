@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSeverity(t *testing.T) {
+	tbl := []struct {
+		inp string
+		out int
+		err string
+	}{
+		{"err", SevErr, ""},
+		{"ERROR", SevErr, ""},
+		{" warn ", SevWarning, ""},
+		{"debug", SevDebug, ""},
+		{"0", SevEmerg, ""},
+		{"6", SevInfo, ""},
+		{"8", 0, `unknown severity "8"`},
+		{"-1", 0, `unknown severity "-1"`},
+		{"blah", 0, `unknown severity "blah"`},
+		{"", 0, `unknown severity ""`},
+	}
+	for n, tt := range tbl {
+		sev, err := ParseSeverity(tt.inp)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("expects error in #%d", n))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("unexpected error in #%d", n))
+		assert.Equal(t, tt.out, sev, fmt.Sprintf("mismatch in #%d", n))
+	}
+}
+
+func TestSeverityName(t *testing.T) {
+	assert.Equal(t, "emerg", SeverityName(SevEmerg))
+	assert.Equal(t, "err", SeverityName(SevErr))
+	assert.Equal(t, "debug", SeverityName(SevDebug))
+	assert.Equal(t, "12", SeverityName(12))
+}
+
+func TestNewEntry_Priority(t *testing.T) {
+	tbl := []struct {
+		inp                string
+		facility, severity int
+		msg                string
+	}{
+		{"<30>2017-05-30T16:13:35-04:00 BigMac.local docker/test123[63415]: stdout msg", 3, SevInfo, "stdout msg"},
+		{"<27>2017-05-30T16:13:35-04:00 BigMac.local docker/test123[63415]: stderr msg", 3, SevErr, "stderr msg"},
+		{"<0>May 30 18:03:27 dev-1 docker[1187]: kern emerg", 0, SevEmerg, "kern emerg"},
+		{"<191>May 30 18:03:27 dev-1 docker[1187]: local7 debug", 23, SevDebug, "local7 debug"},
+		{"May 30 18:03:27 dev-1 docker[1187]: no pri", 1, SevNotice, "no pri"},
+	}
+	for n, tt := range tbl {
+		entry, err := NewEntry(tt.inp, time.UTC)
+		require.NoError(t, err, fmt.Sprintf("unexpected error in #%d", n))
+		assert.Equal(t, tt.facility, entry.Facility, fmt.Sprintf("facility mismatch in #%d", n))
+		assert.Equal(t, tt.severity, entry.Severity, fmt.Sprintf("severity mismatch in #%d", n))
+		assert.Equal(t, tt.msg, entry.Msg, fmt.Sprintf("msg mismatch in #%d", n))
+	}
+
+	// invalid PRI is not a priority, kept as a part of the line
+	for _, line := range []string{"<192>May 30 18:03:27 dev-1 docker[1187]: msg", "<x>May 30 18:03:27 dev-1 docker[1187]: msg",
+		"<1234>May 30 18:03:27 dev-1 docker[1187]: msg"} {
+		_, err := NewEntry(line, time.UTC)
+		assert.Error(t, err, line)
+	}
+}
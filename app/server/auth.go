@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// Auth checks credentials of rest requests, static bearer tokens and basic auth users with bcrypt passwords.
+// Credential with scope limited to its hosts and containers.
+type Auth struct {
+	tokens map[[sha256.Size]byte]Credential // by hash of token
+	users  map[string]Credential
+
+	lock     sync.Mutex
+	verified map[string][sha256.Size]byte // user to hash of verified password, bcrypt is slow for every request
+}
+
+// Credential is a token or user with optional scope. Empty scope allows everything.
+type Credential struct {
+	Name       string
+	Hosts      []string // allowed hosts, can be regex
+	Containers []string // allowed containers, can be regex
+	hash       []byte   // bcrypt hash of user's password
+}
+
+type credentialCtxKey struct{}
+
+// LoadAuth reads users and tokens from file, see ParseAuth for format
+func LoadAuth(fileName string) (*Auth, error) {
+	fh, err := os.Open(fileName) //nolint:gosec // file name from server's options
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open auth file %s", fileName)
+	}
+	defer fh.Close() // nolint
+	res, err := ParseAuth(fh)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't load auth file %s", fileName)
+	}
+	log.Printf("[INFO] loaded %d users and %d tokens from %s", len(res.users), len(res.tokens), fileName)
+	return res, nil
+}
+
+// ParseAuth reads users and tokens, one per line. Empty lines and lines started with # ignored.
+// User is htpasswd line with bcrypt password, i.e. made by "htpasswd -nB user", token line is "token:name:value".
+// Both can have optional scope after ":", i.e. "user:$2y$05$...:hosts=h1,/^web/;containers=nginx".
+func ParseAuth(r io.Reader) (*Auth, error) {
+	res := &Auth{tokens: map[[sha256.Size]byte]Credential{}, users: map[string]Credential{}, verified: map[string][sha256.Size]byte{}}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "token:") {
+			elems := strings.SplitN(line, ":", 4)
+			if len(elems) < 3 || elems[1] == "" || elems[2] == "" {
+				return nil, errors.Errorf("line %d, expected token:name:value[:scope]", n)
+			}
+			cred := Credential{Name: elems[1]}
+			if len(elems) == 4 {
+				if err := cred.parseScope(elems[3]); err != nil {
+					return nil, errors.Wrapf(err, "line %d", n)
+				}
+			}
+			key := sha256.Sum256([]byte(elems[2]))
+			if _, found := res.tokens[key]; found {
+				return nil, errors.Errorf("line %d, duplicate token %s", n, cred.Name)
+			}
+			res.tokens[key] = cred
+			continue
+		}
+
+		elems := strings.SplitN(line, ":", 3)
+		if len(elems) < 2 || elems[0] == "" {
+			return nil, errors.Errorf("line %d, expected user:bcrypt-hash[:scope]", n)
+		}
+		cred := Credential{Name: elems[0], hash: []byte(elems[1])}
+		if _, err := bcrypt.Cost(cred.hash); err != nil {
+			return nil, errors.Wrapf(err, "line %d, bad bcrypt hash of user %s", n, cred.Name)
+		}
+		if len(elems) == 3 {
+			if err := cred.parseScope(elems[2]); err != nil {
+				return nil, errors.Wrapf(err, "line %d", n)
+			}
+		}
+		if _, found := res.users[cred.Name]; found {
+			return nil, errors.Errorf("line %d, duplicate user %s", n, cred.Name)
+		}
+		res.users[cred.Name] = cred
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "can't read auth")
+	}
+	return res, nil
+}
+
+// Middleware rejects requests without valid token or user, and keeps credential in request's context.
+// Token passed as "Authorization: Bearer <token>" header or token query param, for browser's EventSource and WebSocket.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred, ok := a.check(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dkll"`)
+			rest.SendErrorJSON(w, r, log.Default(), http.StatusUnauthorized, errors.New("unauthorized"), "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), credentialCtxKey{}, cred)))
+	})
+}
+
+func (a *Auth) check(r *http.Request) (Credential, bool) {
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	if token != "" {
+		cred, ok := a.tokens[sha256.Sum256([]byte(token))]
+		return cred, ok
+	}
+
+	user, passwd, ok := r.BasicAuth()
+	if !ok {
+		return Credential{}, false
+	}
+	cred, ok := a.users[user]
+	if !ok {
+		return Credential{}, false
+	}
+	passwdHash := sha256.Sum256([]byte(passwd))
+	a.lock.Lock()
+	verified, found := a.verified[user]
+	a.lock.Unlock()
+	if found && subtle.ConstantTimeCompare(verified[:], passwdHash[:]) == 1 {
+		return cred, true
+	}
+	if err := bcrypt.CompareHashAndPassword(cred.hash, []byte(passwd)); err != nil {
+		return Credential{}, false
+	}
+	a.lock.Lock()
+	a.verified[user] = passwdHash
+	a.lock.Unlock()
+	return cred, true
+}
+
+// Scoped checks if credential limited to some hosts or containers
+func (c Credential) Scoped() bool {
+	return len(c.Hosts) > 0 || len(c.Containers) > 0
+}
+
+// Apply sets credential's scope to request
+func (c Credential) Apply(req core.Request) core.Request {
+	req.ScopeHosts, req.ScopeContainers = c.Hosts, c.Containers
+	return req
+}
+
+// parseScope parses "hosts=h1,/^web/;containers=c1"
+func (c *Credential) parseScope(s string) error {
+	for _, part := range strings.Split(s, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || val == "" {
+			return errors.Errorf("bad scope %q, expected hosts=h1,h2;containers=c1", part)
+		}
+		vals := strings.Split(val, ",")
+		for _, v := range vals {
+			if _, err := core.NewMatcher(core.Request{Hosts: []string{v}}); err != nil {
+				return err
+			}
+		}
+		switch key {
+		case "hosts":
+			c.Hosts = append(c.Hosts, vals...)
+		case "containers":
+			c.Containers = append(c.Containers, vals...)
+		default:
+			return errors.Errorf("unknown scope %q, expected hosts or containers", key)
+		}
+	}
+	return nil
+}
+
+// credential returns credential of authenticated request, no credential means auth disabled
+func credential(r *http.Request) (Credential, bool) {
+	cred, ok := r.Context().Value(credentialCtxKey{}).(Credential)
+	return cred, ok
+}
+
+func (c Credential) String() string {
+	if !c.Scoped() {
+		return c.Name
+	}
+	return fmt.Sprintf("%s (hosts=%v, containers=%v)", c.Name, c.Hosts, c.Containers)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestParseAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("passwd"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	tbl := []struct {
+		inp string
+		err string
+	}{
+		{"", ""},
+		{"# comment\n\nadmin:" + string(hash) + "\ntoken:ci:secret\n", ""},
+		{"team:" + string(hash) + ":hosts=h1,/^web/;containers=nginx\ntoken:ci:secret:containers=app", ""},
+		{"admin", "line 1, expected user:bcrypt-hash[:scope]"},
+		{"admin:plain", "line 1, bad bcrypt hash of user admin: crypto/bcrypt: hashedSecret too short to be a bcrypted password"},
+		{"token:ci", "line 1, expected token:name:value[:scope]"},
+		{"token:ci:secret\ntoken:ci2:secret", "line 2, duplicate token ci2"},
+		{"admin:" + string(hash) + "\nadmin:" + string(hash), "line 2, duplicate user admin"},
+		{"token:ci:secret:groups=g1", `line 1: unknown scope "groups", expected hosts or containers`},
+		{"token:ci:secret:hosts", `line 1: bad scope "hosts", expected hosts=h1,h2;containers=c1`},
+		{"token:ci:secret:hosts=/[bad/", "line 1: bad regex \"/[bad/\": error parsing regexp: missing closing ]: `[bad`"},
+	}
+	for i, tt := range tbl {
+		_, err := ParseAuth(strings.NewReader(tt.inp))
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	fname := filepath.Join(t.TempDir(), "auth")
+	require.NoError(t, os.WriteFile(fname, []byte("token:ci:secret:hosts=h1\n"), 0o600))
+	auth, err := LoadAuth(fname)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(auth.tokens))
+	_, err = LoadAuth(filepath.Join(t.TempDir(), "nothing"))
+	assert.Error(t, err)
+}
+
+func TestAuth_Rest(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("passwd"), bcrypt.MinCost)
+	require.NoError(t, err)
+	auth, err := ParseAuth(strings.NewReader("admin:" + string(hash) + "\nteam:" + string(hash) + ":hosts=h2\n" +
+		"token:ci:secret\ntoken:team-ci:team-secret:containers=/^c1$/\n"))
+	require.NoError(t, err)
+
+	mem := NewMemory(100)
+	require.NoError(t, mem.Publish(testRecords()))
+	srv := RestServer{DataService: mem, Limit: 100, Auth: auth, Stats: &Stats{}, Hub: NewHub(0)}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	find := func(setAuth func(r *http.Request), query string) (status int, msgs []string) {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/find"+query, bytes.NewBufferString(`{"hosts":["/h/"]}`))
+		require.NoError(t, err)
+		setAuth(req)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		recs := []core.LogEntry{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&recs))
+		for _, r := range recs {
+			msgs = append(msgs, r.Msg)
+		}
+		return resp.StatusCode, msgs
+	}
+	basic := func(user, passwd string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, passwd) }
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	all := []string{"msg1", "msg2", "msg3", "msg4", "msg5", "msg6"}
+
+	tbl := []struct {
+		auth   func(r *http.Request)
+		query  string
+		status int
+		msgs   []string
+	}{
+		{func(*http.Request) {}, "", http.StatusUnauthorized, nil},
+		{basic("admin", "passwd"), "", http.StatusOK, all},
+		{basic("admin", "passwd"), "", http.StatusOK, all}, // verified password cached
+		{basic("admin", "bad"), "", http.StatusUnauthorized, nil},
+		{basic("unknown", "passwd"), "", http.StatusUnauthorized, nil},
+		{basic("team", "passwd"), "", http.StatusOK, []string{"msg3", "msg6"}},
+		{basic("team", "passwd"), "?q=container=c1", http.StatusOK, []string{"msg3"}},
+		{bearer("secret"), "", http.StatusOK, all},
+		{bearer("bad"), "", http.StatusUnauthorized, nil},
+		{bearer("team-secret"), "", http.StatusOK, []string{"msg1", "msg3", "msg4"}},
+		{func(*http.Request) {}, "?token=team-secret", http.StatusOK, []string{"msg1", "msg3", "msg4"}},
+	}
+	for i, tt := range tbl {
+		status, msgs := find(tt.auth, tt.query)
+		assert.Equal(t, tt.status, status, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.msgs, msgs, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	get := func(path, token string) (int, string) {
+		req, err := http.NewRequest("GET", ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint
+		last := core.LogEntry{}
+		_ = json.NewDecoder(resp.Body).Decode(&last)
+		return resp.StatusCode, last.Msg
+	}
+	status, msg := get("/v1/last", "secret")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "msg6", msg)
+	status, msg = get("/v1/last", "team-secret")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "msg4", msg, "the latest in scope")
+	status, _ = get("/v1/stats", "secret")
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = get("/v1/stats", "team-secret")
+	assert.Equal(t, http.StatusForbidden, status, "not for scoped")
+	status, _ = get("/v1/events", "bad")
+	assert.Equal(t, http.StatusUnauthorized, status, "streams require auth too")
+
+	resp, err := http.Get(ts.URL + "/ping")
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "ping without auth")
+}
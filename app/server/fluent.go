@@ -0,0 +1,345 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// Fluent server receives events with fluent forward protocol, i.e. from docker's fluentd logging driver, fluentd or
+// fluent-bit, see https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1. Message, Forward,
+// PackedForward and gzip CompressedPackedForward modes supported, chunk acknowledged if requested.
+// Shared key handshake is not supported.
+type Fluent struct {
+	Port           int
+	MaxMessageSize int            // longer messages rejected, compressed and decompressed, 16M by default
+	QueueSize      int            // size of entries queue, 10000 by default
+	Overflow       OverflowPolicy // what to do with new entry if queue is full, block by default
+	Stats          *Stats         // optional, counts received, parsed, rejected and dropped events
+	Senders        *SenderFilter  // optional, rejects or tags connections from denied and unknown senders
+}
+
+const (
+	defFluentMaxMessageSize = 16 * 1024 * 1024
+	fluentAckTimeout        = 10 * time.Second
+	fluentEventTimeExt      = 0 // msgpack extension type of EventTime
+)
+
+// fluentMessage is a decoded forward protocol message
+type fluentMessage struct {
+	tag    string
+	events []fluentEvent
+	bad    int    // malformed events skipped
+	chunk  string // chunk id to acknowledge, empty if ack not requested
+}
+
+type fluentEvent struct {
+	ts     time.Time
+	record map[string]string
+}
+
+// Go starts fluent server in background and returns channel with entries
+func (f *Fluent) Go(ctx context.Context) (<-chan core.LogEntry, error) {
+	log.Printf("[INFO] activate fluent server on %d", f.Port)
+	if f.QueueSize <= 0 {
+		f.QueueSize = defQueueSize
+	}
+	if f.Stats == nil {
+		f.Stats = &Stats{}
+	}
+	if f.MaxMessageSize <= 0 {
+		f.MaxMessageSize = defFluentMaxMessageSize
+	}
+	if f.Overflow == "" {
+		f.Overflow = OverflowBlock
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", f.Port))
+	if err != nil {
+		return nil, errors.Wrapf(err, "fluent can't listen on %d", f.Port)
+	}
+
+	outCh := make(chan core.LogEntry, f.QueueSize)
+	registerQueue(f.Stats, statsStageFluent, outCh)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		acceptConns(ctx, listener, f.Senders, f.Stats, func(conn net.Conn, unknown bool) {
+			f.readConn(ctx, conn, unknown, outCh)
+		})
+	}()
+
+	go func() {
+		<-ctx.Done()
+		log.Print("[DEBUG] fluent termination requested")
+		if err := listener.Close(); err != nil {
+			log.Printf("[WARN] failed to close fluent listener, %v", err)
+		}
+		<-done
+		close(outCh)
+		log.Print("[INFO] fluent server terminated")
+	}()
+	return outCh, nil
+}
+
+// readConn reads forward messages till connection closed or ctx done. Malformed msgpack closes connection
+// as the stream can't be synced back, malformed message or event rejected.
+func (f *Fluent) readConn(ctx context.Context, conn net.Conn, unknown bool, outCh chan core.LogEntry) {
+	defer conn.Close() // nolint
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	sender := conn.RemoteAddr().String()
+	dec := &msgpackDecoder{rd: bufio.NewReader(conn), maxSize: f.MaxMessageSize}
+	for {
+		v, err := dec.Decode()
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				log.Printf("[WARN] fluent connection from %s closed, %v", sender, err)
+			}
+			return
+		}
+
+		msg, err := parseFluentMessage(v, f.MaxMessageSize)
+		if err != nil {
+			log.Printf("[WARN] bad fluent message from %s, %v", sender, err)
+			f.Stats.Received.Add(1)
+			f.Stats.Rejected.Add(1)
+			continue
+		}
+		if msg.bad > 0 {
+			log.Printf("[WARN] %d bad events in fluent message from %s", msg.bad, sender)
+			f.Stats.Received.Add(int64(msg.bad))
+			f.Stats.Rejected.Add(int64(msg.bad))
+		}
+		for _, ev := range msg.events {
+			f.handle(ctx, msg.tag, ev, sender, unknown, outCh)
+		}
+
+		if msg.chunk != "" { // acknowledged after events queued
+			ack := msgpackAppendString(msgpackAppendString([]byte{0x81}, "ack"), msg.chunk) // {"ack": chunk}
+			_ = conn.SetWriteDeadline(time.Now().Add(fluentAckTimeout))
+			if _, err := conn.Write(ack); err != nil {
+				log.Printf("[WARN] can't send fluent ack to %s, %v", sender, err)
+				return
+			}
+		}
+	}
+}
+
+// handle makes entry from event and sends it to outCh. Event with bad host or container rejected
+func (f *Fluent) handle(ctx context.Context, tag string, ev fluentEvent, sender string, unknown bool, outCh chan core.LogEntry) {
+	f.Stats.Received.Add(1)
+	ent := core.NewEntryFluent(tag, ev.ts, ev.record)
+	if ip, ok := senderIP(sender); ok {
+		ent.Sender = ip.String()
+	}
+	if ent.Host == "" {
+		ent.Host = ent.Sender
+	}
+	if err := checkEntryNames(ent); err != nil { // host and container, maybe from tag, used as file logger's path
+		log.Printf("[WARN] bad fluent event from %s, tag %q, %v", sender, tag, err)
+		f.Stats.Rejected.Add(1)
+		return
+	}
+	f.Stats.Parsed.Add(1)
+
+	if unknown {
+		if ent.Fields == nil {
+			ent.Fields = map[string]string{}
+		}
+		ent.Fields[unknownSenderField] = "true"
+	}
+	// blocked sender released on ctx done, out channel closed after all senders stopped
+	sendCtx(ctx, outCh, ent, f.Overflow, f.Stats, statsStageFluent)
+}
+
+// parseFluentMessage detects mode of decoded message and extracts events:
+//
+//	Message:       [tag, time, record, option]
+//	Forward:       [tag, [[time, record], ...], option]
+//	PackedForward: [tag, packed [time, record] entries, option], gzipped with option compressed="gzip"
+//
+// option is optional, time is integer, float or EventTime, [time, metadata] of fluent-bit accepted too.
+func parseFluentMessage(v any, maxSize int) (msg fluentMessage, err error) {
+	arr, ok := v.([]any)
+	if !ok || len(arr) < 2 {
+		return msg, errors.New("not an array of tag and events")
+	}
+	if msg.tag, ok = msgpackString(arr[0]); !ok {
+		return msg, errors.Errorf("bad tag %v", arr[0])
+	}
+
+	optIdx := 2
+	switch entries := arr[1].(type) {
+	case []any:
+		for _, e := range entries {
+			msg.addEvent(e)
+		}
+	case string, []byte:
+		opts := fluentOptions(arr, optIdx)
+		data, _ := msgpackString(entries)
+		if err = msg.unpack([]byte(data), opts["compressed"] == "gzip", maxSize); err != nil {
+			return msg, err
+		}
+	default:
+		if len(arr) < 3 {
+			return msg, errors.New("no record in message")
+		}
+		msg.addEvent(arr[1:3])
+		optIdx = 3
+	}
+	if chunk, ok := msgpackString(fluentOptions(arr, optIdx)["chunk"]); ok {
+		msg.chunk = chunk
+	}
+	return msg, nil
+}
+
+// unpack decodes concatenated [time, record] entries of packed forward mode
+func (m *fluentMessage) unpack(data []byte, compressed bool, maxSize int) error {
+	var rd io.Reader = bytes.NewReader(data)
+	if compressed {
+		gz, err := gzip.NewReader(rd)
+		if err != nil {
+			return errors.Wrap(err, "bad compressed entries")
+		}
+		defer gz.Close() // nolint
+		rd = gz
+	}
+	dec := &msgpackDecoder{rd: bufio.NewReader(rd), maxSize: maxSize}
+	total := 0
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "bad packed entries")
+		}
+		if total += dec.read; total > maxSize {
+			return errors.Errorf("unpacked entries are more than %d", maxSize)
+		}
+		m.addEvent(e)
+	}
+}
+
+// addEvent adds [time, record] entry, malformed one counted as bad
+func (m *fluentMessage) addEvent(v any) {
+	e, ok := v.([]any)
+	if !ok || len(e) < 2 {
+		m.bad++
+		return
+	}
+	ts, ok := fluentTime(e[0])
+	rec, isMap := e[1].(map[string]any)
+	if !ok || !isMap {
+		m.bad++
+		return
+	}
+	record := make(map[string]string, len(rec))
+	for k, v := range rec {
+		record[k] = fluentValue(v)
+	}
+	m.events = append(m.events, fluentEvent{ts: ts, record: record})
+}
+
+// fluentOptions returns option map at idx, nil if missing
+func fluentOptions(arr []any, idx int) map[string]any {
+	if len(arr) <= idx {
+		return nil
+	}
+	opts, _ := arr[idx].(map[string]any)
+	return opts
+}
+
+// fluentTime converts unix time in seconds or EventTime with nanoseconds. Time of fluent-bit's [time, metadata]
+// taken from its first element.
+func fluentTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case int64:
+		return time.Unix(t, 0), true
+	case uint64:
+		if t > math.MaxInt64 {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(t), 0), true
+	case float64:
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*1e9)).Round(time.Microsecond), true
+	case msgpackExt:
+		if t.Type != fluentEventTimeExt || len(t.Data) != 8 {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(t.Data[:4])), int64(binary.BigEndian.Uint32(t.Data[4:]))), true
+	case []any:
+		if len(t) == 0 {
+			return time.Time{}, false
+		}
+		return fluentTime(t[0])
+	}
+	return time.Time{}, false
+}
+
+// fluentValue converts record value to string, arrays and maps to json
+func fluentValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case []any, map[string]any:
+		data, err := json.Marshal(fluentPlain(val))
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return string(data)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// fluentPlain makes nested value json friendly, bin as string instead of base64
+func fluentPlain(v any) any {
+	switch val := v.(type) {
+	case []byte:
+		return string(val)
+	case msgpackExt:
+		return fmt.Sprintf("%v", val)
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return fmt.Sprintf("%v", val)
+		}
+		return val
+	case []any:
+		res := make([]any, len(val))
+		for i, e := range val {
+			res[i] = fluentPlain(e)
+		}
+		return res
+	case map[string]any:
+		res := make(map[string]any, len(val))
+		for k, e := range val {
+			res[k] = fluentPlain(e)
+		}
+		return res
+	}
+	return v
+}
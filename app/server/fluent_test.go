@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestFluent(t *testing.T) {
+	stats := &Stats{}
+	f := Fluent{Port: 24225, Stats: stats}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := f.Go(ctx)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:24225")
+	require.NoError(t, err)
+	defer conn.Close() // nolint
+	next := func() core.LogEntry {
+		select {
+		case ent := <-ch:
+			return ent
+		case <-time.After(time.Second):
+			require.Fail(t, "no entry")
+		}
+		return core.LogEntry{}
+	}
+	write := func(v any) {
+		_, e := conn.Write(msgpackEncode(t, v))
+		require.NoError(t, e)
+	}
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 123456789, time.UTC)
+
+	// message mode from docker's fluentd logging driver with ack requested
+	write([]any{"docker.abc123", fluentEventTime(ts), map[string]any{"log": "msg1", "container_name": "/nginx",
+		"container_id": "abc123", "source": "stderr"}, map[string]any{"chunk": "c1"}})
+	ent := next()
+	assert.Equal(t, "127.0.0.1", ent.Host)
+	assert.Equal(t, "127.0.0.1", ent.Sender)
+	assert.Equal(t, "nginx", ent.Container)
+	assert.Equal(t, "msg1", ent.Msg)
+	assert.Equal(t, core.SevErr, ent.Severity)
+	assert.True(t, ts.Equal(ent.TS))
+	assert.Equal(t, map[string]string{"container_id": "abc123", "source": "stderr", "tag": "docker.abc123"}, ent.Fields)
+	ack := make([]byte, 8)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(ack)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x81, 0xa3, 'a', 'c', 'k', 0xa2, 'c', '1'}, ack)
+
+	// forward mode with a bad event, fluent-bit's [time, metadata]
+	write([]any{"app", []any{
+		[]any{int64(1558731270), map[string]any{"log": "msg2", "host": "h1"}},
+		[]any{[]any{fluentEventTime(ts), map[string]any{}}, map[string]any{"log": "msg3", "host": "h1"}},
+		[]any{"bad time", map[string]any{"log": "msg4"}},
+	}})
+	ent = next()
+	assert.Equal(t, "msg2", ent.Msg)
+	assert.Equal(t, "h1", ent.Host)
+	assert.Equal(t, "app", ent.Container)
+	assert.Equal(t, time.Unix(1558731270, 0), ent.TS)
+	assert.Equal(t, "msg3", next().Msg)
+
+	// packed forward mode, plain and gzipped
+	packed := append(msgpackEncode(t, []any{int64(1), map[string]any{"log": "msg5"}}),
+		msgpackEncode(t, []any{int64(2), map[string]any{"log": "msg6"}})...)
+	write([]any{"app", packed})
+	assert.Equal(t, "msg5", next().Msg)
+	assert.Equal(t, "msg6", next().Msg)
+	write([]any{"app", gzipped(t, string(packed)), map[string]any{"compressed": "gzip", "size": int64(2)}})
+	assert.Equal(t, "msg5", next().Msg)
+	assert.Equal(t, "msg6", next().Msg)
+
+	// bad message, rejected and connection kept
+	write([]any{"app"})
+	write([]any{"app", int64(1), map[string]any{"message": "msg7"}})
+	assert.Equal(t, "msg7", next().Msg)
+
+	// hostile host, container and tag rejected, used as file logger's path
+	write([]any{"app", []any{
+		[]any{int64(1), map[string]any{"log": "bad1", "hostname": "../../etc"}},
+		[]any{int64(1), map[string]any{"log": "bad2", "container_name": "/../../../etc/cron.d/x"}},
+	}})
+	write([]any{"..", int64(1), map[string]any{"log": "bad3"}})
+	write([]any{"app", int64(1), map[string]any{"log": "msg8"}})
+	assert.Equal(t, "msg8", next().Msg)
+
+	st := stats.Snapshot()
+	assert.Equal(t, int64(14), st.Received)
+	assert.Equal(t, int64(9), st.Parsed)
+	assert.Equal(t, int64(5), st.Rejected)
+	assert.Equal(t, QueueStats{Len: 0, Cap: defQueueSize}, st.Queues["fluent"])
+
+	// malformed msgpack closes connection
+	_, err = conn.Write([]byte{0xc1})
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(ack)
+	assert.Error(t, err, "closed by server")
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok, "closed on ctx done")
+	case <-time.After(time.Second):
+		assert.Fail(t, "not closed")
+	}
+}
+
+func TestFluent_Senders(t *testing.T) {
+	allow, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	stats := &Stats{}
+	f := Fluent{Port: 24226, Stats: stats, Senders: &SenderFilter{Allow: allow, Unknown: SenderTag}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := f.Go(ctx)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:24226")
+	require.NoError(t, err)
+	defer conn.Close() // nolint
+	_, err = conn.Write(msgpackEncode(t, []any{"app", int64(1), map[string]any{"log": "msg1"}}))
+	require.NoError(t, err)
+	select {
+	case ent := <-ch:
+		assert.Equal(t, "true", ent.Fields[unknownSenderField])
+	case <-time.After(time.Second):
+		assert.Fail(t, "no entry")
+	}
+}
+
+func TestParseFluentMessage(t *testing.T) {
+	rec := map[string]any{"log": "msg1"}
+	ts := time.Unix(1558731270, 0)
+	tbl := []struct {
+		inp any
+		res fluentMessage
+		err string
+	}{
+		{[]any{"t1", int64(1558731270), rec}, fluentMessage{tag: "t1",
+			events: []fluentEvent{{ts: ts, record: map[string]string{"log": "msg1"}}}}, ""},
+		{[]any{[]byte("t1"), 1558731270.5, rec, map[string]any{"chunk": []byte("abc")}}, fluentMessage{tag: "t1", chunk: "abc",
+			events: []fluentEvent{{ts: ts.Add(500 * time.Millisecond), record: map[string]string{"log": "msg1"}}}}, ""},
+		{[]any{"t1", []any{[]any{int64(1558731270), rec}, []any{int64(1)}, "x"}, map[string]any{"chunk": "abc"}},
+			fluentMessage{tag: "t1", chunk: "abc", bad: 2,
+				events: []fluentEvent{{ts: ts, record: map[string]string{"log": "msg1"}}}}, ""},
+		{[]any{"t1", int64(1558731270), "not a map"}, fluentMessage{tag: "t1", bad: 1}, ""},
+		{[]any{"t1", []byte{0x92, 0x01}}, fluentMessage{}, "bad packed entries: unexpected EOF"},
+		{[]any{"t1", []byte{0x01}, map[string]any{"compressed": "gzip"}}, fluentMessage{}, "bad compressed entries: unexpected EOF"},
+		{[]any{"t1", int64(1)}, fluentMessage{}, "no record in message"},
+		{[]any{int64(1), int64(1), rec}, fluentMessage{}, "bad tag 1"},
+		{map[string]any{}, fluentMessage{}, "not an array of tag and events"},
+	}
+	for i, tt := range tbl {
+		res, err := parseFluentMessage(tt.inp, 100)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.res, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	packed := bytes.Repeat(msgpackEncode(t, []any{int64(1), rec}), 10)
+	_, err := parseFluentMessage([]any{"t1", gzipped(t, string(packed)), map[string]any{"compressed": "gzip"}}, 100)
+	assert.EqualError(t, err, "unpacked entries are more than 100")
+}
+
+func TestFluentValue(t *testing.T) {
+	tbl := []struct {
+		inp any
+		res string
+	}{
+		{nil, ""},
+		{"str", "str"},
+		{[]byte("bin"), "bin"},
+		{int64(-12), "-12"},
+		{uint64(12), "12"},
+		{1.25, "1.25"},
+		{true, "true"},
+		{[]any{"a", []byte("b"), int64(1)}, `["a","b",1]`},
+		{map[string]any{"k": map[string]any{"v": []byte("x")}}, `{"k":{"v":"x"}}`},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, fluentValue(tt.inp), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+// fluentEventTime makes EventTime extension value
+func fluentEventTime(ts time.Time) msgpackExt {
+	data := binary.BigEndian.AppendUint32(nil, uint32(ts.Unix()))
+	return msgpackExt{Type: fluentEventTimeExt, Data: binary.BigEndian.AppendUint32(data, uint32(ts.Nanosecond()))}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,10 +16,23 @@ import (
 type Forwarder struct {
 	Publisher  Publisher
 	Syslog     SyslogBackgroundReader
+	Inputs     []EntryBackgroundReader // optional sources making entries themselves, i.e. gelf
 	FileWriter FileWriter
+	Multiline  []core.MultilineRule // optional rules joining multiline events, the first matching container used
+	Rejects    RejectStore          // optional store for lines failed to parse
+	Spool      *Spool               // optional spool for batches failed to publish
+	QueueSize  int                  // size of entries queue, 10000 by default
+	Overflow   OverflowPolicy       // what to do with new entry if queue is full, block by default
+	Stats      *Stats               // optional, counts parsed, dropped and published entries
+	Hub        *Hub                 // optional, gets published entries for live streaming
+	Resolve    bool                 // replace missing or localhost host with reverse dns name of sender
+
+	joiners    map[string]*core.MultilineJoiner[core.LogEntry] // multiline joiners per host/container
+	resolver   *hostResolver
+	replayLock sync.Mutex // serializes replays, concurrent one would publish the same lines again
 }
 
-// Publisher to store
+// Publisher to store. Publish sets IDs of published records.
 type Publisher interface {
 	Publish(records []core.LogEntry) (err error)
 	LastPublished() (entry core.LogEntry, err error)
@@ -26,9 +40,23 @@ type Publisher interface {
 
 // SyslogBackgroundReader provides aysnc runner returning the channel for incoming messages
 type SyslogBackgroundReader interface {
-	Go(ctx context.Context) (<-chan string, error)
+	Go(ctx context.Context) (<-chan RawMessage, error)
+}
+
+// EntryBackgroundReader provides async runner returning the channel for entries, closed on ctx done
+type EntryBackgroundReader interface {
+	Go(ctx context.Context) (<-chan core.LogEntry, error)
 }
 
+// RejectStore keeps raw lines failed to parse, bounded
+type RejectStore interface {
+	Reject(rec core.Rejected) error
+	Rejected(limit int, pendingOnly bool) ([]core.Rejected, error) // latest rejected, pendingOnly skips replayed
+	MarkReplayed(ids []string) error
+}
+
+const maxReplay = 10000 // max number of rejected lines replayed at once
+
 // FileWriter writes entry to all log files
 type FileWriter interface {
 	Write(rec core.LogEntry) error
@@ -37,8 +65,20 @@ type FileWriter interface {
 // Run executes forwarder in endless (blocking) loop
 func (f *Forwarder) Run(ctx context.Context) error {
 	log.Print("[INFO] run forwarder from syslog")
-	messages := make(chan core.LogEntry, 10000)
-	writerWg := f.backgroundWriter(ctx, messages)
+	if f.QueueSize <= 0 {
+		f.QueueSize = defQueueSize
+	}
+	if f.Stats == nil {
+		f.Stats = &Stats{}
+	}
+	messages := make(chan core.LogEntry, f.QueueSize)
+	registerQueue(f.Stats, statsStageForwarder, messages)
+	writerWg := f.backgroundWriter(messages)
+	if f.Spool != nil {
+		f.Stats.setSpool(f.Spool)
+		writerWg.Go(func() { f.Spool.Run(ctx, f.storePublish) })
+	}
+	writerWg.Go(func() { f.logStats(ctx) })
 
 	if pe, err := f.Publisher.LastPublished(); err == nil {
 		log.Printf("[DEBUG] last published [%s : %s]", pe.ID, pe)
@@ -46,31 +86,271 @@ func (f *Forwarder) Run(ctx context.Context) error {
 
 	syslogCh, err := f.Syslog.Go(ctx)
 	if err != nil {
+		close(messages)
+		return errors.Wrap(err, "forwarder failed to run")
+	}
+	inputsCh, err := f.goInputs(ctx)
+	if err != nil {
+		close(messages)
 		return errors.Wrap(err, "forwarder failed to run")
 	}
 
+	f.joiners = map[string]*core.MultilineJoiner[core.LogEntry]{}
+	if f.Resolve && f.resolver == nil {
+		f.resolver = newHostResolver()
+	}
 	for {
 		select {
 		case <-ctx.Done():
+			for _, j := range f.joiners { // pending events sent before messages closed, nothing sends after
+				j.Flush()
+			}
+			close(messages)
 			writerWg.Wait() // wait for backgroundWriter completion
 			<-syslogCh      // wait for syslog close
 			return ctx.Err()
-		case line, ok := <-syslogCh:
+		case msg, ok := <-syslogCh:
 			if !ok {
 				continue
 			}
-			ent, err := core.NewEntry(line, time.Local)
+			ent, err := core.NewEntryWithRef(msg.Line, time.Local, msg.ReceivedTS)
 			if err != nil {
-				log.Printf("[WARN] failed to make entry from %q, %v", line, err)
+				log.Printf("[WARN] failed to make entry from %q, %v", msg.Line, err)
+				f.Stats.Rejected.Add(1)
+				f.reject(msg, err)
 				continue
 			}
-			messages <- ent
+			f.Stats.Parsed.Add(1)
+			f.setSender(&ent, msg)
+			f.push(ent, messages)
+		case ent := <-inputsCh:
+			f.resolveHost(&ent)
+			f.push(ent, messages)
+		}
+	}
+
+}
+
+// goInputs starts inputs and merges their entries to a single channel. Nil channel returned if no inputs.
+func (f *Forwarder) goInputs(ctx context.Context) (<-chan core.LogEntry, error) {
+	if len(f.Inputs) == 0 {
+		return nil, nil
+	}
+	res := make(chan core.LogEntry)
+	for _, in := range f.Inputs {
+		ch, err := in.Go(ctx)
+		if err != nil {
+			return nil, err
 		}
+		go func() {
+			for ent := range ch { // drained till closed by input, entries left on ctx done discarded
+				select {
+				case res <- ent:
+				case <-ctx.Done():
+				}
+			}
+		}()
 	}
+	return res, nil
+}
 
+// setSender sets sender's ip of entry, tags entry from unknown sender and resolves missing host if enabled
+func (f *Forwarder) setSender(ent *core.LogEntry, msg RawMessage) {
+	ip, ok := senderIP(msg.Sender)
+	if !ok {
+		return
+	}
+	ent.Sender = ip.String()
+	if msg.Unknown {
+		if ent.Fields == nil {
+			ent.Fields = map[string]string{}
+		}
+		ent.Fields[unknownSenderField] = "true"
+	}
+	f.resolveHost(ent)
+}
+
+// resolveHost replaces missing, localhost or sender's ip host with reverse dns name of sender, if enabled
+func (f *Forwarder) resolveHost(ent *core.LogEntry) {
+	if f.resolver != nil && ent.Sender != "" && (isLocalHost(ent.Host) || ent.Host == ent.Sender) {
+		ent.Host = f.resolver.resolve(ent.Sender)
+	}
+}
+
+// Replay re-parses pending rejected lines and publishes the ones parsed successfully.
+// Published lines marked as replayed, lines failed again kept as-is. Concurrent calls wait for the running one.
+func (f *Forwarder) Replay() (replayed, failed int, err error) {
+	if f.Rejects == nil {
+		return 0, 0, errors.New("no store for rejected lines")
+	}
+	f.replayLock.Lock()
+	defer f.replayLock.Unlock()
+	recs, err := f.Rejects.Rejected(maxReplay, true)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "can't get rejected lines")
+	}
+
+	entries := make([]core.LogEntry, 0, len(recs))
+	ids := make([]string, 0, len(recs))
+	for _, r := range recs {
+		ent, e := core.NewEntryWithRef(r.Line, time.Local, r.ReceivedTS)
+		if e != nil {
+			failed++
+			continue
+		}
+		f.setSender(&ent, RawMessage{Sender: r.Sender})
+		entries = append(entries, ent)
+		ids = append(ids, r.ID)
+	}
+	if len(entries) == 0 {
+		return 0, failed, nil
+	}
+
+	if err = f.publish(entries); err != nil {
+		return 0, failed, errors.Wrap(err, "can't publish replayed lines")
+	}
+	if err = f.Rejects.MarkReplayed(ids); err != nil {
+		return len(entries), failed, errors.Wrap(err, "can't mark replayed lines")
+	}
+	log.Printf("[INFO] replayed %d rejected lines, failed %d", len(entries), failed)
+	return len(entries), failed, nil
+}
+
+// Ingest publishes entries posted to ingest api, spooled on failure and written to file logger like syslog entries
+func (f *Forwarder) Ingest(entries []core.LogEntry) error {
+	if f.Stats != nil {
+		f.Stats.Ingested.Add(int64(len(entries)))
+	}
+	return f.publishOrSpool(entries)
+}
+
+// Rejected returns latest rejected lines
+func (f *Forwarder) Rejected(limit int) ([]core.Rejected, error) {
+	if f.Rejects == nil {
+		return nil, errors.New("no store for rejected lines")
+	}
+	return f.Rejects.Rejected(limit, false)
 }
 
-func (f *Forwarder) backgroundWriter(ctx context.Context, messages <-chan core.LogEntry) *sync.WaitGroup {
+// reject saves line failed to parse, if store defined
+func (f *Forwarder) reject(msg RawMessage, parseErr error) {
+	if f.Rejects == nil {
+		return
+	}
+	rec := core.Rejected{Line: msg.Line, Sender: msg.Sender, ReceivedTS: msg.ReceivedTS, Error: parseErr.Error()}
+	if err := f.Rejects.Reject(rec); err != nil {
+		log.Printf("[WARN] failed to save rejected line, %v", err)
+	}
+}
+
+// publish sends entries to publisher and file logger. Returns publisher's error only, file errors logged
+func (f *Forwarder) publish(entries []core.LogEntry) error {
+	err := f.storePublish(entries)
+	f.writeFiles(entries)
+	return err
+}
+
+// publishOrSpool sends entries to publisher, or to spool if publish failed. While spool has pending batches
+// entries go to spool as well, to keep the order. File logger always written.
+func (f *Forwarder) publishOrSpool(entries []core.LogEntry) error {
+	if f.Spool == nil {
+		return f.publish(entries)
+	}
+	defer f.writeFiles(entries)
+
+	if f.Spool.Empty() {
+		err := f.storePublish(entries)
+		if err == nil {
+			return nil
+		}
+		log.Printf("[WARN] failed to publish %d entries, spooled, %v", len(entries), err)
+	}
+	return f.Spool.Add(entries)
+}
+
+// storePublish sends entries to publisher and counts published and failed. Published entries passed to hub.
+func (f *Forwarder) storePublish(entries []core.LogEntry) error {
+	if err := f.Publisher.Publish(entries); err != nil {
+		if f.Stats != nil {
+			f.Stats.PublishFailed.Add(int64(len(entries)))
+		}
+		return err
+	}
+	if f.Stats != nil {
+		f.Stats.Published.Add(int64(len(entries)))
+	}
+	if f.Hub != nil {
+		f.Hub.Publish(entries)
+	}
+	return nil
+}
+
+func (f *Forwarder) writeFiles(entries []core.LogEntry) {
+	for _, r := range entries {
+		if e := f.FileWriter.Write(r); e != nil {
+			log.Printf("[WARN] failed to write to logs, %v", e)
+		}
+	}
+}
+
+// push sends entry to messages, entries of containers with multiline rule passed via joiner
+func (f *Forwarder) push(ent core.LogEntry, messages chan core.LogEntry) {
+	rule, ok := core.FindMultilineRule(f.Multiline, ent.Container)
+	if !ok {
+		f.send(ent, messages)
+		return
+	}
+
+	key := ent.Host + "/" + ent.Container
+	j, found := f.joiners[key]
+	if !found {
+		j = core.NewMultilineJoiner(rule, func(entries []core.LogEntry) {
+			res := entries[0]
+			if len(entries) > 1 {
+				lines := make([]string, len(entries))
+				for i, e := range entries {
+					lines[i] = e.Msg
+				}
+				res.Msg = strings.Join(lines, "\n")
+				res.Fields = core.ParseFields(res.Msg)
+			}
+			f.send(res, messages)
+		})
+		f.joiners[key] = j
+	}
+	j.Add(ent, ent.Msg)
+}
+
+// send entry to messages with overflow policy
+func (f *Forwarder) send(ent core.LogEntry, messages chan core.LogEntry) {
+	if dropped := send(messages, ent, f.Overflow); dropped > 0 {
+		f.Stats.Dropped.Add(int64(dropped))
+		f.Stats.warnDrop(statsStageForwarder, f.Overflow)
+	}
+}
+
+// logStats reports pipeline stats periodically, if anything received since the last report
+func (f *Forwarder) logStats(ctx context.Context) {
+	ticker := time.NewTicker(statsLogInterval)
+	defer ticker.Stop()
+	var lastReceived int64 = -1
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] pipeline stats: %s", f.Stats.Snapshot())
+			return
+		case <-ticker.C:
+			st := f.Stats.Snapshot()
+			if st.Received+st.Ingested+st.Parsed != lastReceived {
+				log.Printf("[INFO] pipeline stats: %s", st)
+			}
+			lastReceived = st.Received + st.Ingested + st.Parsed
+		}
+	}
+}
+
+// backgroundWriter publishes entries from messages in batches till messages closed
+func (f *Forwarder) backgroundWriter(messages <-chan core.LogEntry) *sync.WaitGroup {
 	log.Print("[INFO] forwarder's writer activated")
 	wg := sync.WaitGroup{}
 
@@ -83,26 +363,23 @@ func (f *Forwarder) backgroundWriter(ctx context.Context, messages <-chan core.L
 				return
 			}
 
-			if err := f.Publisher.Publish(buffer); err != nil {
+			if err := f.publishOrSpool(buffer); err != nil {
 				log.Printf("[WARN] failed to publish, error=%s", err)
 			}
-			for _, r := range buffer {
-				if err := f.FileWriter.Write(r); err != nil {
-					log.Printf("[WARN] failed to write to logs, %v", err)
-				}
-			}
 			log.Printf("[DEBUG] wrote %d entries", len(buffer))
 			buffer = buffer[0:0]
 		}
 
 		ticks := time.NewTicker(time.Millisecond * 500)
+		defer ticks.Stop()
 		for {
 			select {
-			case <-ctx.Done():
-				writeBuff()
-				log.Print("[DEBUG] background writer terminated")
-				return
-			case msg := <-messages:
+			case msg, ok := <-messages:
+				if !ok { // all entries left in messages written
+					writeBuff()
+					log.Print("[DEBUG] background writer terminated")
+					return
+				}
 				buffer = append(buffer, msg)
 				if len(buffer) >= 1000 { // forced flush every 1000
 					writeBuff()
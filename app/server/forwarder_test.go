@@ -81,25 +81,365 @@ func TestForwarderMalformedLine(t *testing.T) {
 	assert.Equal(t, 1, len(fw.get()), "valid record sent to file log")
 }
 
+func TestForwarderHub(t *testing.T) {
+	hub := NewHub(10)
+	sub, err := hub.Subscribe(core.Request{Containers: []string{"c1"}})
+	require.NoError(t, err)
+	mp := mockPublisher{}
+	f := Forwarder{Publisher: &mp, FileWriter: &mockFileWriter{}, Hub: hub, Syslog: &mockSyslogLinesReader{lines: []string{
+		"May 30 18:03:28 h1 docker/c1[1]: msg1",
+		"May 30 18:03:28 h1 docker/c2[1]: msg2",
+		"May 30 18:03:28 h2 docker/c1[1]: msg3",
+	}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*700, cancel)
+	_ = f.Run(ctx)
+
+	require.Equal(t, 3, len(mp.get()))
+	assert.Equal(t, []string{"msg1", "msg3"}, readMsgs(sub), "published entries pushed to subscriber")
+}
+
+func TestForwarderSender(t *testing.T) {
+	mp := mockPublisher{}
+	f := Forwarder{Publisher: &mp, FileWriter: &mockFileWriter{}, Resolve: true, Syslog: &mockSyslogLinesReader{lines: []string{
+		"May 30 18:03:28 h1 docker/c1[1]: msg1",
+		"May 30 18:03:28 localhost docker/c1[1]: msg2",
+	}}}
+	f.resolver = newHostResolver()
+	f.resolver.lookup = func(context.Context, string) ([]string, error) { return []string{"web-1.example.com."}, nil }
+	f.resolver.resolve("127.0.0.1") // warm up cache, lookup done in background
+	require.Eventually(t, func() bool { return f.resolver.resolve("127.0.0.1") != "127.0.0.1" }, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*700, cancel)
+	_ = f.Run(ctx)
+
+	recs := mp.get()
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, "127.0.0.1", recs[0].Sender)
+	assert.Equal(t, "h1", recs[0].Host)
+	assert.Equal(t, "127.0.0.1", recs[1].Sender)
+	assert.Equal(t, "web-1.example.com", recs[1].Host, "localhost replaced by resolved sender")
+
+	tbl := []struct {
+		msg    RawMessage
+		host   string
+		sender string
+		fields map[string]string
+	}{
+		{RawMessage{Sender: "10.0.0.1:5514"}, "", "10.0.0.1", nil},
+		{RawMessage{Sender: "[::ffff:10.0.0.1]:5514", Unknown: true}, "", "10.0.0.1", map[string]string{"unknown_sender": "true"}},
+		{RawMessage{Sender: "[fd00::1]:5514"}, "h1", "fd00::1", nil},
+		{RawMessage{}, "", "", nil},
+	}
+	f = Forwarder{}
+	for i, tt := range tbl {
+		ent := core.LogEntry{Host: tt.host}
+		f.setSender(&ent, tt.msg)
+		assert.Equal(t, tt.sender, ent.Sender, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.host, ent.Host, fmt.Sprintf("mismatch in #%d, not resolved", i))
+		assert.Equal(t, tt.fields, ent.Fields, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestForwarderMultiline(t *testing.T) {
+	log.Setup(log.Debug)
+
+	rule, err := core.ParseMultilineRule(`container=java;cont=^\s+at ;wait=100ms`)
+	require.NoError(t, err)
+
+	mp := mockPublisher{}
+	fw := mockFileWriter{}
+	f := Forwarder{
+		Publisher: &mp,
+		Syslog: &mockSyslogLinesReader{lines: []string{
+			"May 30 18:03:28 h1 docker/java[1]: Exception in thread main",
+			"May 30 18:03:28 h1 docker/other[2]: other msg 1",
+			"May 30 18:03:28 h1 docker/java[1]: \tat com.example.Main(Main.java:5)",
+			"May 30 18:03:28 h2 docker/java[3]: h2 msg",
+			"May 30 18:03:28 h1 docker/java[1]: \tat com.example.App(App.java:10)",
+			"May 30 18:03:28 h1 docker/other[2]:   at not joined",
+			"May 30 18:03:28 h1 docker/java[1]: next msg",
+		}},
+		FileWriter: &fw,
+		Multiline:  []core.MultilineRule{rule},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*700, func() { // tick every 500ms
+		cancel()
+	})
+
+	_ = f.Run(ctx)
+
+	recs := mp.get()
+	require.Equal(t, 5, len(recs))
+	msgs := map[string]bool{}
+	for _, r := range recs {
+		msgs[r.Host+":"+r.Msg] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"h1:Exception in thread main\n\tat com.example.Main(Main.java:5)\n\tat com.example.App(App.java:10)": true,
+		"h1:other msg 1": true, "h1:  at not joined": true, "h2:h2 msg": true, "h1:next msg": true,
+	}, msgs)
+}
+
+func TestForwarderMultilineFlushOnCancel(t *testing.T) {
+	rule, err := core.ParseMultilineRule(`container=java;cont=^\s+at ;wait=1h`)
+	require.NoError(t, err)
+
+	mp := mockPublisher{}
+	fw := mockFileWriter{}
+	f := Forwarder{
+		Publisher: &mp,
+		Syslog: &mockSyslogLinesReader{lines: []string{
+			"May 30 18:03:28 h1 docker/java[1]: Exception in thread main",
+			"May 30 18:03:28 h1 docker/java[1]: \tat com.example.Main(Main.java:5)",
+		}},
+		FileWriter: &fw,
+		Multiline:  []core.MultilineRule{rule},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel) // event still pending, wait is 1h
+
+	done := make(chan struct{})
+	go func() {
+		_ = f.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "forwarder not terminated")
+	}
+
+	recs := mp.get()
+	require.Equal(t, 1, len(recs), "pending event published on termination")
+	assert.Equal(t, "Exception in thread main\n\tat com.example.Main(Main.java:5)", recs[0].Msg)
+	assert.Equal(t, 1, len(fw.get()))
+}
+
+func TestForwarderRejectAndReplay(t *testing.T) {
+	log.Setup(log.Debug)
+
+	mp := mockPublisher{}
+	fw := mockFileWriter{}
+	rs := mockRejectStore{}
+	f := Forwarder{
+		Publisher: &mp,
+		Syslog: &mockSyslogLinesReader{lines: []string{
+			"Oct 19 15:29:43 ",
+			"May 30 18:03:28 BigMac.local docker/test123[63415]: some msg",
+		}},
+		FileWriter: &fw,
+		Rejects:    &rs,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*700, func() { // tick every 500ms
+		cancel()
+	})
+	_ = f.Run(ctx)
+
+	require.Equal(t, 1, len(mp.get()))
+	recs, err := f.Rejected(10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(recs))
+	assert.Equal(t, "Oct 19 15:29:43 ", recs[0].Line)
+	assert.Equal(t, "127.0.0.1:12345", recs[0].Sender)
+	assert.NotEmpty(t, recs[0].Error)
+	assert.WithinDuration(t, time.Now(), recs[0].ReceivedTS, time.Second)
+
+	replayed, failed, err := f.Replay()
+	require.NoError(t, err)
+	assert.Equal(t, 0, replayed)
+	assert.Equal(t, 1, failed, "still can't be parsed")
+
+	// fixed line, i.e. after parser update
+	rs.recs = append(rs.recs, core.Rejected{ID: "2", Line: "May 30 18:03:29 h1 docker/c1[1]: replayed msg",
+		ReceivedTS: time.Date(2019, 6, 1, 0, 0, 0, 0, time.Local)})
+	replayed, failed, err = f.Replay()
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, 1, failed)
+	require.Equal(t, 2, len(mp.get()))
+	assert.Equal(t, "replayed msg", mp.get()[1].Msg)
+	assert.Equal(t, 2019, mp.get()[1].TS.Year(), "year inferred from received time")
+	assert.Equal(t, 2, len(fw.get()))
+
+	replayed, _, err = f.Replay()
+	require.NoError(t, err)
+	assert.Equal(t, 0, replayed, "already replayed")
+
+	f.Rejects = nil
+	_, _, err = f.Replay()
+	assert.EqualError(t, err, "no store for rejected lines")
+}
+
+func TestForwarderReplayConcurrent(t *testing.T) {
+	mp := mockBlockingPublisher{release: make(chan struct{})}
+	rs := NewMemoryRejects(10)
+	require.NoError(t, rs.Reject(core.Rejected{Line: "May 30 18:03:29 h1 docker/c1[1]: replayed msg"}))
+	f := Forwarder{Publisher: &mp, FileWriter: &mockFileWriter{}, Rejects: rs}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	total := 0
+	for range 3 {
+		wg.Go(func() {
+			replayed, _, err := f.Replay()
+			assert.NoError(t, err)
+			lock.Lock()
+			total += replayed
+			lock.Unlock()
+		})
+	}
+	time.Sleep(50 * time.Millisecond) // let all replays start
+	close(mp.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, total, "replayed once")
+	assert.Equal(t, 1, len(mp.get()), "published once")
+}
+
+func TestForwarderSpool(t *testing.T) {
+	log.Setup(log.Debug)
+
+	spool, err := NewSpool(SpoolParams{Path: t.TempDir(), MinRetry: 50 * time.Millisecond})
+	require.NoError(t, err)
+	lines := []string{}
+	for i := range 10 {
+		lines = append(lines, fmt.Sprintf("May 30 18:03:28 h1 docker/c1[1]: msg %d", i))
+	}
+	mp := mockFlakyPublisher{failures: 3}
+	fw := mockFileWriter{}
+	f := Forwarder{Publisher: &mp, Syslog: &mockSyslogLinesReader{lines: lines}, FileWriter: &fw, Spool: spool}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*1200, cancel)
+	_ = f.Run(ctx)
+
+	recs := mp.get()
+	require.Equal(t, 10, len(recs), "all published after failures")
+	for i, r := range recs {
+		assert.Equal(t, fmt.Sprintf("msg %d", i), r.Msg)
+	}
+	assert.Equal(t, 10, len(fw.get()), "file log written once")
+	assert.True(t, spool.Empty())
+}
+
+func TestForwarderOverflow(t *testing.T) {
+	log.Setup(log.Debug)
+
+	lines := make([]string, 1100)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("May 30 18:03:28 h1 docker/c1[1]: msg %d", i)
+	}
+	mp := mockBlockingPublisher{release: make(chan struct{})}
+	stats := &Stats{}
+	f := Forwarder{Publisher: &mp, Syslog: &mockSyslogLinesReader{lines: lines}, FileWriter: &mockFileWriter{},
+		QueueSize: 2, Overflow: OverflowDropNewest, Stats: stats}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, func() { close(mp.release) }) // writer blocked, queue overflows
+	time.AfterFunc(time.Millisecond*700, cancel)
+	_ = f.Run(ctx)
+
+	st := stats.Snapshot()
+	assert.Equal(t, int64(1100), st.Parsed)
+	assert.True(t, st.Dropped > 0, "dropped on full queue")
+	assert.Equal(t, int64(1100), st.Dropped+st.Published, "each entry either published or dropped")
+	assert.Equal(t, int(st.Published), len(mp.get()))
+	assert.Equal(t, QueueStats{Len: 0, Cap: 2}, st.Queues["forwarder"])
+}
+
+func TestForwarderInputs(t *testing.T) {
+	mp := mockPublisher{}
+	f := Forwarder{Publisher: &mp, FileWriter: &mockFileWriter{}, Resolve: true, Syslog: &mockSyslogLinesReader{lines: []string{
+		"May 30 18:03:28 h1 docker/c1[1]: syslog msg"}},
+		Inputs: []EntryBackgroundReader{
+			&mockEntriesReader{entries: []core.LogEntry{{Host: "h2", Container: "c2", Msg: "input1 msg1"}}},
+			&mockEntriesReader{entries: []core.LogEntry{{Host: "localhost", Sender: "10.0.0.1", Container: "c3", Msg: "input2 msg1"},
+				{Host: "10.0.0.1", Sender: "10.0.0.1", Container: "c3", Msg: "input2 msg2"}}},
+		}}
+	f.resolver = newHostResolver()
+	f.resolver.lookup = func(context.Context, string) ([]string, error) { return []string{"web-1."}, nil }
+	f.resolver.resolve("10.0.0.1")
+	require.Eventually(t, func() bool { return f.resolver.resolve("10.0.0.1") != "10.0.0.1" }, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(700*time.Millisecond, cancel)
+	_ = f.Run(ctx)
+
+	hosts := map[string]string{}
+	for _, r := range mp.get() {
+		hosts[r.Msg] = r.Host
+	}
+	assert.Equal(t, map[string]string{"syslog msg": "h1", "input1 msg1": "h2", "input2 msg1": "web-1", "input2 msg2": "web-1"}, hosts)
+
+	f = Forwarder{Publisher: &mp, FileWriter: &mockFileWriter{}, Syslog: &mockSyslogLinesReader{},
+		Inputs: []EntryBackgroundReader{&mockEntriesReader{err: errors.New("listen failed")}}}
+	assert.EqualError(t, f.Run(context.Background()), "forwarder failed to run: listen failed")
+}
+
+func TestForwarderIngest(t *testing.T) {
+	mp, fw, hub, stats := mockPublisher{}, mockFileWriter{}, NewHub(0), &Stats{}
+	f := Forwarder{Publisher: &mp, FileWriter: &fw, Hub: hub, Stats: stats}
+	sub, err := hub.Subscribe(core.Request{})
+	require.NoError(t, err)
+	defer sub.Close()
+
+	recs := []core.LogEntry{{Host: "h1", Container: "c1", Msg: "msg1"}, {Host: "h1", Container: "c2", Msg: "msg2"}}
+	require.NoError(t, f.Ingest(recs))
+	assert.Equal(t, recs, mp.get())
+	assert.Equal(t, recs, fw.get())
+	assert.Equal(t, "msg1", (<-sub.Entries()).Msg)
+	assert.Equal(t, int64(2), stats.Ingested.Load())
+	assert.Equal(t, int64(2), stats.Published.Load())
+
+	err = f.Ingest([]core.LogEntry{{Host: "h1", Container: "err", Msg: "msg3"}})
+	assert.EqualError(t, err, "publisher error")
+	assert.Equal(t, int64(1), stats.PublishFailed.Load())
+}
+
 type mockSyslogLinesReader struct{ lines []string }
 
-func (m *mockSyslogLinesReader) Go(context.Context) (<-chan string, error) {
-	ch := make(chan string, len(m.lines))
+func (m *mockSyslogLinesReader) Go(context.Context) (<-chan RawMessage, error) {
+	ch := make(chan RawMessage, len(m.lines))
 	for _, line := range m.lines {
-		ch <- line
+		ch <- RawMessage{Line: line, Sender: "127.0.0.1:12345", ReceivedTS: time.Now()}
 	}
 	close(ch)
 	return ch, nil
 }
 
+type mockEntriesReader struct {
+	entries []core.LogEntry
+	err     error
+}
+
+func (m *mockEntriesReader) Go(ctx context.Context) (<-chan core.LogEntry, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	ch := make(chan core.LogEntry, len(m.entries))
+	for _, e := range m.entries {
+		ch <- e
+	}
+	context.AfterFunc(ctx, func() { close(ch) })
+	return ch, nil
+}
+
 type mockSyslogBackgroundReader struct{}
 
-func (m *mockSyslogBackgroundReader) Go(context.Context) (<-chan string, error) {
-	ch := make(chan string, 101)
+func (m *mockSyslogBackgroundReader) Go(context.Context) (<-chan RawMessage, error) {
+	ch := make(chan RawMessage, 101)
 	for i := range 100 {
-		ch <- fmt.Sprintf("May 30 18:03:28 BigMac.local docker/test123[63415]: some msg %d", i)
+		ch <- RawMessage{Line: fmt.Sprintf("May 30 18:03:28 BigMac.local docker/test123[63415]: some msg %d", i), ReceivedTS: time.Now()}
 	}
-	ch <- "May 30 18:03:28 BigMac.local docker/err[63415]: some bad msg"
+	ch <- RawMessage{Line: "May 30 18:03:28 BigMac.local docker/err[63415]: some bad msg", ReceivedTS: time.Now()}
 	close(ch)
 	return ch, nil
 }
@@ -156,3 +496,73 @@ func (m *mockPublisher) get() []core.LogEntry {
 func (m *mockPublisher) LastPublished() (entry core.LogEntry, err error) {
 	return core.LogEntry{}, nil
 }
+
+type mockRejectStore struct {
+	recs []core.Rejected
+	sync.Mutex
+}
+
+func (m *mockRejectStore) Reject(rec core.Rejected) error {
+	m.Lock()
+	defer m.Unlock()
+	rec.ID = fmt.Sprintf("%d", len(m.recs)+1)
+	m.recs = append(m.recs, rec)
+	return nil
+}
+
+func (m *mockRejectStore) Rejected(limit int, pendingOnly bool) ([]core.Rejected, error) {
+	m.Lock()
+	defer m.Unlock()
+	res := []core.Rejected{}
+	for _, r := range m.recs {
+		if pendingOnly && r.Replayed {
+			continue
+		}
+		res = append(res, r)
+	}
+	if len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+	return res, nil
+}
+
+func (m *mockRejectStore) MarkReplayed(ids []string) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, id := range ids {
+		for i := range m.recs {
+			if m.recs[i].ID == id {
+				m.recs[i].Replayed = true
+			}
+		}
+	}
+	return nil
+}
+
+// mockFlakyPublisher fails the first failures calls
+type mockFlakyPublisher struct {
+	mockPublisher
+	failures int
+}
+
+func (m *mockFlakyPublisher) Publish(records []core.LogEntry) (err error) {
+	m.Lock()
+	if m.failures > 0 {
+		m.failures--
+		m.Unlock()
+		return errors.New("store down")
+	}
+	m.Unlock()
+	return m.mockPublisher.Publish(records)
+}
+
+// mockBlockingPublisher blocks until release closed
+type mockBlockingPublisher struct {
+	mockPublisher
+	release chan struct{}
+}
+
+func (m *mockBlockingPublisher) Publish(records []core.LogEntry) (err error) {
+	<-m.release
+	return m.mockPublisher.Publish(records)
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// Gelf server receives GELF messages, i.e. from docker's gelf logging driver, on UDP and TCP port.
+// UDP message can be chunked and compressed with gzip or zlib, TCP messages are uncompressed and null byte delimited.
+type Gelf struct {
+	Port           int
+	MaxMessageSize int            // longer messages rejected, compressed and decompressed, 1M by default
+	QueueSize      int            // size of entries queue, 10000 by default
+	Overflow       OverflowPolicy // what to do with new entry if queue is full, block by default
+	Stats          *Stats         // optional, counts received, parsed, rejected and dropped messages
+	Senders        *SenderFilter  // optional, rejects or tags messages from denied and unknown senders
+}
+
+const (
+	defGelfMaxMessageSize = 1024 * 1024
+	gelfChunkHeaderSize   = 12 // magic 0x1e 0x0f, 8 bytes message id, sequence number and count
+	gelfMaxChunks         = 128
+	gelfChunkTimeout      = 5 * time.Second // incomplete chunked message discarded after
+	gelfMaxPending        = 1000            // max number of incomplete chunked messages
+)
+
+// Go starts gelf server in background and returns channel with entries
+func (g *Gelf) Go(ctx context.Context) (<-chan core.LogEntry, error) {
+	log.Printf("[INFO] activate gelf server on %d", g.Port)
+	if g.QueueSize <= 0 {
+		g.QueueSize = defQueueSize
+	}
+	if g.Stats == nil {
+		g.Stats = &Stats{}
+	}
+	if g.MaxMessageSize <= 0 {
+		g.MaxMessageSize = defGelfMaxMessageSize
+	}
+	if g.Overflow == "" {
+		g.Overflow = OverflowBlock
+	}
+
+	addr := fmt.Sprintf("0.0.0.0:%d", g.Port)
+	udpConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "gelf can't listen to udp on %d", g.Port)
+	}
+	tcpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, errors.Wrapf(err, "gelf can't listen to tcp on %d", g.Port)
+	}
+
+	outCh := make(chan core.LogEntry, g.QueueSize)
+	registerQueue(g.Stats, statsStageGelf, outCh)
+	var wg sync.WaitGroup
+	wg.Go(func() { g.serveUDP(ctx, udpConn, outCh) })
+	wg.Go(func() {
+		acceptConns(ctx, tcpListener, g.Senders, g.Stats, func(conn net.Conn, unknown bool) {
+			g.readTCP(ctx, conn, unknown, outCh)
+		})
+	})
+
+	go func() {
+		<-ctx.Done()
+		log.Print("[DEBUG] gelf termination requested")
+		if err := udpConn.Close(); err != nil {
+			log.Printf("[WARN] failed to close gelf udp listener, %v", err)
+		}
+		if err := tcpListener.Close(); err != nil {
+			log.Printf("[WARN] failed to close gelf tcp listener, %v", err)
+		}
+		wg.Wait()
+		close(outCh)
+		log.Print("[INFO] gelf server terminated")
+	}()
+	return outCh, nil
+}
+
+// serveUDP reads datagrams till connection closed, chunks reassembled to messages
+func (g *Gelf) serveUDP(ctx context.Context, conn net.PacketConn, outCh chan core.LogEntry) {
+	chunks := &gelfChunks{maxSize: g.MaxMessageSize, pending: map[[8]byte]*gelfChunked{}}
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[WARN] gelf udp read failed, %v", err)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		sender := addr.String()
+		accepted, unknown := g.Senders.accept(sender, g.Stats)
+		if !accepted {
+			continue
+		}
+		data := buf[:n]
+		if isGelfChunk(data) {
+			msg, complete, e := chunks.add(data, time.Now())
+			if e != nil {
+				log.Printf("[WARN] bad gelf chunk from %s, %v", sender, e)
+				g.Stats.Rejected.Add(1)
+				continue
+			}
+			if !complete {
+				continue
+			}
+			data = msg
+		}
+		g.handle(ctx, data, sender, unknown, outCh)
+	}
+}
+
+// readTCP reads null byte delimited messages till connection closed or ctx done
+func (g *Gelf) readTCP(ctx context.Context, conn net.Conn, unknown bool, outCh chan core.LogEntry) {
+	defer conn.Close() // nolint
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	sender := conn.RemoteAddr().String()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), g.MaxMessageSize+1) // with delimiter
+	scanner.Split(splitGelfStream)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		g.handle(ctx, scanner.Bytes(), sender, unknown, outCh)
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		log.Printf("[WARN] gelf connection from %s closed, %v", sender, err)
+	}
+}
+
+// handle decompresses and parses message, sends entry to outCh
+func (g *Gelf) handle(ctx context.Context, data []byte, sender string, unknown bool, outCh chan core.LogEntry) {
+	g.Stats.Received.Add(1)
+	payload, err := gelfPayload(data, g.MaxMessageSize)
+	if err != nil {
+		log.Printf("[WARN] bad gelf message from %s, %v", sender, err)
+		g.Stats.Rejected.Add(1)
+		return
+	}
+	ent, err := core.NewEntryGELF(payload, time.Now())
+	if err == nil {
+		err = checkEntryNames(ent) // host and container used as file logger's path
+	}
+	if err != nil {
+		log.Printf("[WARN] failed to make entry from gelf message %.256q, %v", payload, err)
+		g.Stats.Rejected.Add(1)
+		return
+	}
+	g.Stats.Parsed.Add(1)
+
+	if ip, ok := senderIP(sender); ok {
+		ent.Sender = ip.String()
+	}
+	if unknown {
+		if ent.Fields == nil {
+			ent.Fields = map[string]string{}
+		}
+		ent.Fields[unknownSenderField] = "true"
+	}
+
+	// blocked sender released on ctx done, out channel closed after all senders stopped
+	sendCtx(ctx, outCh, ent, g.Overflow, g.Stats, statsStageGelf)
+}
+
+// gelfPayload decompresses gzip or zlib message, uncompressed one returned as-is. Message larger than maxSize rejected.
+func gelfPayload(data []byte, maxSize int) ([]byte, error) {
+	var rd io.ReadCloser
+	var err error
+	switch {
+	case len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b:
+		rd, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) > 2 && data[0] == 0x78 && (int(data[0])<<8|int(data[1]))%31 == 0: // zlib header check, RFC 1950
+		rd, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		if len(data) > maxSize {
+			return nil, errors.Errorf("message size %d is more than %d", len(data), maxSize)
+		}
+		return data, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "bad compressed message")
+	}
+	defer rd.Close() // nolint
+
+	res, err := io.ReadAll(io.LimitReader(rd, int64(maxSize)+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't decompress message")
+	}
+	if len(res) > maxSize {
+		return nil, errors.Errorf("decompressed message is more than %d", maxSize)
+	}
+	return res, nil
+}
+
+// splitGelfStream splits tcp stream by null byte, newline accepted as well
+func splitGelfStream(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\x00\n"); i >= 0 {
+		return i + 1, bytes.TrimSuffix(data[:i], []byte("\r")), nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func isGelfChunk(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1e && data[1] == 0x0f
+}
+
+// gelfChunks reassembles chunked udp messages. Incomplete message discarded after gelfChunkTimeout. Not thread safe.
+type gelfChunks struct {
+	maxSize     int
+	pending     map[[8]byte]*gelfChunked // by message id
+	lastCleanup time.Time
+}
+
+type gelfChunked struct {
+	parts    [][]byte
+	received int
+	size     int
+	ts       time.Time // the first chunk received
+}
+
+// add chunk, returns the whole message after all its chunks received
+func (c *gelfChunks) add(data []byte, now time.Time) (msg []byte, complete bool, err error) {
+	if len(data) <= gelfChunkHeaderSize {
+		return nil, false, errors.Errorf("chunk size %d is too short", len(data))
+	}
+	var id [8]byte
+	copy(id[:], data[2:10])
+	seq, count := int(data[10]), int(data[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, false, errors.Errorf("bad chunk %d of %d", seq, count)
+	}
+
+	c.cleanup(now)
+	m, found := c.pending[id]
+	if !found {
+		if len(c.pending) >= gelfMaxPending {
+			return nil, false, errors.Errorf("too many incomplete messages, %d", len(c.pending))
+		}
+		m = &gelfChunked{parts: make([][]byte, count), ts: now}
+		c.pending[id] = m
+	}
+	if len(m.parts) != count {
+		delete(c.pending, id)
+		return nil, false, errors.Errorf("chunk count %d, expected %d", count, len(m.parts))
+	}
+	if m.parts[seq] != nil {
+		return nil, false, nil // duplicate
+	}
+	m.size += len(data) - gelfChunkHeaderSize
+	if m.size > c.maxSize {
+		delete(c.pending, id)
+		return nil, false, errors.Errorf("chunked message is more than %d", c.maxSize)
+	}
+	m.parts[seq] = bytes.Clone(data[gelfChunkHeaderSize:])
+	if m.received++; m.received < count {
+		return nil, false, nil
+	}
+	delete(c.pending, id)
+	return bytes.Join(m.parts, nil), true, nil
+}
+
+// cleanup removes expired incomplete messages, not more often than once a second
+func (c *gelfChunks) cleanup(now time.Time) {
+	if now.Sub(c.lastCleanup) < time.Second {
+		return
+	}
+	c.lastCleanup = now
+	for id, m := range c.pending {
+		if now.Sub(m.ts) > gelfChunkTimeout {
+			log.Printf("[DEBUG] incomplete gelf message discarded, %d of %d chunks received", m.received, len(m.parts))
+			delete(c.pending, id)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestGelf(t *testing.T) {
+	stats := &Stats{}
+	g := Gelf{Port: 12211, Stats: stats}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := g.Go(ctx)
+	require.NoError(t, err)
+
+	udp, err := net.Dial("udp", "127.0.0.1:12211")
+	require.NoError(t, err)
+	defer udp.Close() // nolint
+
+	// plain, gzipped and zlib compressed datagrams
+	_, err = udp.Write([]byte(`{"version":"1.1","host":"h1","short_message":"msg1","level":3,"_container_name":"c1","_image_name":"img"}`))
+	require.NoError(t, err)
+	next := func() core.LogEntry {
+		select {
+		case ent := <-ch:
+			return ent
+		case <-time.After(time.Second):
+			require.Fail(t, "no entry")
+		}
+		return core.LogEntry{}
+	}
+	ent := next()
+	assert.Equal(t, "h1", ent.Host)
+	assert.Equal(t, "c1", ent.Container)
+	assert.Equal(t, "msg1", ent.Msg)
+	assert.Equal(t, core.SevErr, ent.Severity)
+	assert.Equal(t, "127.0.0.1", ent.Sender)
+	assert.Equal(t, map[string]string{"image_name": "img"}, ent.Fields)
+
+	_, err = udp.Write(gzipped(t, `{"host":"h1","short_message":"msg2","_container_name":"c1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "msg2", next().Msg)
+	_, err = udp.Write(zlibbed(t, `{"host":"h1","short_message":"msg3","_container_name":"c1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "msg3", next().Msg)
+
+	// chunked and compressed, chunks out of order
+	long := strings.Repeat("x", 3000)
+	payload := gzipped(t, `{"host":"h2","short_message":"`+long+`","_container_name":"c2"}`)
+	chunks := gelfChunkMsg([8]byte{1, 2, 3, 4, 5, 6, 7, 8}, payload, 3)
+	for _, i := range []int{2, 0, 1} {
+		_, err = udp.Write(chunks[i])
+		require.NoError(t, err)
+	}
+	ent = next()
+	assert.Equal(t, "h2", ent.Host)
+	assert.Equal(t, long, ent.Msg)
+
+	// bad message, rejected
+	_, err = udp.Write([]byte(`{"host":"h1"}`))
+	require.NoError(t, err)
+
+	// null byte delimited tcp stream
+	tcp, err := net.Dial("tcp", "127.0.0.1:12211")
+	require.NoError(t, err)
+	defer tcp.Close() // nolint
+	// hostile host and container rejected, used as file logger's path
+	_, err = fmt.Fprintf(tcp, "%s\x00%s\x00", `{"host":"../../etc","short_message":"bad1","_container_name":"c3"}`,
+		`{"host":"h3","short_message":"bad2","_container_name":"/../../../etc/cron.d/x"}`)
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(tcp, "%s\x00%s\x00", `{"host":"h3","short_message":"msg4","_container_name":"c3"}`,
+		`{"host":"h3","short_message":"msg5","_container_name":"c3","timestamp":1558731270.5}`)
+	require.NoError(t, err)
+	assert.Equal(t, "msg4", next().Msg)
+	ent = next()
+	assert.Equal(t, "msg5", ent.Msg)
+	assert.Equal(t, time.Unix(1558731270, 500000000), ent.TS)
+
+	assert.Eventually(t, func() bool { return stats.Rejected.Load() == 3 }, time.Second, 10*time.Millisecond)
+	st := stats.Snapshot()
+	assert.Equal(t, int64(9), st.Received)
+	assert.Equal(t, int64(6), st.Parsed)
+	assert.Equal(t, int64(3), st.Rejected)
+	assert.Equal(t, QueueStats{Len: 0, Cap: defQueueSize}, st.Queues["gelf"])
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok, "closed on ctx done")
+	case <-time.After(time.Second):
+		assert.Fail(t, "not closed")
+	}
+}
+
+func TestGelf_Senders(t *testing.T) {
+	deny, err := ParseCIDRs([]string{"127.0.0.1"})
+	require.NoError(t, err)
+	stats := &Stats{}
+	g := Gelf{Port: 12212, Stats: stats, Senders: &SenderFilter{Deny: deny}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := g.Go(ctx)
+	require.NoError(t, err)
+
+	udp, err := net.Dial("udp", "127.0.0.1:12212")
+	require.NoError(t, err)
+	defer udp.Close() // nolint
+	_, err = udp.Write([]byte(`{"host":"h1","short_message":"msg1"}`))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return stats.SenderRejected.Load() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, len(ch))
+}
+
+func TestGelfPayload(t *testing.T) {
+	msg := `{"host":"h1","short_message":"msg1"}`
+	tbl := []struct {
+		inp     []byte
+		maxSize int
+		res     string
+		err     string
+	}{
+		{[]byte(msg), 100, msg, ""},
+		{gzipped(t, msg), 100, msg, ""},
+		{zlibbed(t, msg), 100, msg, ""},
+		{[]byte(msg), 10, "", "message size 36 is more than 10"},
+		{gzipped(t, msg), 10, "", "decompressed message is more than 10"},
+		{[]byte{0x1f, 0x8b, 1, 2, 3}, 100, "", "bad compressed message: unexpected EOF"},
+	}
+	for i, tt := range tbl {
+		res, err := gelfPayload(tt.inp, tt.maxSize)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.res, string(res), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestGelfChunks(t *testing.T) {
+	c := &gelfChunks{maxSize: 100, pending: map[[8]byte]*gelfChunked{}}
+	now := time.Now()
+	id1, id2 := [8]byte{1}, [8]byte{2}
+
+	chunks := gelfChunkMsg(id1, []byte("0123456789"), 3)
+	_, complete, err := c.add(chunks[1], now)
+	require.NoError(t, err)
+	assert.False(t, complete)
+	_, complete, err = c.add(chunks[1], now)
+	require.NoError(t, err)
+	assert.False(t, complete, "duplicate ignored")
+	_, complete, err = c.add(chunks[0], now)
+	require.NoError(t, err)
+	assert.False(t, complete)
+	msg, complete, err := c.add(chunks[2], now)
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, "0123456789", string(msg))
+	assert.Equal(t, 0, len(c.pending))
+
+	// incomplete message expired
+	_, _, err = c.add(gelfChunkMsg(id2, []byte("0123456789"), 2)[0], now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(c.pending))
+	_, _, err = c.add(gelfChunkMsg(id1, []byte("0123456789"), 2)[0], now.Add(gelfChunkTimeout+time.Second))
+	require.NoError(t, err)
+	_, ok := c.pending[id2]
+	assert.False(t, ok, "expired")
+
+	_, _, err = c.add(gelfChunkMsg(id2, bytes.Repeat([]byte("x"), 101), 1)[0], now)
+	assert.EqualError(t, err, "chunked message is more than 100")
+	_, _, err = c.add([]byte{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 5, 3, 'x'}, now)
+	assert.EqualError(t, err, "bad chunk 5 of 3")
+	_, _, err = c.add([]byte{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 0, 200, 'x'}, now)
+	assert.EqualError(t, err, "bad chunk 0 of 200")
+	_, _, err = c.add([]byte{0x1e, 0x0f, 1, 2}, now)
+	assert.EqualError(t, err, "chunk size 4 is too short")
+	_, _, err = c.add(gelfChunkMsg(id1, []byte("0123456789"), 3)[0], now.Add(gelfChunkTimeout+time.Second))
+	assert.EqualError(t, err, "chunk count 3, expected 2")
+}
+
+func TestSplitGelfStream(t *testing.T) {
+	tbl := []struct {
+		data    string
+		atEOF   bool
+		advance int
+		token   string
+	}{
+		{"msg1\x00msg2", false, 5, "msg1"},
+		{"msg1\r\nmsg2", false, 6, "msg1"},
+		{"\x00msg2", false, 1, ""},
+		{"msg2", false, 0, ""},
+		{"msg2", true, 4, "msg2"},
+	}
+	for i, tt := range tbl {
+		advance, token, err := splitGelfStream([]byte(tt.data), tt.atEOF)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.advance, advance, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.token, string(token), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+// gelfChunkMsg splits message to n chunks
+func gelfChunkMsg(id [8]byte, msg []byte, n int) [][]byte {
+	res := make([][]byte, 0, n)
+	size := (len(msg) + n - 1) / n
+	for i := range n {
+		chunk := append([]byte{0x1e, 0x0f}, id[:]...)
+		chunk = append(chunk, byte(i), byte(n))
+		res = append(res, append(chunk, msg[i*size:min((i+1)*size, len(msg))]...))
+	}
+	return res
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zlibbed(t *testing.T, s string) []byte {
+	buf := bytes.Buffer{}
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
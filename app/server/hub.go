@@ -0,0 +1,121 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// Hub delivers published entries to live subscribers in memory, each subscriber gets entries matching its request.
+// Publish never blocks, subscriber failed to keep up is closed as lagged and has to catch up from the store.
+type Hub struct {
+	lock    sync.RWMutex
+	bufSize int
+	subs    map[*Subscription]struct{}
+}
+
+// Subscription receives matching entries from Hub until closed
+type Subscription struct {
+	hub     *Hub
+	matcher *core.Matcher
+	ch      chan core.LogEntry
+	lagged  bool // set under hub's lock
+}
+
+const defHubBufSize = 1000
+
+// NewHub makes Hub with bufSize entries buffered for each subscriber
+func NewHub(bufSize int) *Hub {
+	if bufSize <= 0 {
+		bufSize = defHubBufSize
+	}
+	return &Hub{bufSize: bufSize, subs: map[*Subscription]struct{}{}}
+}
+
+// Subscribe makes subscription for entries matching request. LastID and Limit ignored.
+func (h *Hub) Subscribe(req core.Request) (*Subscription, error) {
+	matcher, err := core.NewMatcher(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad request %+v", req)
+	}
+	sub := &Subscription{hub: h, matcher: matcher, ch: make(chan core.LogEntry, h.bufSize)}
+	h.lock.Lock()
+	h.subs[sub] = struct{}{}
+	h.lock.Unlock()
+	return sub, nil
+}
+
+// Publish sends entries to all matching subscribers. Entries expected to have IDs set by store.
+func (h *Hub) Publish(entries []core.LogEntry) {
+	var lagged []*Subscription
+	h.lock.RLock()
+	for sub := range h.subs {
+		if !sub.send(entries) {
+			lagged = append(lagged, sub)
+		}
+	}
+	h.lock.RUnlock()
+
+	if len(lagged) == 0 {
+		return
+	}
+	h.lock.Lock()
+	for _, sub := range lagged {
+		if _, ok := h.subs[sub]; ok {
+			sub.lagged = true
+			h.remove(sub)
+		}
+	}
+	h.lock.Unlock()
+}
+
+// Subscribers returns number of active subscriptions
+func (h *Hub) Subscribers() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.subs)
+}
+
+// remove subscription and close its channel, has to be called under lock
+func (h *Hub) remove(sub *Subscription) {
+	delete(h.subs, sub)
+	close(sub.ch)
+}
+
+// Entries returns channel of matching entries, closed on Close or if subscriber lagged
+func (s *Subscription) Entries() <-chan core.LogEntry {
+	return s.ch
+}
+
+// Lagged checks if subscription closed because subscriber failed to keep up and missed entries
+func (s *Subscription) Lagged() bool {
+	s.hub.lock.RLock()
+	defer s.hub.lock.RUnlock()
+	return s.lagged
+}
+
+// Close unsubscribes, safe to call more than once
+func (s *Subscription) Close() {
+	s.hub.lock.Lock()
+	defer s.hub.lock.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		s.hub.remove(s)
+	}
+}
+
+// send matching entries without blocking, returns false if buffer is full
+func (s *Subscription) send(entries []core.LogEntry) bool {
+	for _, e := range entries {
+		if !s.matcher.Match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestHub_PublishSubscribe(t *testing.T) {
+	h := NewHub(10)
+	all, err := h.Subscribe(core.Request{})
+	require.NoError(t, err)
+	h2, err := h.Subscribe(core.Request{Hosts: []string{"h2"}})
+	require.NoError(t, err)
+	_, err = h.Subscribe(core.Request{Hosts: []string{"/[bad/"}})
+	assert.Error(t, err)
+	assert.Equal(t, 2, h.Subscribers())
+
+	h.Publish(testRecords())
+	assert.Equal(t, []string{"msg1", "msg2", "msg3", "msg4", "msg5", "msg6"}, readMsgs(all))
+	assert.Equal(t, []string{"msg3", "msg6"}, readMsgs(h2))
+
+	h2.Close()
+	h2.Close()
+	assert.Equal(t, 1, h.Subscribers())
+	_, ok := <-h2.Entries()
+	assert.False(t, ok, "closed")
+	assert.False(t, h2.Lagged())
+
+	h.Publish(testRecords()[:1])
+	assert.Equal(t, []string{"msg1"}, readMsgs(all))
+}
+
+func TestHub_Lagged(t *testing.T) {
+	h := NewHub(4)
+	slow, err := h.Subscribe(core.Request{})
+	require.NoError(t, err)
+	fast, err := h.Subscribe(core.Request{Hosts: []string{"h2"}})
+	require.NoError(t, err)
+
+	h.Publish(testRecords())
+	assert.True(t, slow.Lagged())
+	assert.False(t, fast.Lagged(), "only 2 matching entries buffered")
+	assert.Equal(t, 1, h.Subscribers())
+
+	msgs := []string{}
+	for e := range slow.Entries() { // buffered entries delivered before close
+		msgs = append(msgs, e.Msg)
+	}
+	assert.Equal(t, []string{"msg1", "msg2", "msg3", "msg4"}, msgs)
+	slow.Close()
+	assert.Equal(t, []string{"msg3", "msg6"}, readMsgs(fast))
+}
+
+// readMsgs reads buffered entries without blocking
+func readMsgs(sub *Subscription) []string {
+	res := []string{}
+	for len(sub.Entries()) > 0 {
+		res = append(res, (<-sub.Entries()).Msg)
+	}
+	return res
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// IngestService publishes records posted to ingest api
+type IngestService interface {
+	Ingest(entries []core.LogEntry) error
+}
+
+const (
+	defIngestMaxSize = 10 * 1024 * 1024
+	maxIngestErrors  = 100 // max number of rejected records reported back
+	ingestFacility   = 1   // user, the same as for syslog line without <PRI>
+)
+
+// ingestRecord is LogEntry posted to ingest api. Missing severity and facility set to user.notice,
+// the same as for syslog line without <PRI>.
+type ingestRecord struct {
+	core.LogEntry
+	Severity *int `json:"severity"`
+	Facility *int `json:"facility"`
+}
+
+// ingestError is a record rejected by ingest api, index is position of record in the batch
+type ingestError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// ingestResponse is a result of ingest batch
+type ingestResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Errors   []ingestError `json:"errors,omitempty"` // up to maxIngestErrors
+}
+
+// POST /v1/ingest, body is NDJSON or JSON array of LogEntry, gzipped with "Content-Encoding: gzip" header.
+// Valid records published, invalid ones rejected. Returns {"accepted": 10, "rejected": 1, "errors": [{"index": 3, "error": "..."}]}
+// Scoped caller can ingest records of its hosts and containers only.
+func (s *RestServer) ingestCtrl(w http.ResponseWriter, r *http.Request) {
+	data, err := s.ingestBody(w, r)
+	if err != nil {
+		status := http.StatusBadRequest
+		var mbErr *http.MaxBytesError
+		if errors.As(err, &mbErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		rest.SendErrorJSON(w, r, log.Default(), status, err, "failed to read records")
+		return
+	}
+
+	items, err := splitIngest(data)
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, "failed to decode records")
+		return
+	}
+
+	var scope *core.Matcher
+	if cred, ok := credential(r); ok && cred.Scoped() {
+		if scope, err = core.NewMatcher(cred.Apply(core.Request{})); err != nil {
+			rest.SendErrorJSON(w, r, log.Default(), http.StatusInternalServerError, err, "bad credential scope")
+			return
+		}
+	}
+
+	sender := ""
+	if ip, ok := senderIP(r.RemoteAddr); ok {
+		sender = ip.String()
+	}
+	resp := ingestResponse{}
+	entries := make([]core.LogEntry, 0, len(items))
+	now := time.Now()
+	for i, item := range items {
+		ent, e := makeIngestEntry(item, scope, sender, now)
+		if e != nil {
+			resp.Rejected++
+			if len(resp.Errors) < maxIngestErrors {
+				resp.Errors = append(resp.Errors, ingestError{Index: i, Error: e.Error()})
+			}
+			continue
+		}
+		entries = append(entries, ent)
+	}
+
+	if len(entries) > 0 {
+		if err = s.Ingest.Ingest(entries); err != nil {
+			rest.SendErrorJSON(w, r, log.Default(), http.StatusInternalServerError, err, "failed to publish records")
+			return
+		}
+	}
+	resp.Accepted = len(entries)
+	if resp.Rejected > 0 {
+		log.Printf("[WARN] ingest from %s, accepted %d, rejected %d, first error: %s", sender, resp.Accepted, resp.Rejected,
+			resp.Errors[0].Error)
+	}
+	rest.RenderJSON(w, resp)
+}
+
+// ingestBody reads request's body, decompressed if gzipped. Both compressed and decompressed limited by IngestMaxSize.
+func (s *RestServer) ingestBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	maxSize := s.IngestMaxSize
+	if maxSize <= 0 {
+		maxSize = defIngestMaxSize
+	}
+	var rd io.Reader = http.MaxBytesReader(w, r.Body, maxSize)
+	switch enc := strings.ToLower(r.Header.Get("Content-Encoding")); enc {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(rd)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad gzip body")
+		}
+		defer gz.Close() // nolint
+		rd = gz
+	default:
+		return nil, errors.Errorf("unsupported content encoding %q", enc)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(rd, maxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read body")
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errors.WithStack(&http.MaxBytesError{Limit: maxSize}) // decompressed body too large
+	}
+	return data, nil
+}
+
+// splitIngest splits body to records, JSON array if it starts with "[", NDJSON otherwise. Empty lines skipped.
+// Broken array fails the whole body, broken NDJSON line rejected later as a single bad record.
+func splitIngest(data []byte) ([]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		var res []json.RawMessage
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, errors.Wrap(err, "bad json array")
+		}
+		return res, nil
+	}
+
+	var res []json.RawMessage
+	for line := range bytes.SplitSeq(data, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			res = append(res, line)
+		}
+	}
+	return res, nil
+}
+
+// makeIngestEntry validates posted record and makes entry of it. ID and creation time set by server,
+// missing ts set to now and fields parsed from json or logfmt message if not posted.
+func makeIngestEntry(item json.RawMessage, scope *core.Matcher, sender string, now time.Time) (core.LogEntry, error) {
+	rec := ingestRecord{}
+	if err := json.Unmarshal(item, &rec); err != nil {
+		return core.LogEntry{}, errors.Wrap(err, "bad record")
+	}
+
+	ent := rec.LogEntry
+	ent.Msg = strings.TrimRight(ent.Msg, " \t\r\n")
+	if ent.Msg == "" {
+		return core.LogEntry{}, errors.New("empty msg")
+	}
+	if err := checkEntryNames(ent); err != nil {
+		return core.LogEntry{}, err
+	}
+	if scope != nil && (!scope.MatchHost(ent.Host) || !scope.MatchContainer(ent.Container)) {
+		return core.LogEntry{}, errors.Errorf("%s/%s is out of credential's scope", ent.Host, ent.Container)
+	}
+
+	ent.Severity, ent.Facility = core.SevNotice, ingestFacility
+	if rec.Severity != nil {
+		if *rec.Severity < core.SevEmerg || *rec.Severity > core.SevDebug {
+			return core.LogEntry{}, errors.Errorf("severity %d out of range 0-7", *rec.Severity)
+		}
+		ent.Severity = *rec.Severity
+	}
+	if rec.Facility != nil {
+		if *rec.Facility < 0 || *rec.Facility > 23 {
+			return core.LogEntry{}, errors.Errorf("facility %d out of range 0-23", *rec.Facility)
+		}
+		ent.Facility = *rec.Facility
+	}
+
+	ent.ID, ent.Sender, ent.CreatedTS = "", sender, now
+	if ent.TS.IsZero() {
+		ent.TS = now
+	}
+	if ent.Fields == nil {
+		ent.Fields = core.ParseFields(ent.Msg)
+	}
+	return ent, nil
+}
+
+// checkEntryNames checks host and container of entry made from client's input, i.e. ingested or sent with gelf or fluent
+func checkEntryNames(ent core.LogEntry) error {
+	if err := checkIngestName("host", ent.Host); err != nil {
+		return err
+	}
+	return checkIngestName("container", ent.Container)
+}
+
+// checkIngestName checks host or container, used as directory and file name by file logger
+func checkIngestName(kind, name string) error {
+	if name == "" {
+		return errors.Errorf("empty %s", kind)
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\ \t\r\n") {
+		return errors.Errorf("invalid %s %q", kind, name)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestRest_ingestCtrl(t *testing.T) {
+	ingest := &mockIngestService{}
+	srv := RestServer{DataService: &mockDataService{}, Ingest: ingest, IngestMaxSize: 2048}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	post := func(body, encoding string) (status int, resp ingestResponse) {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/ingest", strings.NewReader(body))
+		require.NoError(t, err)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		r, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer r.Body.Close() // nolint
+		if r.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&resp))
+		}
+		return r.StatusCode, resp
+	}
+
+	// ndjson with bad lines
+	status, resp := post(`{"host":"h1","container":"c1","msg":"msg1","ts":"2019-05-24T20:54:30Z","severity":3}
+
+{"host":"h1","container":"c2","msg":"level=info user=u1"}
+{"host":"h1","msg":"no container"}
+{"host":"h1","container":"c1",
+{"host":"h2","container":"c1","msg":"msg4","id":"blah","severity":9}
+`, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, ingestResponse{Accepted: 2, Rejected: 3, Errors: []ingestError{
+		{Index: 2, Error: "empty container"},
+		{Index: 3, Error: "bad record: unexpected end of JSON input"},
+		{Index: 4, Error: "severity 9 out of range 0-7"},
+	}}, resp)
+	recs := ingest.get()
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, "msg1", recs[0].Msg)
+	assert.Equal(t, core.SevErr, recs[0].Severity)
+	assert.Equal(t, 1, recs[0].Facility)
+	assert.Equal(t, time.Date(2019, 5, 24, 20, 54, 30, 0, time.UTC), recs[0].TS)
+	assert.Equal(t, "127.0.0.1", recs[0].Sender)
+	assert.Equal(t, core.SevNotice, recs[1].Severity, "default severity")
+	assert.Equal(t, map[string]string{"level": "info", "user": "u1"}, recs[1].Fields)
+	assert.False(t, recs[1].TS.IsZero())
+	assert.Equal(t, recs[1].TS, recs[1].CreatedTS)
+
+	// gzipped array
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(`[{"host":"h1","container":"c1","msg":"msg5"}, {"host":"..","container":"c1","msg":"msg6"}]`))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	status, resp = post(buf.String(), "gzip")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, ingestResponse{Accepted: 1, Rejected: 1, Errors: []ingestError{{Index: 1, Error: `invalid host ".."`}}}, resp)
+	assert.Equal(t, "msg5", ingest.get()[0].Msg)
+
+	status, _ = post(`[{"host":"h1","container":"c1","msg":"msg1"}`, "")
+	assert.Equal(t, http.StatusBadRequest, status, "broken array")
+	status, _ = post(`{"host":"h1","container":"c1","msg":"msg1"}`, "gzip")
+	assert.Equal(t, http.StatusBadRequest, status, "not gzipped")
+	status, _ = post(`{"host":"h1","container":"c1","msg":"msg1"}`, "br")
+	assert.Equal(t, http.StatusBadRequest, status, "unsupported encoding")
+	status, _ = post(strings.Repeat(`{"host":"h1","container":"c1","msg":"msg1"}`+"\n", 100), "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	buf.Reset()
+	gz = gzip.NewWriter(&buf)
+	_, err = gz.Write([]byte(strings.Repeat(`{"host":"h1","container":"c1","msg":"msg1"}`+"\n", 100)))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	status, _ = post(buf.String(), "gzip")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status, "decompressed body too large")
+
+	ingest.err = errors.New("store failed")
+	status, _ = post(`{"host":"h1","container":"c1","msg":"msg1"}`, "")
+	assert.Equal(t, http.StatusInternalServerError, status)
+}
+
+func TestRest_ingestCtrlScoped(t *testing.T) {
+	auth, err := ParseAuth(strings.NewReader("token:ci:secret\ntoken:team-ci:team-secret:hosts=/^web-/;containers=c1\n"))
+	require.NoError(t, err)
+	ingest := &mockIngestService{}
+	srv := RestServer{DataService: &mockDataService{}, Ingest: ingest, Auth: auth}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	body := `{"host":"web-1","container":"c1","msg":"msg1"}
+{"host":"db-1","container":"c1","msg":"msg2"}
+{"host":"web-2","container":"c2","msg":"msg3"}`
+
+	tbl := []struct {
+		token    string
+		status   int
+		accepted int
+	}{
+		{"", http.StatusUnauthorized, 0},
+		{"secret", http.StatusOK, 3},
+		{"team-secret", http.StatusOK, 1},
+	}
+	for i, tt := range tbl {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/ingest", strings.NewReader(body))
+		require.NoError(t, err)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, tt.status, resp.StatusCode, fmt.Sprintf("mismatch in #%d", i))
+		res := ingestResponse{}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		}
+		assert.Equal(t, tt.accepted, res.Accepted, fmt.Sprintf("mismatch in #%d", i))
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, "msg1", ingest.get()[0].Msg)
+}
+
+func TestRest_ingestCtrlDisabled(t *testing.T) {
+	srv := RestServer{DataService: &mockDataService{}}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+"/v1/ingest", "application/x-ndjson", strings.NewReader(`{"host":"h1","container":"c1","msg":"msg1"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestCheckIngestName(t *testing.T) {
+	tbl := []struct {
+		name string
+		err  string
+	}{
+		{"web-1.example.com", ""},
+		{"", "empty host"},
+		{".", `invalid host "."`},
+		{"..", `invalid host ".."`},
+		{"a/b", `invalid host "a/b"`},
+		{`a\b`, `invalid host "a\\b"`},
+		{"a b", `invalid host "a b"`},
+	}
+	for i, tt := range tbl {
+		err := checkIngestName("host", tt.name)
+		if tt.err == "" {
+			assert.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+type mockIngestService struct {
+	lock sync.Mutex
+	recs []core.LogEntry
+	err  error
+}
+
+func (m *mockIngestService) Ingest(entries []core.LogEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.recs = entries
+	return nil
+}
+
+func (m *mockIngestService) get() []core.LogEntry {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.recs
+}
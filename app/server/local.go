@@ -0,0 +1,491 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// Local store keeps records in append-only segment files in local directory, a pure-go alternative to mongo.
+// Each segment is a file with json record per line, indexed by hosts, containers and time range.
+// Segment rotated on SegmentSize, the oldest segments removed on MaxSize and MaxAge, like capped collection.
+// Record ID is a sequence number in fixed-width hex, ordered as a string.
+type Local struct {
+	LocalParams
+
+	lock          sync.RWMutex
+	segments      []*segment // from old to new, the last one is active
+	active        segmentFile
+	lastSeq       uint64
+	lastPublished core.LogEntry
+	lastCleanup   time.Time
+}
+
+// LocalParams defines location and limits of local store
+type LocalParams struct {
+	Path        string
+	MaxSize     int64         // max total size of segments, bytes
+	MaxAge      time.Duration // max age of records in sealed segments, 0 for unlimited
+	SegmentSize int64         // segment rotated on this size, bytes
+}
+
+const (
+	defLocalMaxSize     = 10 * 1024 * 1024 * 1024 // 10G
+	defLocalSegmentSize = 64 * 1024 * 1024        // 64M
+	localCleanupPeriod  = time.Minute             // max age checked not often than this
+	segmentExt          = ".seg"
+	segmentIndexExt     = ".idx"
+)
+
+// segmentFile is the active segment opened for append, *os.File
+type segmentFile interface {
+	io.WriteCloser
+	Truncate(size int64) error
+}
+
+// segment is a single file of local store with its index
+type segment struct {
+	name string // file name without extension, hex of the first seq
+	segmentIndex
+}
+
+// segmentIndex saved along with sealed segment, active segment indexed on start
+type segmentIndex struct {
+	FirstSeq    uint64          `json:"first_seq"`
+	LastSeq     uint64          `json:"last_seq"`
+	MinTS       time.Time       `json:"min_ts"`
+	MaxTS       time.Time       `json:"max_ts"`
+	LastCreated time.Time       `json:"last_created"`
+	Hosts       map[string]bool `json:"hosts"`
+	Containers  map[string]bool `json:"containers"`
+	Size        int64           `json:"size"`
+	Count       int             `json:"count"`
+}
+
+// segmentScan defines part of segment to read, made under lock and read without it
+type segmentScan struct {
+	path string
+	size int64
+}
+
+// NewLocal makes Local store in params.Path directory, loads indexes of existing segments
+func NewLocal(params LocalParams) (*Local, error) {
+	log.Printf("[INFO] make new local store with %+v", params)
+	if params.MaxSize == 0 {
+		params.MaxSize = defLocalMaxSize
+	}
+	if params.SegmentSize == 0 {
+		params.SegmentSize = defLocalSegmentSize
+	}
+	if err := os.MkdirAll(params.Path, 0o750); err != nil {
+		return nil, errors.Wrapf(err, "can't make local store directory %s", params.Path)
+	}
+
+	res := &Local{LocalParams: params, lastCleanup: time.Now()}
+	if err := res.load(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Publish appends records to the active segment
+func (l *Local) Publish(records []core.LogEntry) error {
+	if len(records) == 0 {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	buf := bytes.Buffer{}
+	recs := make([]core.LogEntry, len(records))
+	for i, r := range records {
+		r.ID = seqID(l.lastSeq + uint64(i) + 1)
+		if r.CreatedTS.IsZero() {
+			r.CreatedTS = time.Now()
+		}
+		if err := json.NewEncoder(&buf).Encode(r); err != nil {
+			return errors.Wrapf(err, "can't encode record %s", r.ID)
+		}
+		recs[i] = r
+	}
+	if _, err := l.active.Write(buf.Bytes()); err != nil {
+		l.dropTail()
+		return errors.Wrapf(err, "publish %d records", len(records))
+	}
+
+	seg := l.segments[len(l.segments)-1]
+	for _, r := range recs {
+		seg.add(r)
+	}
+	seg.Size += int64(buf.Len())
+	l.lastSeq += uint64(len(recs))
+	l.lastPublished = recs[len(recs)-1]
+	copy(records, recs)
+
+	if seg.Size >= l.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	l.cleanup()
+	return nil
+}
+
+// LastPublished returns latest published entry
+func (l *Local) LastPublished() (entry core.LogEntry, err error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.lastPublished, nil
+}
+
+// Find records matching given request. Returns records after LastID from old to new,
+// or the latest records if LastID not defined.
+func (l *Local) Find(req core.Request) ([]core.LogEntry, error) {
+	if req.Limit == 0 || req.Limit > defaultLimit {
+		req.Limit = defaultLimit
+	}
+	matcher, err := core.NewMatcher(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad request %+v", req)
+	}
+
+	afterSeq, fromStart := uint64(0), req.LastID == "" || req.LastID == "0"
+	if !fromStart {
+		afterSeq = parseSeqID(req.LastID)
+	}
+
+	l.lock.RLock()
+	if !fromStart && afterSeq >= l.lastSeq {
+		l.lock.RUnlock()
+		return []core.LogEntry{}, nil
+	}
+	var scans []segmentScan
+	for _, seg := range l.segments {
+		if seg.Count > 0 && seg.LastSeq > afterSeq && seg.match(matcher) {
+			scans = append(scans, segmentScan{path: l.segmentPath(seg.name, segmentExt), size: seg.Size})
+		}
+	}
+	l.lock.RUnlock()
+
+	result := []core.LogEntry{}
+	if !fromStart {
+		for _, sc := range scans {
+			err = l.scan(sc, func(r core.LogEntry) bool {
+				if parseSeqID(r.ID) > afterSeq && matcher.Match(r) {
+					result = append(result, r)
+				}
+				return len(result) < req.Limit
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "can't get records for %+v", req)
+			}
+			if len(result) >= req.Limit {
+				break
+			}
+		}
+		log.Printf("[DEBUG] req: %+v, recs=%d", req, len(result))
+		return result, nil
+	}
+
+	// no LastID, collect the latest matching records going from the newest segment
+	for i := len(scans) - 1; i >= 0 && len(result) < req.Limit; i-- {
+		var segRecs []core.LogEntry
+		err = l.scan(scans[i], func(r core.LogEntry) bool {
+			if matcher.Match(r) {
+				segRecs = append(segRecs, r)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get records for %+v", req)
+		}
+		if need := req.Limit - len(result); len(segRecs) > need {
+			segRecs = segRecs[len(segRecs)-need:]
+		}
+		result = append(segRecs, result...)
+	}
+	log.Printf("[DEBUG] req: %+v, recs=%d", req, len(result))
+	return result, nil
+}
+
+// Close the active segment. It is indexed on the next start
+func (l *Local) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.active.Close()
+}
+
+// load indexes existing segments and opens the last one for append, makes the first segment if nothing found
+func (l *Local) load() error {
+	files, err := filepath.Glob(filepath.Join(l.Path, "*"+segmentExt))
+	if err != nil {
+		return errors.Wrapf(err, "can't list segments in %s", l.Path)
+	}
+	sort.Strings(files) // names are fixed-width hex of the first seq
+
+	for i, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), segmentExt)
+		seg, e := l.loadSegment(name, i == len(files)-1)
+		if e != nil {
+			return e
+		}
+		l.segments = append(l.segments, seg)
+		if seg.Count > 0 {
+			l.lastSeq = seg.LastSeq
+		}
+	}
+
+	if len(l.segments) == 0 {
+		return l.openSegment()
+	}
+
+	active := l.segments[len(l.segments)-1]
+	if l.active, err = os.OpenFile(l.segmentPath(active.name, segmentExt), os.O_WRONLY|os.O_APPEND, 0o640); err != nil {
+		return errors.Wrapf(err, "can't open segment %s", active.name)
+	}
+	// last record cached for LastPublished
+	for i := len(l.segments) - 1; i >= 0 && l.lastPublished.ID == ""; i-- {
+		seg := l.segments[i]
+		err = l.scan(segmentScan{path: l.segmentPath(seg.name, segmentExt), size: seg.Size}, func(r core.LogEntry) bool {
+			l.lastPublished = r
+			return true
+		})
+		if err != nil {
+			return errors.Wrapf(err, "can't read segment %s", seg.name)
+		}
+	}
+	log.Printf("[INFO] loaded %d segments, last id %s", len(l.segments), seqID(l.lastSeq))
+	return nil
+}
+
+// loadSegment gets index of sealed segment from idx file, or makes it by scanning the segment.
+// Active segment always scanned, broken tail (i.e. after crash) truncated.
+func (l *Local) loadSegment(name string, active bool) (*segment, error) {
+	seg := newSegment(name)
+	if !active {
+		if data, err := os.ReadFile(l.segmentPath(name, segmentIndexExt)); err == nil && json.Unmarshal(data, &seg.segmentIndex) == nil {
+			return seg, nil
+		}
+	}
+
+	fpath := l.segmentPath(name, segmentExt)
+	fh, err := os.Open(fpath) //nolint:gosec // path made from store's directory
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open segment %s", name)
+	}
+	defer fh.Close() //nolint
+
+	rd := bufio.NewReader(fh)
+	for {
+		line, e := rd.ReadBytes('\n')
+		if e == io.EOF && len(line) == 0 {
+			break
+		}
+		rec := core.LogEntry{}
+		if e != nil || json.Unmarshal(line, &rec) != nil || parseSeqID(rec.ID) == 0 {
+			log.Printf("[WARN] broken record in segment %s at %d, truncated", name, seg.Size)
+			if err = os.Truncate(fpath, seg.Size); err != nil {
+				return nil, errors.Wrapf(err, "can't truncate segment %s", name)
+			}
+			break
+		}
+		seg.add(rec)
+		seg.Size += int64(len(line))
+	}
+
+	if !active {
+		if err = l.saveIndex(seg); err != nil {
+			log.Printf("[WARN] can't save index, %v", err)
+		}
+	}
+	return seg, nil
+}
+
+// dropTail removes partially written records from the active segment after failed write. If it can't be truncated,
+// segment sealed with the last good size, and its tail not read as scans are limited by size.
+func (l *Local) dropTail() {
+	seg := l.segments[len(l.segments)-1]
+	err := l.active.Truncate(seg.Size)
+	if err == nil {
+		return
+	}
+	log.Printf("[WARN] can't truncate segment %s to %d, %v", seg.name, seg.Size, err)
+	if seg.Count == 0 {
+		return // the next segment would have the same name
+	}
+	if err = l.rotate(); err != nil {
+		log.Printf("[WARN] can't rotate segment %s, %v", seg.name, err)
+	}
+}
+
+// rotate seals the active segment and opens the new one
+func (l *Local) rotate() error {
+	seg := l.segments[len(l.segments)-1]
+	if err := l.saveIndex(seg); err != nil {
+		log.Printf("[WARN] can't save index, %v", err)
+	}
+	if err := l.active.Close(); err != nil {
+		log.Printf("[WARN] can't close segment %s, %v", seg.name, err)
+	}
+	log.Printf("[DEBUG] segment %s sealed, %d records, size %d", seg.name, seg.Count, seg.Size)
+	return l.openSegment()
+}
+
+// openSegment makes the new active segment starting from the next seq
+func (l *Local) openSegment() (err error) {
+	seg := newSegment(fmt.Sprintf("%016x", l.lastSeq+1))
+	fpath := l.segmentPath(seg.name, segmentExt)
+	if l.active, err = os.OpenFile(fpath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640); err != nil { //nolint:gosec
+		return errors.Wrapf(err, "can't make segment %s", fpath)
+	}
+	l.segments = append(l.segments, seg)
+	return nil
+}
+
+// cleanup removes the oldest sealed segments exceeding MaxSize or MaxAge
+func (l *Local) cleanup() {
+	var total int64
+	for _, seg := range l.segments {
+		total += seg.Size
+	}
+	checkAge := l.MaxAge > 0 && time.Since(l.lastCleanup) >= localCleanupPeriod
+	if total <= l.MaxSize && !checkAge {
+		return
+	}
+	l.lastCleanup = time.Now()
+
+	for len(l.segments) > 1 {
+		seg := l.segments[0]
+		expired := l.MaxAge > 0 && time.Since(seg.LastCreated) > l.MaxAge
+		if total <= l.MaxSize && !expired {
+			break
+		}
+		for _, ext := range []string{segmentExt, segmentIndexExt} {
+			if err := os.Remove(l.segmentPath(seg.name, ext)); err != nil && !os.IsNotExist(err) {
+				log.Printf("[WARN] can't remove %s%s, %v", seg.name, ext, err)
+			}
+		}
+		total -= seg.Size
+		l.segments = l.segments[1:]
+		log.Printf("[INFO] segment %s removed, %d records, size %d, expired %v", seg.name, seg.Count, seg.Size, expired)
+	}
+}
+
+// scan reads records of the segment up to scan size, stops when fn returns false
+func (l *Local) scan(sc segmentScan, fn func(r core.LogEntry) bool) error {
+	fh, err := os.Open(sc.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // removed by cleanup
+		}
+		return err
+	}
+	defer fh.Close() //nolint
+
+	rd := bufio.NewReader(io.LimitReader(fh, sc.size))
+	for {
+		line, e := rd.ReadBytes('\n')
+		if e == io.EOF {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		rec := core.LogEntry{}
+		if e = json.Unmarshal(line, &rec); e != nil {
+			return errors.Wrapf(e, "can't decode record in %s", sc.path)
+		}
+		if !fn(rec) {
+			return nil
+		}
+	}
+}
+
+func (l *Local) saveIndex(seg *segment) error {
+	data, err := json.Marshal(seg.segmentIndex)
+	if err != nil {
+		return errors.Wrapf(err, "can't encode index of %s", seg.name)
+	}
+	return errors.Wrapf(os.WriteFile(l.segmentPath(seg.name, segmentIndexExt), data, 0o640), "can't save index of %s", seg.name)
+}
+
+func (l *Local) segmentPath(name, ext string) string {
+	return filepath.Join(l.Path, name+ext)
+}
+
+func newSegment(name string) *segment {
+	return &segment{name: name, segmentIndex: segmentIndex{Hosts: map[string]bool{}, Containers: map[string]bool{}}}
+}
+
+// add record to segment's index, size not changed
+func (s *segment) add(r core.LogEntry) {
+	seq := parseSeqID(r.ID)
+	if s.Count == 0 || seq < s.FirstSeq {
+		s.FirstSeq = seq
+	}
+	if seq > s.LastSeq {
+		s.LastSeq = seq
+	}
+	if s.Count == 0 || r.TS.Before(s.MinTS) {
+		s.MinTS = r.TS
+	}
+	if r.TS.After(s.MaxTS) {
+		s.MaxTS = r.TS
+	}
+	if r.CreatedTS.After(s.LastCreated) {
+		s.LastCreated = r.CreatedTS
+	}
+	s.Hosts[r.Host] = true
+	s.Containers[r.Container] = true
+	s.Count++
+}
+
+// match checks if segment may have records for the matcher
+func (s *segment) match(m *core.Matcher) bool {
+	if !m.MatchTime(s.MinTS, s.MaxTS) {
+		return false
+	}
+	hostFound := false
+	for h := range s.Hosts {
+		if m.MatchHost(h) {
+			hostFound = true
+			break
+		}
+	}
+	if !hostFound {
+		return false
+	}
+	for c := range s.Containers {
+		if m.MatchContainer(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func seqID(seq uint64) string {
+	return fmt.Sprintf("%024x", seq)
+}
+
+// parseSeqID returns seq of ID, 0 for bad ID
+func parseSeqID(id string) uint64 {
+	seq, err := strconv.ParseUint(id, 16, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
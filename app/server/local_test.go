@@ -0,0 +1,244 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+	"github.com/umputun/dkll/app/server/storetest"
+)
+
+func TestLocal_PublishFind(t *testing.T) {
+	l, err := NewLocal(LocalParams{Path: t.TempDir()})
+	require.NoError(t, err)
+	defer l.Close() // nolint
+
+	last, err := l.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, "", last.ID, "empty store")
+
+	require.NoError(t, l.Publish(testRecords()))
+
+	last, err = l.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, "msg6", last.Msg)
+	assert.Equal(t, "000000000000000000000006", last.ID)
+
+	tbl := []struct {
+		req  core.Request
+		msgs []string
+	}{
+		{core.Request{}, []string{"msg1", "msg2", "msg3", "msg4", "msg5", "msg6"}},
+		{core.Request{Limit: 2}, []string{"msg5", "msg6"}},
+		{core.Request{LastID: "000000000000000000000002", Limit: 2}, []string{"msg3", "msg4"}},
+		{core.Request{LastID: "000000000000000000000006"}, []string{}},
+		{core.Request{Hosts: []string{"h2"}}, []string{"msg3", "msg6"}},
+		{core.Request{Hosts: []string{"/^h/"}, Excludes: []string{"c2"}}, []string{"msg1", "msg3", "msg4"}},
+		{core.Request{ExcludeHosts: []string{"/1$/"}}, []string{"msg3", "msg6"}},
+		{core.Request{Containers: []string{"/c/"}, Excludes: []string{"c1"}, Hosts: []string{"h1"}}, []string{"msg2", "msg5"}},
+		{core.Request{FromTS: time.Date(2019, 5, 24, 20, 54, 32, 0, time.Local),
+			ToTS: time.Date(2019, 5, 24, 20, 54, 34, 0, time.Local)}, []string{"msg3", "msg4"}},
+		{core.Request{Groups: []string{"db"}}, []string{"msg2"}},
+		{core.Request{MinSeverity: "err"}, []string{"msg2"}},
+		{core.Request{Fields: []string{"level=error"}}, []string{"msg4"}},
+		{core.Request{Grep: []string{"/msg[12]/"}, UnGrep: []string{"msg2"}}, []string{"msg1"}},
+		{core.Request{Hosts: []string{"unknown"}}, []string{}},
+	}
+
+	for i, tt := range tbl {
+		recs, err := l.Find(tt.req)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		msgs := []string{}
+		for _, r := range recs {
+			msgs = append(msgs, r.Msg)
+		}
+		assert.Equal(t, tt.msgs, msgs, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	_, err = l.Find(core.Request{Hosts: []string{"/[bad/"}})
+	assert.Error(t, err)
+}
+
+func TestLocal_RotateAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(LocalParams{Path: dir, SegmentSize: 500})
+	require.NoError(t, err)
+	for range 5 {
+		require.NoError(t, l.Publish(testRecords()))
+	}
+	require.NoError(t, l.Close())
+
+	segs, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	assert.True(t, len(segs) > 5, "rotated, %d segments", len(segs))
+	idxs, err := filepath.Glob(filepath.Join(dir, "*.idx"))
+	require.NoError(t, err)
+	assert.Equal(t, len(segs)-1, len(idxs), "sealed segments indexed")
+
+	l, err = NewLocal(LocalParams{Path: dir, SegmentSize: 500})
+	require.NoError(t, err)
+	defer l.Close() // nolint
+
+	last, err := l.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, seqID(30), last.ID)
+
+	recs, err := l.Find(core.Request{LastID: seqID(10), Limit: 5})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(recs))
+	for i, r := range recs {
+		assert.Equal(t, seqID(uint64(11+i)), r.ID)
+	}
+
+	recs, err = l.Find(core.Request{Hosts: []string{"h2"}, Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(recs))
+	assert.Equal(t, []string{seqID(24), seqID(27), seqID(30)}, []string{recs[0].ID, recs[1].ID, recs[2].ID})
+
+	require.NoError(t, l.Publish(testRecords()[:1]))
+	last, err = l.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, seqID(31), last.ID, "sequence continued")
+}
+
+func TestLocal_BrokenTail(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(LocalParams{Path: dir})
+	require.NoError(t, err)
+	require.NoError(t, l.Publish(testRecords()))
+	require.NoError(t, l.Close())
+
+	fh, err := os.OpenFile(filepath.Join(dir, "0000000000000001.seg"), os.O_WRONLY|os.O_APPEND, 0o640)
+	require.NoError(t, err)
+	_, err = fh.WriteString(`{"id":"000000000000000000000007","host":"h`)
+	require.NoError(t, err)
+	require.NoError(t, fh.Close())
+
+	l, err = NewLocal(LocalParams{Path: dir})
+	require.NoError(t, err)
+	defer l.Close() // nolint
+	require.NoError(t, l.Publish(testRecords()[:1]))
+
+	recs, err := l.Find(core.Request{})
+	require.NoError(t, err)
+	require.Equal(t, 7, len(recs))
+	assert.Equal(t, seqID(7), recs[6].ID)
+	assert.Equal(t, "msg1", recs[6].Msg)
+}
+
+func TestLocal_FailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(LocalParams{Path: dir})
+	require.NoError(t, err)
+	defer l.Close() // nolint
+	require.NoError(t, l.Publish(testRecords()[:2]))
+
+	// partial write truncated back
+	l.active = &failingSegmentFile{segmentFile: l.active, n: 10}
+	assert.Error(t, l.Publish(testRecords()[2:4]))
+	l.active = l.active.(*failingSegmentFile).segmentFile
+	require.NoError(t, l.Publish(testRecords()[4:]))
+	recs, err := l.Find(core.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msg1", "msg2", "msg5", "msg6"}, []string{recs[0].Msg, recs[1].Msg, recs[2].Msg, recs[3].Msg})
+	assert.Equal(t, 1, len(l.segments))
+
+	// segment can't be truncated, rotated
+	l.active = &failingSegmentFile{segmentFile: l.active, n: 10, truncErr: errors.New("truncate failed")}
+	assert.Error(t, l.Publish(testRecords()[:1]))
+	require.Equal(t, 2, len(l.segments))
+	require.NoError(t, l.Publish(testRecords()[1:2]))
+	recs, err = l.Find(core.Request{})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(recs))
+	assert.Equal(t, "msg2", recs[4].Msg)
+
+	// nothing broken on reopen
+	require.NoError(t, l.Close())
+	l, err = NewLocal(LocalParams{Path: dir})
+	require.NoError(t, err)
+	recs, err = l.Find(core.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, len(recs))
+}
+
+func TestLocal_Retention(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(LocalParams{Path: dir, SegmentSize: 500, MaxSize: 2000})
+	require.NoError(t, err)
+	defer l.Close() // nolint
+	for range 10 {
+		require.NoError(t, l.Publish(testRecords()))
+	}
+
+	var total int64
+	segs, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	require.NoError(t, err)
+	for _, s := range segs {
+		fi, e := os.Stat(s)
+		require.NoError(t, e)
+		total += fi.Size()
+	}
+	assert.True(t, total <= 2000+500, "total size %d limited", total)
+
+	recs, err := l.Find(core.Request{LastID: "0"})
+	require.NoError(t, err)
+	require.True(t, len(recs) > 0)
+	assert.True(t, recs[0].ID > seqID(1), "oldest removed")
+	assert.Equal(t, seqID(60), recs[len(recs)-1].ID)
+
+	// expire all sealed segments by age
+	l.MaxAge = time.Millisecond
+	l.lastCleanup = time.Time{}
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, l.Publish(testRecords()[:1]))
+	assert.Equal(t, 1, len(l.segments), "only active segment left")
+}
+
+func testRecords() []core.LogEntry {
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	return []core.LogEntry{
+		{Host: "h1", Container: "c1", Msg: "msg1", TS: ts.Add(0 * time.Second), Severity: core.SevInfo},
+		{Host: "h1", Container: "c2", Group: "db", Msg: "msg2", TS: ts.Add(1 * time.Second), Severity: core.SevErr},
+		{Host: "h2", Container: "c1", Msg: "msg3", TS: ts.Add(2 * time.Second), Severity: core.SevInfo},
+		{Host: "h1", Container: "c1", Msg: "msg4", TS: ts.Add(3 * time.Second), Severity: core.SevInfo,
+			Fields: map[string]string{"level": "error"}},
+		{Host: "h1", Container: "c2", Msg: "msg5", TS: ts.Add(4 * time.Second), Severity: core.SevInfo},
+		{Host: "h2", Container: "c2", Msg: "msg6", TS: ts.Add(5 * time.Second), Severity: core.SevInfo},
+	}
+}
+
+func TestLocal_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		l, err := NewLocal(LocalParams{Path: t.TempDir(), SegmentSize: 16 * 1024})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+		return l
+	})
+}
+
+// failingSegmentFile writes the first n bytes and fails
+type failingSegmentFile struct {
+	segmentFile
+	n        int
+	truncErr error
+}
+
+func (f *failingSegmentFile) Write(p []byte) (int, error) {
+	n, _ := f.segmentFile.Write(p[:min(f.n, len(p))])
+	return n, errors.New("disk full")
+}
+
+func (f *failingSegmentFile) Truncate(size int64) error {
+	if f.truncErr != nil {
+		return f.truncErr
+	}
+	return f.segmentFile.Truncate(size)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// Memory store keeps the latest records in a bounded ring buffer, for dev, demo and tests.
+// Nothing persisted, the oldest records overwritten after maxRecords. IDs are the same sequence as for Local store.
+type Memory struct {
+	lock       sync.RWMutex
+	maxRecords int
+	recs       []core.LogEntry // ring buffer, grows up to maxRecords
+	head       int             // position of the oldest record
+	lastSeq    uint64
+}
+
+const defMemoryMaxRecords = 100000
+
+// NewMemory makes Memory store keeping up to maxRecords latest records
+func NewMemory(maxRecords int) *Memory {
+	if maxRecords <= 0 {
+		maxRecords = defMemoryMaxRecords
+	}
+	log.Printf("[INFO] make new memory store with max records %d", maxRecords)
+	return &Memory{maxRecords: maxRecords}
+}
+
+// Publish adds records to the ring buffer, the oldest records overwritten
+func (m *Memory) Publish(records []core.LogEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i := range records {
+		m.lastSeq++
+		records[i].ID = seqID(m.lastSeq)
+		if records[i].CreatedTS.IsZero() {
+			records[i].CreatedTS = time.Now()
+		}
+		r := records[i]
+		if len(m.recs) < m.maxRecords {
+			m.recs = append(m.recs, r)
+			continue
+		}
+		m.recs[m.head] = r
+		m.head = (m.head + 1) % len(m.recs)
+	}
+	return nil
+}
+
+// LastPublished returns latest published entry
+func (m *Memory) LastPublished() (entry core.LogEntry, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if len(m.recs) == 0 {
+		return core.LogEntry{}, nil
+	}
+	return m.at(len(m.recs) - 1), nil
+}
+
+// Find records matching given request. Returns records after LastID from old to new,
+// or the latest records if LastID not defined.
+func (m *Memory) Find(req core.Request) ([]core.LogEntry, error) {
+	if req.Limit == 0 || req.Limit > defaultLimit {
+		req.Limit = defaultLimit
+	}
+	matcher, err := core.NewMatcher(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad request %+v", req)
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	result := []core.LogEntry{}
+	if req.LastID != "" && req.LastID != "0" {
+		// records in buffer have sequential ids, so position of the next record is known
+		firstSeq := m.lastSeq - uint64(len(m.recs)) + 1
+		start := 0
+		if afterSeq := parseSeqID(req.LastID); afterSeq >= firstSeq {
+			start = int(min(afterSeq-firstSeq+1, uint64(len(m.recs))))
+		}
+		for i := start; i < len(m.recs) && len(result) < req.Limit; i++ {
+			if r := m.at(i); matcher.Match(r) {
+				result = append(result, r)
+			}
+		}
+		return result, nil
+	}
+
+	for i := len(m.recs) - 1; i >= 0 && len(result) < req.Limit; i-- {
+		if r := m.at(i); matcher.Match(r) {
+			result = append(result, r)
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, nil
+}
+
+// at returns i-th record from the oldest one
+func (m *Memory) at(i int) core.LogEntry {
+	return m.recs[(m.head+i)%len(m.recs)]
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// MemoryRejects keeps the latest lines failed to parse in memory, for stores without their own RejectStore,
+// i.e. memory and local. Nothing persisted, the oldest lines dropped after maxRecords.
+type MemoryRejects struct {
+	lock       sync.RWMutex
+	maxRecords int
+	recs       []core.Rejected // from old to new
+	lastSeq    uint64
+}
+
+const defMaxMemoryRejects = 10000
+
+// NewMemoryRejects makes MemoryRejects keeping up to maxRecords latest lines
+func NewMemoryRejects(maxRecords int) *MemoryRejects {
+	if maxRecords <= 0 {
+		maxRecords = defMaxMemoryRejects
+	}
+	return &MemoryRejects{maxRecords: maxRecords}
+}
+
+// Reject saves line failed to parse, the oldest line dropped if full
+func (m *MemoryRejects) Reject(rec core.Rejected) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lastSeq++
+	rec.ID = strconv.FormatUint(m.lastSeq, 10)
+	if len(m.recs) >= m.maxRecords {
+		copy(m.recs, m.recs[1:])
+		m.recs = m.recs[:len(m.recs)-1]
+	}
+	m.recs = append(m.recs, rec)
+	return nil
+}
+
+// Rejected returns up to limit latest rejected lines, sorted from old to new. pendingOnly skips replayed lines
+func (m *MemoryRejects) Rejected(limit int, pendingOnly bool) ([]core.Rejected, error) {
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	start := len(m.recs)
+	for n := 0; start > 0 && n < limit; start-- {
+		if !pendingOnly || !m.recs[start-1].Replayed {
+			n++
+		}
+	}
+	res := []core.Rejected{}
+	for _, r := range m.recs[start:] {
+		if !pendingOnly || !r.Replayed {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+// MarkReplayed sets replayed flag for rejected lines, ids of dropped lines ignored
+func (m *MemoryRejects) MarkReplayed(ids []string) error {
+	seqs := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		seq, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "bad rejected id %q", id)
+		}
+		seqs[seq] = true
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, r := range m.recs {
+		if seq, _ := strconv.ParseUint(r.ID, 10, 64); seqs[seq] {
+			m.recs[i].Replayed = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestMemoryRejects(t *testing.T) {
+	m := NewMemoryRejects(3)
+	recs, err := m.Rejected(10, false)
+	require.NoError(t, err)
+	assert.Equal(t, []core.Rejected{}, recs, "empty store")
+
+	for i := range 5 {
+		require.NoError(t, m.Reject(core.Rejected{Line: fmt.Sprintf("line%d", i), Sender: "127.0.0.1:12345", Error: "bad"}))
+	}
+	require.NoError(t, m.MarkReplayed([]string{"4", "1"}))
+	assert.EqualError(t, m.MarkReplayed([]string{"x"}), `bad rejected id "x": strconv.ParseUint: parsing "x": invalid syntax`)
+
+	tbl := []struct {
+		limit       int
+		pendingOnly bool
+		lines       []string
+	}{
+		{10, false, []string{"line2", "line3", "line4"}},
+		{2, false, []string{"line3", "line4"}},
+		{0, false, []string{"line2", "line3", "line4"}},
+		{10, true, []string{"line2", "line4"}},
+		{1, true, []string{"line4"}},
+	}
+	for i, tt := range tbl {
+		recs, err := m.Rejected(tt.limit, tt.pendingOnly)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		lines := []string{}
+		for _, r := range recs {
+			lines = append(lines, r.Line)
+		}
+		assert.Equal(t, tt.lines, lines, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	recs, err = m.Rejected(1, false)
+	require.NoError(t, err)
+	assert.Equal(t, []core.Rejected{{ID: "5", Line: "line4", Sender: "127.0.0.1:12345", Error: "bad"}}, recs)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+	"github.com/umputun/dkll/app/server/storetest"
+)
+
+func TestMemory_PublishFind(t *testing.T) {
+	m := NewMemory(100)
+
+	last, err := m.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, "", last.ID, "empty store")
+
+	require.NoError(t, m.Publish(testRecords()))
+	last, err = m.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, "msg6", last.Msg)
+	assert.Equal(t, seqID(6), last.ID)
+
+	tbl := []struct {
+		req  core.Request
+		msgs []string
+	}{
+		{core.Request{}, []string{"msg1", "msg2", "msg3", "msg4", "msg5", "msg6"}},
+		{core.Request{Limit: 2}, []string{"msg5", "msg6"}},
+		{core.Request{LastID: seqID(2), Limit: 2}, []string{"msg3", "msg4"}},
+		{core.Request{LastID: seqID(6)}, []string{}},
+		{core.Request{Hosts: []string{"/^h/"}, Excludes: []string{"c2"}}, []string{"msg1", "msg3", "msg4"}},
+		{core.Request{LastID: seqID(3), Hosts: []string{"h2"}}, []string{"msg6"}},
+		{core.Request{FromTS: time.Date(2019, 5, 24, 20, 54, 32, 0, time.Local),
+			ToTS: time.Date(2019, 5, 24, 20, 54, 34, 0, time.Local)}, []string{"msg3", "msg4"}},
+		{core.Request{MinSeverity: "err"}, []string{"msg2"}},
+	}
+	for i, tt := range tbl {
+		recs, err := m.Find(tt.req)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		msgs := []string{}
+		for _, r := range recs {
+			msgs = append(msgs, r.Msg)
+		}
+		assert.Equal(t, tt.msgs, msgs, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	_, err = m.Find(core.Request{Containers: []string{"/[bad/"}})
+	assert.Error(t, err)
+}
+
+func TestMemory_Ring(t *testing.T) {
+	m := NewMemory(10)
+	for range 4 {
+		require.NoError(t, m.Publish(testRecords()))
+	}
+	assert.Equal(t, 10, len(m.recs), "bounded")
+
+	recs, err := m.Find(core.Request{})
+	require.NoError(t, err)
+	require.Equal(t, 10, len(recs))
+	assert.Equal(t, seqID(15), recs[0].ID, "oldest records overwritten")
+	assert.Equal(t, seqID(24), recs[9].ID)
+
+	recs, err = m.Find(core.Request{LastID: seqID(2), Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(recs))
+	assert.Equal(t, seqID(15), recs[0].ID, "from the oldest kept")
+
+	recs, err = m.Find(core.Request{LastID: seqID(20)})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(recs))
+	assert.Equal(t, seqID(21), recs[0].ID)
+
+	last, err := m.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, seqID(24), last.ID)
+}
+
+func TestMemory_Conformance(t *testing.T) {
+	storetest.Run(t, func(*testing.T) storetest.Store { return NewMemory(0) })
+}
@@ -32,6 +32,7 @@ type Mongo struct {
 type MongoParams struct {
 	MaxDocs            int
 	MaxCollectionSize  int
+	MaxRejected        int // max docs in <collection>_rejected with lines failed to parse
 	Delay              time.Duration
 	DBName, Collection string
 }
@@ -39,16 +40,33 @@ type MongoParams struct {
 const (
 	defMaxDocs           = 100000000               // 100 Millions
 	defMaxCollectionSize = 10 * 1024 * 1024 * 1024 // 10G
+	defMaxRejected       = 10000
+	maxRejectedSize      = 100 * 1024 * 1024 // 100M
 	defaultLimit         = 1000
 )
 
 type mongoLogEntry struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	Host      string             `bson:"host"`
-	Container string             `bson:"container"`
-	Pid       int                `bson:"pid"`
-	Msg       string             `bson:"msg"`
-	TS        time.Time          `bson:"ts"`
+	ID         primitive.ObjectID           `bson:"_id,omitempty"`
+	Host       string                       `bson:"host"`
+	Sender     string                       `bson:"sender,omitempty"`
+	Container  string                       `bson:"container"`
+	Group      string                       `bson:"group,omitempty"`
+	Pid        int                          `bson:"pid"`
+	Msg        string                       `bson:"msg"`
+	TS         time.Time                    `bson:"ts"`
+	StructData map[string]map[string]string `bson:"sd,omitempty"`
+	Facility   int                          `bson:"facility"`
+	Severity   *int                         `bson:"severity,omitempty"` // nil for records stored before severity was added
+	Fields     map[string]string            `bson:"fields,omitempty"`
+}
+
+type mongoRejected struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	Line       string             `bson:"line"`
+	Sender     string             `bson:"sender"`
+	ReceivedTS time.Time          `bson:"received_ts"`
+	Error      string             `bson:"error"`
+	Replayed   bool               `bson:"replayed"` // always stored, capped collection doesn't allow doc to grow on update
 }
 
 // NewMongo makes Mongo accessor
@@ -61,6 +79,9 @@ func NewMongo(client *mdrv.Client, params MongoParams) (res *Mongo, err error) {
 	if params.MaxDocs == 0 {
 		params.MaxDocs = defMaxDocs
 	}
+	if params.MaxRejected == 0 {
+		params.MaxRejected = defMaxRejected
+	}
 
 	res = &Mongo{Client: client, MongoParams: params}
 	if err := res.init(params.Collection); err != nil {
@@ -72,8 +93,10 @@ func NewMongo(client *mdrv.Client, params MongoParams) (res *Mongo, err error) {
 // Publish inserts buffer to mongo
 func (m *Mongo) Publish(records []core.LogEntry) (err error) {
 	recs := make([]any, len(records))
+	ids := make([]string, len(records))
 	for i, v := range records {
-		recs[i] = m.makeMongoEntry(v)
+		me := m.makeMongoEntry(v)
+		recs[i], ids[i] = me, me.ID.Hex()
 	}
 
 	coll := m.Database(m.DBName).Collection(m.Collection)
@@ -81,6 +104,9 @@ func (m *Mongo) Publish(records []core.LogEntry) (err error) {
 	if err != nil {
 		return errors.Wrapf(err, "publish %d records", len(records))
 	}
+	for i := range records {
+		records[i].ID = ids[i]
+	}
 
 	if len(res.InsertedIDs) > 0 {
 		m.lastPublished.Lock()
@@ -120,6 +146,21 @@ func (m *Mongo) Find(req core.Request) ([]core.LogEntry, error) {
 		req.Limit = defaultLimit
 	}
 	req = m.sanitizeReq(req)
+	if req.MinSeverity != "" {
+		if _, err := core.ParseSeverity(req.MinSeverity); err != nil {
+			return nil, errors.Wrapf(err, "bad request %+v", req)
+		}
+	}
+	for _, f := range req.Fields {
+		if _, err := core.ParseFieldFilter(f); err != nil {
+			return nil, errors.Wrapf(err, "bad request %+v", req)
+		}
+	}
+	for _, g := range append(append([]string{}, req.Grep...), req.UnGrep...) {
+		if _, err := core.GrepPattern(g); err != nil {
+			return nil, errors.Wrapf(err, "bad request %+v", req)
+		}
+	}
 	// eliminate mongo find if lastPublished ID < req.LastID
 	m.lastPublished.Lock()
 	lastPublishedCached := m.lastPublished.entry
@@ -157,6 +198,63 @@ func (m *Mongo) Find(req core.Request) ([]core.LogEntry, error) {
 	return result, nil
 }
 
+// Reject saves line failed to parse to capped <collection>_rejected
+func (m *Mongo) Reject(rec core.Rejected) error {
+	mrec := mongoRejected{ID: primitive.NewObjectID(), Line: rec.Line, Sender: rec.Sender, ReceivedTS: rec.ReceivedTS,
+		Error: rec.Error, Replayed: rec.Replayed}
+	if _, err := m.rejectedColl().InsertOne(context.TODO(), mrec); err != nil {
+		return errors.Wrap(err, "can't save rejected line")
+	}
+	return nil
+}
+
+// Rejected returns up to limit latest rejected lines, sorted from old to new. pendingOnly skips replayed lines
+func (m *Mongo) Rejected(limit int, pendingOnly bool) ([]core.Rejected, error) {
+	query := bson.M{}
+	if pendingOnly {
+		query["replayed"] = false
+	}
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+
+	var mrecs []mongoRejected
+	cursor, err := m.rejectedColl().Find(context.TODO(), query,
+		options.Find().SetLimit(int64(limit)).SetSort(bson.D{{Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get rejected lines")
+	}
+	if err = cursor.All(context.TODO(), &mrecs); err != nil {
+		return nil, errors.Wrap(err, "can't decode rejected lines")
+	}
+
+	res := make([]core.Rejected, len(mrecs))
+	for i, r := range mrecs {
+		res[len(mrecs)-1-i] = core.Rejected{ID: r.ID.Hex(), Line: r.Line, Sender: r.Sender, ReceivedTS: r.ReceivedTS,
+			Error: r.Error, Replayed: r.Replayed}
+	}
+	return res, nil
+}
+
+// MarkReplayed sets replayed flag for rejected lines
+func (m *Mongo) MarkReplayed(ids []string) error {
+	bids := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		bid, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return errors.Wrapf(err, "bad rejected id %q", id)
+		}
+		bids = append(bids, bid)
+	}
+	_, err := m.rejectedColl().UpdateMany(context.TODO(), bson.M{"_id": bson.M{"$in": bids}},
+		bson.M{"$set": bson.M{"replayed": true}})
+	return errors.Wrap(err, "can't mark replayed lines")
+}
+
+func (m *Mongo) rejectedColl() *mdrv.Collection {
+	return m.Database(m.DBName).Collection(m.Collection + "_rejected")
+}
+
 func (m *Mongo) makeQuery(req core.Request) (b bson.M) {
 
 	fromTS := time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)
@@ -178,6 +276,26 @@ func (m *Mongo) makeQuery(req core.Request) (b bson.M) {
 		query["host"] = bson.M{"$in": m.convertListWithRegex(req.Hosts)}
 	}
 
+	if len(req.ExcludeHosts) > 0 {
+		if val, found := query["host"]; found {
+			val.(bson.M)["$nin"] = m.convertListWithRegex(req.ExcludeHosts)
+		} else {
+			query["host"] = bson.M{"$nin": m.convertListWithRegex(req.ExcludeHosts)}
+		}
+	}
+
+	if len(req.Groups) > 0 {
+		query["group"] = bson.M{"$in": m.convertListWithRegex(req.Groups)}
+	}
+
+	if len(req.ExcludeGroups) > 0 { // records without group don't have the field and kept by $nin
+		if val, found := query["group"]; found {
+			val.(bson.M)["$nin"] = m.convertListWithRegex(req.ExcludeGroups)
+		} else {
+			query["group"] = bson.M{"$nin": m.convertListWithRegex(req.ExcludeGroups)}
+		}
+	}
+
 	if len(req.Excludes) > 0 {
 		if val, found := query["container"]; found {
 			val.(bson.M)["$nin"] = m.convertListWithRegex(req.Excludes)
@@ -186,9 +304,77 @@ func (m *Mongo) makeQuery(req core.Request) (b bson.M) {
 		}
 	}
 
+	if len(req.Grep) > 0 {
+		query["msg"] = bson.M{"$in": m.grepRegexes(req.Grep)}
+	}
+
+	if len(req.UnGrep) > 0 {
+		if val, found := query["msg"]; found {
+			val.(bson.M)["$nin"] = m.grepRegexes(req.UnGrep)
+		} else {
+			query["msg"] = bson.M{"$nin": m.grepRegexes(req.UnGrep)}
+		}
+	}
+
+	if req.Search != "" {
+		query["$text"] = bson.M{"$search": req.Search}
+	}
+
+	conds := m.fieldsQuery(req.Fields)
+	if sev, err := core.ParseSeverity(req.MinSeverity); req.MinSeverity != "" && err == nil {
+		sevCond := bson.M{"severity": bson.M{"$lte": sev}}
+		if sev >= core.SevNotice { // records stored before severity was added have no field and read back as notice
+			sevCond = bson.M{"$or": bson.A{sevCond, bson.M{"severity": bson.M{"$exists": false}}}}
+		}
+		conds = append(conds, sevCond)
+	}
+	if len(req.ScopeHosts) > 0 { // in $and, host may have own $in already
+		conds = append(conds, bson.M{"host": bson.M{"$in": m.convertListWithRegex(req.ScopeHosts)}})
+	}
+	if len(req.ScopeContainers) > 0 {
+		conds = append(conds, bson.M{"container": bson.M{"$in": m.convertListWithRegex(req.ScopeContainers)}})
+	}
+	if len(conds) > 0 {
+		query["$and"] = conds
+	}
+
 	return query
 }
 
+// grepRegexes makes list of regexes for grep strings, bad regexes ignored
+func (m *Mongo) grepRegexes(elems []string) []any {
+	var result []any
+	for _, elem := range elems {
+		if re, err := core.GrepPattern(elem); err == nil {
+			result = append(result, primitive.Regex{Pattern: re})
+		}
+	}
+	return result
+}
+
+// fieldsQuery makes list of conditions for fields predicates, bad predicates ignored
+func (m *Mongo) fieldsQuery(fields []string) (res []bson.M) {
+	for _, f := range fields {
+		ff, err := core.ParseFieldFilter(f)
+		if err != nil {
+			continue
+		}
+		var val any = ff.Value
+		if ff.IsRegex() {
+			val = primitive.Regex{Pattern: ff.Value[1 : len(ff.Value)-1]}
+		}
+		switch {
+		case ff.Negate && ff.IsRegex():
+			res = append(res, bson.M{"fields." + ff.Key: bson.M{"$not": val}})
+		case ff.Negate:
+			res = append(res, bson.M{"fields." + ff.Key: bson.M{"$ne": val}})
+		default:
+			res = append(res, bson.M{"fields." + ff.Key: val})
+		}
+	}
+	return res
+}
+
 func (m *Mongo) convertListWithRegex(elems []string) []any {
 	var result []any
 	for _, elem := range elems {
@@ -224,6 +410,10 @@ func (m *Mongo) init(collection string) error {
 		{Keys: bson.D{{Key: "host", Value: 1}, {Key: "container", Value: 1}, {Key: "ts", Value: 1}}},
 		{Keys: bson.D{{Key: "ts", Value: 1}, {Key: "host", Value: 1}, {Key: "container", Value: 1}}},
 		{Keys: bson.D{{Key: "container", Value: 1}, {Key: "ts", Value: 1}}},
+		{Keys: bson.D{{Key: "severity", Value: 1}, {Key: "ts", Value: 1}}},
+		{Keys: bson.D{{Key: "group", Value: 1}, {Key: "ts", Value: 1}}},
+		{Keys: bson.D{{Key: "fields.$**", Value: 1}}},
+		{Keys: bson.D{{Key: "msg", Value: "text"}}, Options: options.Index().SetDefaultLanguage("none")},
 	}
 
 	err := m.Client.Database(m.DBName).CreateCollection(context.Background(), m.Collection,
@@ -239,17 +429,29 @@ func (m *Mongo) init(collection string) error {
 		return errors.Wrap(err, "create indexes")
 	}
 
+	err = m.Client.Database(m.DBName).CreateCollection(context.Background(), m.Collection+"_rejected",
+		options.CreateCollection().SetCapped(true).SetSizeInBytes(maxRejectedSize).SetMaxDocuments(int64(m.MaxRejected)))
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return errors.Wrapf(err, "initilize collection %s_rejected", collection)
+	}
+
 	return nil
 }
 
 func (m *Mongo) makeMongoEntry(entry core.LogEntry) mongoLogEntry {
 	res := mongoLogEntry{
-		ID:        m.getBid(entry.ID),
-		Host:      entry.Host,
-		Container: entry.Container,
-		Msg:       entry.Msg,
-		TS:        entry.TS,
-		Pid:       entry.Pid,
+		ID:         m.getBid(entry.ID),
+		Host:       entry.Host,
+		Sender:     entry.Sender,
+		Container:  entry.Container,
+		Group:      entry.Group,
+		Msg:        entry.Msg,
+		TS:         entry.TS,
+		Pid:        entry.Pid,
+		StructData: entry.StructData,
+		Facility:   entry.Facility,
+		Severity:   &entry.Severity,
+		Fields:     entry.Fields,
 	}
 	if entry.ID == "" {
 		res.ID = primitive.NewObjectID()
@@ -259,12 +461,21 @@ func (m *Mongo) makeMongoEntry(entry core.LogEntry) mongoLogEntry {
 
 func (m *Mongo) makeLogEntry(entry mongoLogEntry) core.LogEntry {
 	r := core.LogEntry{
-		ID:        entry.ID.Hex(),
-		Host:      entry.Host,
-		Container: entry.Container,
-		Msg:       entry.Msg,
-		TS:        entry.TS,
-		Pid:       entry.Pid,
+		ID:         entry.ID.Hex(),
+		Host:       entry.Host,
+		Sender:     entry.Sender,
+		Container:  entry.Container,
+		Group:      entry.Group,
+		Msg:        entry.Msg,
+		TS:         entry.TS,
+		Pid:        entry.Pid,
+		StructData: entry.StructData,
+		Facility:   entry.Facility,
+		Severity:   core.SevNotice,
+		Fields:     entry.Fields,
+	}
+	if entry.Severity != nil {
+		r.Severity = *entry.Severity
 	}
 	r.CreatedTS = entry.ID.Timestamp()
 	return r
@@ -8,8 +8,10 @@ import (
 	"github.com/go-pkgz/mongo/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 
 	"github.com/umputun/dkll/app/core"
+	"github.com/umputun/dkll/app/server/storetest"
 )
 
 func TestMongo_LastPublished(t *testing.T) {
@@ -113,6 +115,244 @@ func TestMongo_Find(t *testing.T) {
 	assert.Equal(t, "hh1", recs[0].Host)
 	assert.Equal(t, "hh22", recs[1].Host)
 	assert.Equal(t, "hh3456", recs[2].Host)
+
+	recs, err = m.Find(core.Request{Hosts: []string{"/hh/"}, ExcludeHosts: []string{"/^hh3/"}})
+	assert.NoError(t, err, "regex hh hosts without hh3*")
+	assert.Equal(t, 2, len(recs))
+	assert.Equal(t, "hh1", recs[0].Host)
+	assert.Equal(t, "hh22", recs[1].Host)
+
+	recs, err = m.Find(core.Request{ExcludeHosts: []string{"/hh/"}})
+	assert.NoError(t, err, "exclude hh hosts")
+	for _, r := range recs {
+		assert.NotContains(t, r.Host, "hh")
+	}
+}
+
+func TestMongo_StructData(t *testing.T) {
+	mg, coll, teardown := mongo.MakeTestConnection(t)
+	defer teardown()
+	m, err := NewMongo(mg, MongoParams{DBName: "test", Collection: coll.Name()})
+	require.NoError(t, err)
+
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	sd := map[string]map[string]string{"exampleSDID@32473": {"iut": "3", "eventSource": "Application"}, "empty@1": {}}
+	recs := []core.LogEntry{
+		{ID: "5ce8718aef1d7346a5443a1f", Host: "h1", Container: "c1", Msg: "msg1", TS: ts, StructData: sd},
+		{ID: "5ce8718aef1d7346a5443a2f", Host: "h1", Container: "c2", Msg: "msg2", TS: ts.Add(time.Second)},
+	}
+	require.NoError(t, m.Publish(recs))
+
+	res, err := m.Find(core.Request{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, sd, res[0].StructData, "structured data stored and restored")
+	assert.Nil(t, res[1].StructData, "no structured data")
+}
+
+func TestMongo_FindSeverity(t *testing.T) {
+	mg, coll, teardown := mongo.MakeTestConnection(t)
+	defer teardown()
+	m, err := NewMongo(mg, MongoParams{DBName: "test", Collection: coll.Name()})
+	require.NoError(t, err)
+
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	recs := []core.LogEntry{
+		{ID: "5ce8718aef1d7346a5443a1f", Host: "h1", Container: "c1", Msg: "msg1", TS: ts, Facility: 3, Severity: core.SevInfo},
+		{ID: "5ce8718aef1d7346a5443a2f", Host: "h1", Container: "c1", Msg: "msg2", TS: ts.Add(1 * time.Second), Facility: 3, Severity: core.SevErr},
+		{ID: "5ce8718aef1d7346a5443a3f", Host: "h1", Container: "c1", Msg: "msg3", TS: ts.Add(2 * time.Second), Facility: 3, Severity: core.SevWarning},
+		{ID: "5ce8718aef1d7346a5443a4f", Host: "h1", Container: "c1", Msg: "msg4", TS: ts.Add(3 * time.Second), Facility: 3, Severity: core.SevCrit},
+	}
+	require.NoError(t, m.Publish(recs))
+
+	res, err := m.Find(core.Request{})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(res))
+	assert.Equal(t, core.SevInfo, res[0].Severity)
+	assert.Equal(t, core.SevErr, res[1].Severity)
+	assert.Equal(t, 3, res[1].Facility)
+
+	res, err = m.Find(core.Request{MinSeverity: "err"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res), "err and crit")
+	assert.Equal(t, "msg2", res[0].Msg)
+	assert.Equal(t, "msg4", res[1].Msg)
+
+	res, err = m.Find(core.Request{MinSeverity: "4"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "warning and above")
+
+	// record stored before severity was added, read back as notice
+	_, err = coll.InsertOne(context.Background(), bson.M{"host": "h1", "container": "c1", "msg": "msg5", "ts": ts.Add(4 * time.Second)})
+	require.NoError(t, err)
+	res, err = m.Find(core.Request{MinSeverity: "notice"})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(res), "all but info")
+	assert.Equal(t, "msg5", res[3].Msg)
+	assert.Equal(t, core.SevNotice, res[3].Severity)
+	res, err = m.Find(core.Request{MinSeverity: "warning"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "no record without severity")
+
+	_, err = m.Find(core.Request{MinSeverity: "bad"})
+	assert.Error(t, err)
+}
+
+func TestMongo_FindGroups(t *testing.T) {
+	mg, coll, teardown := mongo.MakeTestConnection(t)
+	defer teardown()
+	m, err := NewMongo(mg, MongoParams{DBName: "test", Collection: coll.Name()})
+	require.NoError(t, err)
+
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	recs := []core.LogEntry{
+		{ID: "5ce8718aef1d7346a5443a1f", Host: "h1", Group: "db", Container: "mongo", Msg: "msg1", TS: ts},
+		{ID: "5ce8718aef1d7346a5443a2f", Host: "h1", Group: "app", Container: "web", Msg: "msg2", TS: ts.Add(1 * time.Second)},
+		{ID: "5ce8718aef1d7346a5443a3f", Host: "h1", Container: "nginx", Msg: "msg3", TS: ts.Add(2 * time.Second)},
+		{ID: "5ce8718aef1d7346a5443a4f", Host: "h1", Group: "app-ext", Container: "api", Msg: "msg4", TS: ts.Add(3 * time.Second)},
+	}
+	require.NoError(t, m.Publish(recs))
+
+	res, err := m.Find(core.Request{})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(res))
+	assert.Equal(t, "db", res[0].Group)
+	assert.Equal(t, "", res[2].Group)
+
+	res, err = m.Find(core.Request{Groups: []string{"db"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "mongo", res[0].Container)
+
+	res, err = m.Find(core.Request{Groups: []string{"/^app/"}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "web", res[0].Container)
+	assert.Equal(t, "api", res[1].Container)
+}
+
+func TestMongo_FindFields(t *testing.T) {
+	mg, coll, teardown := mongo.MakeTestConnection(t)
+	defer teardown()
+	m, err := NewMongo(mg, MongoParams{DBName: "test", Collection: coll.Name()})
+	require.NoError(t, err)
+
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	recs := []core.LogEntry{
+		{ID: "5ce8718aef1d7346a5443a1f", Host: "h1", Container: "c1", Msg: "msg1", TS: ts,
+			Fields: map[string]string{"level": "error", "user_id": "42"}},
+		{ID: "5ce8718aef1d7346a5443a2f", Host: "h1", Container: "c1", Msg: "msg2", TS: ts.Add(1 * time.Second),
+			Fields: map[string]string{"level": "info", "user_id": "42"}},
+		{ID: "5ce8718aef1d7346a5443a3f", Host: "h1", Container: "c1", Msg: "msg3", TS: ts.Add(2 * time.Second)},
+		{ID: "5ce8718aef1d7346a5443a4f", Host: "h1", Container: "c1", Msg: "msg4", TS: ts.Add(3 * time.Second),
+			Fields: map[string]string{"level": "err", "user_id": "7"}},
+	}
+	require.NoError(t, m.Publish(recs))
+
+	res, err := m.Find(core.Request{})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(res))
+	assert.Equal(t, map[string]string{"level": "error", "user_id": "42"}, res[0].Fields)
+	assert.Nil(t, res[2].Fields)
+
+	res, err = m.Find(core.Request{Fields: []string{"level=error"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "msg1", res[0].Msg)
+
+	res, err = m.Find(core.Request{Fields: []string{"level=/^err/", "user_id=7"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "msg4", res[0].Msg)
+
+	res, err = m.Find(core.Request{Fields: []string{"user_id!=42"}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res), "no user_id field and user_id=7")
+	assert.Equal(t, "msg3", res[0].Msg)
+	assert.Equal(t, "msg4", res[1].Msg)
+
+	res, err = m.Find(core.Request{Fields: []string{"level!=/^err/"}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "msg2", res[0].Msg)
+
+	_, err = m.Find(core.Request{Fields: []string{"level"}})
+	assert.Error(t, err)
+}
+
+func TestMongo_FindGrep(t *testing.T) {
+	mg, coll, teardown := mongo.MakeTestConnection(t)
+	defer teardown()
+	m, err := NewMongo(mg, MongoParams{DBName: "test", Collection: coll.Name()})
+	require.NoError(t, err)
+
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	recs := []core.LogEntry{
+		{ID: "5ce8718aef1d7346a5443a1f", Host: "h1", Container: "c1", Msg: "GET /api req-123 200", TS: ts},
+		{ID: "5ce8718aef1d7346a5443a2f", Host: "h1", Container: "c1", Msg: "GET /health 200", TS: ts.Add(1 * time.Second)},
+		{ID: "5ce8718aef1d7346a5443a3f", Host: "h1", Container: "c1", Msg: "error: timeout for req-123", TS: ts.Add(2 * time.Second)},
+		{ID: "5ce8718aef1d7346a5443a4f", Host: "h1", Container: "c1", Msg: "POST /api [a.b] 500", TS: ts.Add(3 * time.Second)},
+	}
+	require.NoError(t, m.Publish(recs))
+
+	res, err := m.Find(core.Request{Grep: []string{"req-123"}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "GET /api req-123 200", res[0].Msg)
+
+	res, err = m.Find(core.Request{Grep: []string{"[a.b]"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "substring with regex chars")
+	assert.Equal(t, "POST /api [a.b] 500", res[0].Msg)
+
+	res, err = m.Find(core.Request{Grep: []string{"/^GET .* 200$/"}, UnGrep: []string{"health"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "GET /api req-123 200", res[0].Msg)
+
+	res, err = m.Find(core.Request{UnGrep: []string{"/^GET/", "error"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "POST /api [a.b] 500", res[0].Msg)
+
+	res, err = m.Find(core.Request{Search: "timeout"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "error: timeout for req-123", res[0].Msg)
+
+	_, err = m.Find(core.Request{Grep: []string{"/[bad/"}})
+	assert.Error(t, err)
+}
+
+func TestMongo_Rejected(t *testing.T) {
+	mg, coll, teardown := mongo.MakeTestConnection(t)
+	defer teardown()
+	m, err := NewMongo(mg, MongoParams{DBName: "test", Collection: coll.Name()})
+	require.NoError(t, err)
+	defer m.rejectedColl().Drop(context.Background()) // nolint
+
+	ts := time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+	for i, line := range []string{"bad1", "bad2", "bad3"} {
+		require.NoError(t, m.Reject(core.Rejected{Line: line, Sender: "10.0.0.1:514", Error: "err",
+			ReceivedTS: ts.Add(time.Duration(i) * time.Second)}))
+	}
+
+	recs, err := m.Rejected(2, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, "bad2", recs[0].Line, "latest, from old to new")
+	assert.Equal(t, "bad3", recs[1].Line)
+	assert.Equal(t, "10.0.0.1:514", recs[1].Sender)
+	assert.Equal(t, ts.Add(2*time.Second), recs[1].ReceivedTS.In(time.Local))
+
+	require.NoError(t, m.MarkReplayed([]string{recs[1].ID}))
+	recs, err = m.Rejected(10, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(recs))
+	assert.Equal(t, "bad1", recs[0].Line)
+	assert.Equal(t, "bad2", recs[1].Line)
+
+	assert.Error(t, m.MarkReplayed([]string{"bad-id"}))
 }
 
 func TestMongo_FindEmpty(t *testing.T) {
@@ -138,3 +378,13 @@ func TestMongo_Init(t *testing.T) {
 	_, err = NewMongo(mg, MongoParams{DBName: "test", Collection: "test_msgs"})
 	require.NoError(t, err)
 }
+
+func TestMongo_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		mg, coll, teardown := mongo.MakeTestConnection(t)
+		t.Cleanup(teardown)
+		m, err := NewMongo(mg, MongoParams{DBName: "test", Collection: coll.Name()})
+		require.NoError(t, err)
+		return m
+	})
+}
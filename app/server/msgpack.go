@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// msgpackDecoder is a minimal msgpack (https://github.com/msgpack/msgpack/blob/master/spec.md) stream decoder,
+// enough for fluent forward protocol. Values decoded to nil, bool, int64, uint64, float64, string, []byte, []any,
+// map[string]any and msgpackExt. Size of a single value limited by maxSize.
+type msgpackDecoder struct {
+	rd      *bufio.Reader
+	maxSize int
+	read    int // bytes read for the current value
+}
+
+// msgpackExt is extension type value, i.e. fluent's EventTime
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+const msgpackMaxDepth = 100
+
+// Decode reads the next value from the stream
+func (d *msgpackDecoder) Decode() (any, error) {
+	d.read = 0
+	return d.value(0)
+}
+
+func (d *msgpackDecoder) value(depth int) (any, error) {
+	if depth > msgpackMaxDepth {
+		return nil, errors.Errorf("msgpack nesting is deeper than %d", msgpackMaxDepth)
+	}
+	c, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f: // positive fixint
+		return int64(c), nil
+	case c >= 0xe0: // negative fixint
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return d.mapOf(int(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return d.arrayOf(int(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		return d.str(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8, 16, 32
+		n, err := d.readUint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(int(n))
+	case 0xc7, 0xc8, 0xc9: // ext 8, 16, 32
+		n, err := d.readUint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(int(n))
+	case 0xca:
+		n, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.readUint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce:
+		n, err := d.readUint(1 << (c - 0xcc))
+		return int64(n), err
+	case 0xcf:
+		n, err := d.readUint(8)
+		if n <= math.MaxInt64 {
+			return int64(n), err
+		}
+		return n, err
+	case 0xd0:
+		n, err := d.readUint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.readUint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.readUint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.readUint(8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1, 2, 4, 8, 16
+		return d.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb: // str 8, 16, 32
+		n, err := d.readUint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xdc, 0xdd: // array 16, 32
+		n, err := d.readUint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(int(n), depth)
+	case 0xde, 0xdf: // map 16, 32
+		n, err := d.readUint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(int(n), depth)
+	}
+	return nil, errors.Errorf("invalid msgpack type 0x%02x", c)
+}
+
+func (d *msgpackDecoder) arrayOf(n, depth int) ([]any, error) {
+	if err := d.check(n); err != nil { // each element is one byte at least
+		return nil, err
+	}
+	res := make([]any, 0, min(n, 1024))
+	for range n {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+// mapOf reads map with n pairs, non-string keys converted to strings
+func (d *msgpackDecoder) mapOf(n, depth int) (map[string]any, error) {
+	if err := d.check(2 * n); err != nil {
+		return nil, err
+	}
+	res := make(map[string]any, min(n, 1024))
+	for range n {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := msgpackString(k)
+		if !ok {
+			key = fmt.Sprintf("%v", k)
+		}
+		res[key] = v
+	}
+	return res, nil
+}
+
+func (d *msgpackDecoder) str(n int) (string, error) {
+	b, err := d.readBytes(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) ext(n int) (msgpackExt, error) {
+	t, err := d.readByte()
+	if err != nil {
+		return msgpackExt{}, err
+	}
+	data, err := d.readBytes(n)
+	return msgpackExt{Type: int8(t), Data: data}, err
+}
+
+func (d *msgpackDecoder) readBytes(n int) ([]byte, error) {
+	if err := d.check(n); err != nil {
+		return nil, err
+	}
+	res := make([]byte, n)
+	if _, err := io.ReadFull(d.rd, res); err != nil {
+		return nil, noEOF(err)
+	}
+	d.read += n
+	return res, nil
+}
+
+// readUint reads big endian unsigned integer of n (1, 2, 4 or 8) bytes
+func (d *msgpackDecoder) readUint(n int) (uint64, error) {
+	b, err := d.readBytes(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *msgpackDecoder) readByte() (byte, error) {
+	if err := d.check(1); err != nil {
+		return 0, err
+	}
+	c, err := d.rd.ReadByte()
+	if err != nil {
+		if d.read > 0 {
+			return 0, noEOF(err)
+		}
+		return 0, err // io.EOF between values is a normal end of stream
+	}
+	d.read++
+	return c, nil
+}
+
+// check if n more bytes fit into maxSize
+func (d *msgpackDecoder) check(n int) error {
+	if n < 0 || d.read+n > d.maxSize {
+		return errors.Errorf("msgpack value is more than %d", d.maxSize)
+	}
+	return nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// msgpackString returns string or bin value as string
+func msgpackString(v any) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case []byte:
+		return string(s), true
+	}
+	return "", false
+}
+
+// msgpackAppendString appends string value to buf
+func msgpackAppendString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n)) //nolint:gosec // string is shorter than 4G
+	}
+	return append(buf, s...)
+}
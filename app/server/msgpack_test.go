@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgpackDecoder(t *testing.T) {
+	tbl := []struct {
+		inp []byte
+		res any
+		err string
+	}{
+		{[]byte{0x05}, int64(5), ""},
+		{[]byte{0xff}, int64(-1), ""},
+		{[]byte{0xc0}, nil, ""},
+		{[]byte{0xc3}, true, ""},
+		{[]byte{0xcd, 0x01, 0x00}, int64(256), ""},
+		{[]byte{0xd1, 0xff, 0x00}, int64(-256), ""},
+		{[]byte{0xce, 0x5c, 0xe8, 0x5c, 0x46}, int64(1558731846), ""},
+		{[]byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(math.MaxUint64), ""},
+		{[]byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, 1.5, ""},
+		{[]byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5, ""},
+		{[]byte{0xa3, 'a', 'b', 'c'}, "abc", ""},
+		{[]byte{0xd9, 0x03, 'a', 'b', 'c'}, "abc", ""},
+		{[]byte{0xc4, 0x02, 'a', 'b'}, []byte("ab"), ""},
+		{[]byte{0x92, 0x01, 0xa1, 'x'}, []any{int64(1), "x"}, ""},
+		{[]byte{0xdc, 0x00, 0x01, 0xc2}, []any{false}, ""},
+		{[]byte{0x82, 0xa1, 'k', 0x01, 0x02, 0xa1, 'v'}, map[string]any{"k": int64(1), "2": "v"}, ""},
+		{[]byte{0xd7, 0x00, 0x5c, 0xe8, 0x58, 0x46, 0, 0, 0, 1}, msgpackExt{Type: 0, Data: []byte{0x5c, 0xe8, 0x58, 0x46, 0, 0, 0, 1}}, ""},
+		{[]byte{0xc7, 0x01, 0x05, 0x01}, msgpackExt{Type: 5, Data: []byte{1}}, ""},
+		{[]byte{0xc1}, nil, "invalid msgpack type 0xc1"},
+		{[]byte{0xa3, 'a'}, nil, "unexpected EOF"},
+		{[]byte{0x92, 0x01}, nil, "unexpected EOF"},
+		{[]byte{0xdb, 0xff, 0xff, 0xff, 0xff}, nil, "msgpack value is more than 100"},
+		{[]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, nil, "msgpack value is more than 100"},
+		{[]byte{}, nil, "EOF"},
+	}
+	for i, tt := range tbl {
+		d := &msgpackDecoder{rd: bufio.NewReader(bytes.NewReader(tt.inp)), maxSize: 100}
+		res, err := d.Decode()
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.res, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestMsgpackDecoder_Stream(t *testing.T) {
+	inp := append(msgpackEncode(t, []any{"tag", int64(1), map[string]any{"log": "msg1"}}), msgpackEncode(t, "next")...)
+	d := &msgpackDecoder{rd: bufio.NewReader(bytes.NewReader(inp)), maxSize: 100}
+	v, err := d.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, []any{"tag", int64(1), map[string]any{"log": "msg1"}}, v)
+	v, err = d.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, "next", v)
+	_, err = d.Decode()
+	assert.Equal(t, io.EOF, err)
+
+	deep := bytes.Repeat([]byte{0x91}, msgpackMaxDepth+2)
+	d = &msgpackDecoder{rd: bufio.NewReader(bytes.NewReader(deep)), maxSize: 1000}
+	_, err = d.Decode()
+	assert.EqualError(t, err, "msgpack nesting is deeper than 100")
+}
+
+func TestMsgpackAppendString(t *testing.T) {
+	for i, n := range []int{0, 31, 32, 255, 256, 65535, 65536} {
+		s := strings.Repeat("x", n)
+		d := &msgpackDecoder{rd: bufio.NewReader(bytes.NewReader(msgpackAppendString(nil, s))), maxSize: 100000}
+		res, err := d.Decode()
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, s, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+// msgpackEncode encodes values of types produced by msgpackDecoder, map keys sorted
+func msgpackEncode(t *testing.T, v any) []byte {
+	switch val := v.(type) {
+	case nil:
+		return []byte{0xc0}
+	case bool:
+		if val {
+			return []byte{0xc3}
+		}
+		return []byte{0xc2}
+	case int:
+		return msgpackEncode(t, int64(val))
+	case int64:
+		return binary.BigEndian.AppendUint64([]byte{0xd3}, uint64(val))
+	case float64:
+		return binary.BigEndian.AppendUint64([]byte{0xcb}, math.Float64bits(val))
+	case string:
+		return msgpackAppendString(nil, val)
+	case []byte:
+		return append(binary.BigEndian.AppendUint32([]byte{0xc6}, uint32(len(val))), val...)
+	case msgpackExt:
+		return append(binary.BigEndian.AppendUint32([]byte{0xc9}, uint32(len(val.Data))), append([]byte{byte(val.Type)}, val.Data...)...)
+	case []any:
+		res := binary.BigEndian.AppendUint32([]byte{0xdd}, uint32(len(val)))
+		for _, e := range val {
+			res = append(res, msgpackEncode(t, e)...)
+		}
+		return res
+	case map[string]any:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		res := binary.BigEndian.AppendUint32([]byte{0xdf}, uint32(len(val)))
+		for _, k := range keys {
+			res = append(res, msgpackAppendString(nil, k)...)
+			res = append(res, msgpackEncode(t, val[k])...)
+		}
+		return res
+	}
+	require.Fail(t, "unsupported type", "%T", v)
+	return nil
+}
@@ -2,15 +2,18 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
 	"github.com/go-pkgz/rest"
 	"github.com/go-pkgz/rest/logger"
 	"github.com/go-pkgz/routegroup"
+	"github.com/pkg/errors"
 
 	"github.com/umputun/dkll/app/core"
 )
@@ -22,6 +25,14 @@ type RestServer struct {
 	Limit          int // request limit, i.e. max number of records any single Find can return
 	Version        string
 	StreamDuration time.Duration
+	Rejects        RejectService // optional, enables /v1/rejected endpoints
+	Stats          StatsService  // optional, enables /v1/stats endpoint
+	Hub            *Hub          // optional, streams entries pushed by forwarder instead of polling DataService, enables /v1/events and /v1/ws
+	Heartbeat      time.Duration // interval of /v1/events heartbeats and /v1/ws pings, 15s by default
+	Auth           *Auth         // optional, requires token or user for /v1 endpoints
+	TLS            *tls.Config   // optional, serves https
+	Ingest         IngestService // optional, enables POST /v1/ingest
+	IngestMaxSize  int64         // max body size of /v1/ingest, compressed and decompressed, 10M by default
 }
 
 // DataService is accessor to store
@@ -30,9 +41,20 @@ type DataService interface {
 	LastPublished() (entry core.LogEntry, err error)
 }
 
+// RejectService provides access to lines failed to parse and replays them
+type RejectService interface {
+	Rejected(limit int) ([]core.Rejected, error)
+	Replay() (replayed, failed int, err error)
+}
+
+// StatsService provides ingest pipeline stats
+type StatsService interface {
+	Snapshot() StatsSnapshot
+}
+
 // Run the lister and request's router
 func (s *RestServer) Run(ctx context.Context) error {
-	log.Printf("[INFO] activate rest server on :%d", s.Port)
+	log.Printf("[INFO] activate rest server on :%d, tls %v", s.Port, s.TLS != nil)
 
 	if s.StreamDuration == 0 {
 		s.StreamDuration = 250 * time.Millisecond // default duration for streaming mode. Defines how often it will repeat DataService.Find
@@ -53,6 +75,10 @@ func (s *RestServer) Run(ctx context.Context) error {
 		}
 	}()
 
+	if s.TLS != nil {
+		srv.TLSConfig = s.TLS
+		return srv.ListenAndServeTLS("", "") // certificate from TLS config
+	}
 	return srv.ListenAndServe()
 }
 
@@ -61,19 +87,65 @@ func (s *RestServer) router() http.Handler {
 	router.Use(rest.Recoverer(log.Default()))
 	router.Use(rest.Throttle(100))
 	router.Use(rest.AppInfo("dkll", "umputun", s.Version))
-	router.Use(rest.Ping, rest.SizeLimit(1024))
-	router.Use(logger.New(logger.Log(log.Default()), logger.WithBody, logger.Prefix("[DEBUG]")).Handler)
+	router.Use(rest.Ping)
 
 	router.Mount("/v1").Route(func(r *routegroup.Bundle) {
+		r.Use(rest.SizeLimit(1024))
+		r.Use(logger.New(logger.Log(log.Default()), logger.WithBody, logger.Prefix("[DEBUG]")).Handler)
+		if s.Auth != nil {
+			r.Use(s.Auth.Middleware)
+		}
 		r.HandleFunc("POST /find", s.findCtrl)
-		r.HandleFunc("POST /stream", s.streamCtrl)
 		r.HandleFunc("GET /last", s.lastCtrl)
+		if s.Rejects != nil {
+			r.HandleFunc("GET /rejected", unscoped(s.rejectedCtrl))
+			r.HandleFunc("POST /rejected/replay", unscoped(s.replayCtrl))
+		}
+		if s.Stats != nil {
+			r.HandleFunc("GET /stats", unscoped(s.statsCtrl))
+		}
 	})
+
+	// long-lived streams without request logger, it hides write deadline control of response writer
+	router.Mount("/v1").Route(func(r *routegroup.Bundle) {
+		r.Use(rest.SizeLimit(1024))
+		if s.Auth != nil {
+			r.Use(s.Auth.Middleware)
+		}
+		r.HandleFunc("POST /stream", s.streamCtrl)
+		if s.Hub != nil {
+			r.HandleFunc("GET /events", s.eventsCtrl)
+			r.HandleFunc("GET /ws", s.wsCtrl)
+		}
+	})
+
+	// bulk ingest with its own body limit, request logged without body
+	if s.Ingest != nil {
+		router.Mount("/v1").Route(func(r *routegroup.Bundle) {
+			r.Use(logger.New(logger.Log(log.Default()), logger.Prefix("[DEBUG]")).Handler)
+			if s.Auth != nil {
+				r.Use(s.Auth.Middleware)
+			}
+			r.HandleFunc("POST /ingest", s.ingestCtrl)
+		})
+	}
 	return router
 }
 
-// POST /v1/find, body is Request.  Returns list of LogEntry
+// unscoped allows handler for callers without scope only, rejected lines and stats are not limited by hosts
+func unscoped(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cred, ok := credential(r); ok && cred.Scoped() {
+			rest.SendErrorJSON(w, r, log.Default(), http.StatusForbidden, errors.New("forbidden"), "not allowed for scoped "+cred.Name)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// POST /v1/find?q=query, body is Request.  Returns list of LogEntry
 // containers,hosts and excludes lists support regexp in "//", i.e. /regex/
+// optional query (q param or field) parsed by core.ParseQuery and added to request
 func (s *RestServer) findCtrl(w http.ResponseWriter, r *http.Request) {
 	req := core.Request{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -81,6 +153,12 @@ func (s *RestServer) findCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	req, err := s.applyQuery(r, req)
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, err.Error()) // pass position to the client
+		return
+	}
+
 	if req.Limit == 0 || req.Limit > s.Limit {
 		req.Limit = s.Limit
 	}
@@ -94,7 +172,7 @@ func (s *RestServer) findCtrl(w http.ResponseWriter, r *http.Request) {
 	rest.RenderJSON(w, recs)
 }
 
-// POST /v1/stream?timeout=30s, body is Request.  Stream list of LogEntry, breaks on timeout
+// POST /v1/stream?timeout=30s&q=query, body is Request.  Stream list of LogEntry, breaks on timeout
 // containers,hosts and excludes lists support regexp in "//", i.e. /regex/
 func (s *RestServer) streamCtrl(w http.ResponseWriter, r *http.Request) {
 	req := core.Request{}
@@ -109,10 +187,21 @@ func (s *RestServer) streamCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	req, err := s.applyQuery(r, req)
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, err.Error()) // pass position to the client
+		return
+	}
+
 	if req.Limit == 0 || req.Limit > s.Limit {
 		req.Limit = s.Limit
 	}
 
+	if s.Hub != nil {
+		s.pushStream(w, r, req, timeout)
+		return
+	}
+
 	st := time.Now()
 	for {
 		recs, err := s.DataService.Find(req)
@@ -144,9 +233,219 @@ func (s *RestServer) streamCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// pushStream sends records from store after req.LastID, and then entries from hub as they published.
+// Breaks on timeout inactivity.
+func (s *RestServer) pushStream(w http.ResponseWriter, r *http.Request, req core.Request, timeout time.Duration) {
+	sub, recs, err := s.startTail(req)
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, "failed to start stream")
+		return
+	}
+
+	log.Printf("[DEBUG] stream started for %+v", req)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{}) // timeout may exceed server's write timeout
+	enc := json.NewEncoder(w)
+	send := func(recs []core.LogEntry) error {
+		for _, rec := range recs {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return http.NewResponseController(w).Flush()
+	}
+	stop := func() error { return errStreamTimeout }
+	if err = s.tail(r.Context(), sub, req, recs, send, timeout, stop); err != nil && err != errStreamTimeout {
+		log.Printf("[WARN] stream terminated, %v", err)
+	}
+}
+
+var errStreamTimeout = errors.New("stream timeout")
+
+// GET /v1/events?q=query&last_id=id, Server-Sent Events with records after last_id from store, and then
+// records pushed as published. Each event is a LogEntry with its ID as event id, Last-Event-ID header resumes
+// reconnected client after the last received event. Heartbeat comment sent on inactivity.
+func (s *RestServer) eventsCtrl(w http.ResponseWriter, r *http.Request) {
+	req, err := s.applyQuery(r, core.Request{LastID: r.URL.Query().Get("last_id")})
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, err.Error()) // pass position to the client
+		return
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		req.LastID = id
+	}
+	if req.Limit == 0 || req.Limit > s.Limit {
+		req.Limit = s.Limit
+	}
+
+	sub, recs, err := s.startTail(req)
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, "failed to start events")
+		return
+	}
+
+	log.Printf("[DEBUG] events started for %+v", req)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering, i.e. nginx
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // events never end on server side
+	w.WriteHeader(http.StatusOK)
+	if err = rc.Flush(); err != nil {
+		sub.Close()
+		return
+	}
+
+	send := func(recs []core.LogEntry) error {
+		for _, rec := range recs {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if _, err = fmt.Fprintf(w, "id: %s\ndata: %s\n\n", rec.ID, data); err != nil {
+				return err
+			}
+		}
+		return rc.Flush()
+	}
+	heartbeat := func() error {
+		if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	interval := s.Heartbeat
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	if err = s.tail(r.Context(), sub, req, recs, send, interval, heartbeat); err != nil {
+		log.Printf("[DEBUG] events terminated, %v", err)
+	}
+}
+
+// startTail subscribes to hub and finds the first records after req.LastID. Subscribed before Find,
+// so nothing published in between lost.
+func (s *RestServer) startTail(req core.Request) (*Subscription, []core.LogEntry, error) {
+	sub, err := s.Hub.Subscribe(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	recs, err := s.DataService.Find(req)
+	if err != nil {
+		sub.Close()
+		return nil, nil, err
+	}
+	return sub, recs, nil
+}
+
+// tail sends recs and the rest of records after req.LastID from store, and then entries pushed by hub.
+// Records already sent skipped. Lagged subscription replaced with the new one after catch-up from store.
+// onIdle called after idle period without records. Stops on send or onIdle error, or on ctx done. Closes sub.
+func (s *RestServer) tail(ctx context.Context, sub *Subscription, req core.Request, recs []core.LogEntry,
+	send func(recs []core.LogEntry) error, idle time.Duration, onIdle func() error) (err error) {
+	defer func() { sub.Close() }()
+
+	write := func(recs []core.LogEntry) error {
+		fresh := make([]core.LogEntry, 0, len(recs))
+		for _, rec := range recs {
+			if rec.ID != "" && rec.ID <= req.LastID {
+				continue // already sent from store
+			}
+			fresh = append(fresh, rec)
+			req.LastID = rec.ID
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		return send(fresh)
+	}
+
+	// catchUp sends records from store after req.LastID, page by page
+	catchUp := func(recs []core.LogEntry) error {
+		for {
+			if err := write(recs); err != nil {
+				return err
+			}
+			if len(recs) < req.Limit {
+				return nil
+			}
+			var err error
+			if recs, err = s.DataService.Find(req); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = catchUp(recs); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+			if err = onIdle(); err != nil {
+				return err
+			}
+			timer.Reset(idle)
+		case rec, ok := <-sub.Entries():
+			if !ok { // lagged, entries missed
+				if sub, recs, err = s.startTail(req); err != nil {
+					return err
+				}
+				if err = catchUp(recs); err != nil {
+					return err
+				}
+				continue
+			}
+			batch := []core.LogEntry{rec}
+			for len(batch) < req.Limit && len(sub.Entries()) > 0 { // send all buffered at once
+				if rec, ok = <-sub.Entries(); ok {
+					batch = append(batch, rec)
+				}
+			}
+			if err = write(batch); err != nil {
+				return err
+			}
+			timer.Reset(idle)
+		}
+	}
+}
+
+// applyQuery adds query from q param or Request.Query and caller's scope to request
+func (s *RestServer) applyQuery(r *http.Request, req core.Request) (core.Request, error) {
+	if cred, ok := credential(r); ok {
+		req = cred.Apply(req)
+	}
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		q = req.Query
+	}
+	if q == "" {
+		return req, nil
+	}
+	return req.WithQuery(q)
+}
+
 // GET /v1/last
-// Returns latest published LogEntry from DataService
+// Returns latest published LogEntry from DataService, the latest one in scope for scoped caller
 func (s *RestServer) lastCtrl(w http.ResponseWriter, r *http.Request) {
+	if cred, ok := credential(r); ok && cred.Scoped() {
+		recs, err := s.DataService.Find(cred.Apply(core.Request{Limit: 1}))
+		if err != nil {
+			rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, "failed to get last published")
+			return
+		}
+		last := core.LogEntry{}
+		if len(recs) > 0 {
+			last = recs[len(recs)-1]
+		}
+		rest.RenderJSON(w, last)
+		return
+	}
 	last, err := s.DataService.LastPublished()
 	if err != nil {
 		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, "failed to get last published")
@@ -154,3 +453,35 @@ func (s *RestServer) lastCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 	rest.RenderJSON(w, last)
 }
+
+// GET /v1/rejected?max=100
+// Returns latest lines failed to parse, from old to new
+func (s *RestServer) rejectedCtrl(w http.ResponseWriter, r *http.Request) {
+	limit := s.Limit
+	if n, err := strconv.Atoi(r.URL.Query().Get("max")); err == nil && n > 0 && (n < limit || limit == 0) {
+		limit = n
+	}
+	recs, err := s.Rejects.Rejected(limit)
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusInternalServerError, err, "failed to get rejected lines")
+		return
+	}
+	rest.RenderJSON(w, recs)
+}
+
+// POST /v1/rejected/replay
+// Re-parses pending rejected lines and publishes parsed ones. Returns {"replayed": 10, "failed": 2}
+func (s *RestServer) replayCtrl(w http.ResponseWriter, r *http.Request) {
+	replayed, failed, err := s.Rejects.Replay()
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusInternalServerError, err, "failed to replay rejected lines")
+		return
+	}
+	rest.RenderJSON(w, rest.JSON{"replayed": replayed, "failed": failed})
+}
+
+// GET /v1/stats
+// Returns counters of ingest pipeline stages and queues, i.e. {"received": 100, "dropped": 0, "queues": {"syslog": {"len": 0, "cap": 10000}}}
+func (s *RestServer) statsCtrl(w http.ResponseWriter, _ *http.Request) {
+	rest.RenderJSON(w, s.Stats.Snapshot())
+}
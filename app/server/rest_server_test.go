@@ -1,12 +1,14 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -52,6 +54,39 @@ func TestRest_findCtrl(t *testing.T) {
 	assert.Equal(t, "5ce8718aef1d7346a5443a6f", recs[5].ID)
 }
 
+func TestRest_findCtrlWithQuery(t *testing.T) {
+	ds := &mockDataService{}
+	srv := RestServer{DataService: ds, Limit: 1000}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	buff := bytes.Buffer{}
+	require.NoError(t, json.NewEncoder(&buff).Encode(core.Request{Hosts: []string{"xyz"}, Query: "container=c1 limit:10"}))
+	resp, err := http.Post(ts.URL+"/v1/find", "application/json", &buff)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, core.Request{Hosts: []string{"xyz"}, Containers: []string{"c1"}, Limit: 10}, ds.getReq())
+
+	buff.Reset()
+	require.NoError(t, json.NewEncoder(&buff).Encode(core.Request{Query: "container=c1"}))
+	resp, err = http.Post(ts.URL+"/v1/find?q="+url.QueryEscape(`host!=h2 msg:"time out"`), "application/json", &buff)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, core.Request{ExcludeHosts: []string{"h2"}, Grep: []string{"time out"}, Limit: 1000}, ds.getReq(), "q param wins")
+
+	buff.Reset()
+	require.NoError(t, json.NewEncoder(&buff).Encode(core.Request{Query: "container=c1 foo"}))
+	resp, err = http.Post(ts.URL+"/v1/find", "application/json", &buff)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 400, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `query error at 16: expected =, !=, =~ or : after \"foo\"`)
+}
+
 func TestRest_findCtrlFailed(t *testing.T) {
 	ds := &mockDataService{}
 	srv := RestServer{DataService: ds}
@@ -115,13 +150,170 @@ func TestRest_streamCtrl(t *testing.T) {
 	t.Log(string(data))
 	recs := strings.Split(string(data), "\n")
 	assert.Equal(t, 6*9+1, len(recs), "got 9 chunks")
-	assert.Equal(t, `{"id":"5ce8718aef1d7346a5443a1f","host":"h1","container":"c1","pid":0,"msg":"msg1","ts":"2019-05-24T20:54:30-05:00","cts":"0001-01-01T00:00:00Z"}`,
+	assert.Equal(t, `{"id":"5ce8718aef1d7346a5443a1f","host":"h1","container":"c1","pid":0,"msg":"msg1","ts":"2019-05-24T20:54:30-05:00","cts":"0001-01-01T00:00:00Z","facility":0,"severity":0}`,
 		recs[0])
-	assert.Equal(t, `{"id":"5ce8718aef1d7346a5443a6f","host":"h2","container":"c2","pid":0,"msg":"msg6","ts":"2019-05-24T21:03:35-05:00","cts":"0001-01-01T00:00:00Z"}`,
+	assert.Equal(t, `{"id":"5ce8718aef1d7346a5443a6f","host":"h2","container":"c2","pid":0,"msg":"msg6","ts":"2019-05-24T21:03:35-05:00","cts":"0001-01-01T00:00:00Z","facility":0,"severity":0}`,
 		recs[53])
 
 }
 
+func TestRest_streamCtrlWithHub(t *testing.T) {
+	mem, hub := NewMemory(100), NewHub(1)
+	srv := RestServer{DataService: mem, Hub: hub, Limit: 100}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	recs := testRecords()
+	require.NoError(t, mem.Publish(recs[:3]))
+	publish := func(recs []core.LogEntry) {
+		require.NoError(t, mem.Publish(recs))
+		hub.Publish(recs)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { publish(recs[3:4]) })
+	time.AfterFunc(200*time.Millisecond, func() { publish(recs[4:]) }) // 2 entries lagged with buffer 1
+
+	buff := bytes.Buffer{}
+	require.NoError(t, json.NewEncoder(&buff).Encode(core.Request{LastID: recs[0].ID, Hosts: []string{"/h/"}}))
+	st := time.Now()
+	resp, err := http.Post(ts.URL+"/v1/stream?timeout=300ms", "application/json", &buff)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	msgs := []string{}
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		rec := core.LogEntry{}
+		require.NoError(t, dec.Decode(&rec))
+		msgs = append(msgs, rec.Msg)
+	}
+	assert.Equal(t, []string{"msg2", "msg3", "msg4", "msg5", "msg6"}, msgs, "no gaps and no duplicates")
+	assert.True(t, time.Since(st) >= 500*time.Millisecond, "terminated on inactivity")
+	assert.Equal(t, 0, hub.Subscribers(), "unsubscribed")
+
+	resp, err = http.Post(ts.URL+"/v1/stream", "application/json", strings.NewReader(`{"hosts":["/[bad/"]}`))
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRest_eventsCtrl(t *testing.T) {
+	mem, hub := NewMemory(100), NewHub(10)
+	srv := RestServer{DataService: mem, Hub: hub, Limit: 100, Heartbeat: 50 * time.Millisecond}
+	ts := httptest.NewUnstartedServer(srv.router())
+	ts.Config.WriteTimeout = 100 * time.Millisecond // events live longer than write timeout
+	ts.Start()
+	defer ts.Close()
+
+	recs := testRecords()
+	require.NoError(t, mem.Publish(recs[:3]))
+	time.AfterFunc(300*time.Millisecond, func() {
+		require.NoError(t, mem.Publish(recs[3:]))
+		hub.Publish(recs[3:])
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/v1/events?q="+url.QueryEscape("host=h1"), http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", recs[0].ID)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	ids, msgs, heartbeats := []string{}, []string{}, 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && len(msgs) < 3 {
+		line := scanner.Text()
+		switch {
+		case line == ": heartbeat":
+			heartbeats++
+		case strings.HasPrefix(line, "id: "):
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		case strings.HasPrefix(line, "data: "):
+			rec := core.LogEntry{}
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &rec))
+			msgs = append(msgs, rec.Msg)
+		}
+	}
+	assert.Equal(t, []string{"msg2", "msg4", "msg5"}, msgs, "after Last-Event-ID, matching host only")
+	assert.Equal(t, []string{recs[1].ID, recs[3].ID, recs[4].ID}, ids)
+	assert.True(t, heartbeats >= 3, "heartbeats %d", heartbeats)
+
+	resp, err = http.Get(ts.URL + "/v1/events?q=" + url.QueryEscape("host=~/[bad/"))
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRest_rejectedCtrl(t *testing.T) {
+	rs := &mockRejectService{recs: []core.Rejected{
+		{ID: "1", Line: "bad line 1", Sender: "10.0.0.1:514", Error: "err1"},
+		{ID: "2", Line: "bad line 2", Sender: "10.0.0.2:514", Error: "err2"},
+	}}
+	srv := RestServer{DataService: &mockDataService{}, Rejects: rs, Limit: 100}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/rejected?max=10")
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 200, resp.StatusCode)
+	recs := []core.Rejected{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&recs))
+	assert.Equal(t, rs.recs, recs)
+	assert.Equal(t, 10, rs.limit)
+
+	resp, err = http.Get(ts.URL + "/v1/rejected?max=1000")
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 100, rs.limit, "limited by server's limit")
+
+	resp, err = http.Post(ts.URL+"/v1/rejected/replay", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 200, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"replayed":2,"failed":1}`, string(body))
+
+	rs.err = errors.New("store error")
+	resp, err = http.Post(ts.URL+"/v1/rejected/replay", "application/json", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 500, resp.StatusCode)
+
+	srv = RestServer{DataService: &mockDataService{}}
+	ts2 := httptest.NewServer(srv.router())
+	defer ts2.Close()
+	resp, err = http.Get(ts2.URL + "/v1/rejected")
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 404, resp.StatusCode, "not available without reject service")
+}
+
+func TestRest_statsCtrl(t *testing.T) {
+	stats := &Stats{}
+	stats.Received.Add(5)
+	stats.Published.Add(4)
+	srv := RestServer{DataService: &mockDataService{}, Stats: stats}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/stats")
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, 200, resp.StatusCode)
+	st := StatsSnapshot{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+	assert.Equal(t, int64(5), st.Received)
+	assert.Equal(t, int64(4), st.Published)
+}
+
 type mockDataService struct {
 	req struct {
 		sync.Mutex
@@ -177,3 +369,21 @@ func (m *mockDataService) getReq() core.Request {
 	return m.req.v
 
 }
+
+type mockRejectService struct {
+	recs  []core.Rejected
+	limit int
+	err   error
+}
+
+func (m *mockRejectService) Rejected(limit int) ([]core.Rejected, error) {
+	m.limit = limit
+	return m.recs, m.err
+}
+
+func (m *mockRejectService) Replay() (replayed, failed int, err error) {
+	if m.err != nil {
+		return 0, 0, m.err
+	}
+	return 2, 1, nil
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// SenderPolicy defines what to do with messages from sender not in allow list
+type SenderPolicy string
+
+// enum of all sender policies
+const (
+	SenderReject SenderPolicy = "reject" // drop messages
+	SenderTag    SenderPolicy = "tag"    // accept messages with unknownSenderField set
+)
+
+// unknownSenderField added to fields of entries from unknown senders with tag policy
+const unknownSenderField = "unknown_sender"
+
+// ParseSenderPolicy checks policy name, empty means reject
+func ParseSenderPolicy(s string) (SenderPolicy, error) {
+	switch p := SenderPolicy(s); p {
+	case "":
+		return SenderReject, nil
+	case SenderReject, SenderTag:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown sender policy %q, expected reject or tag", s)
+	}
+}
+
+// SenderFilter checks syslog senders against allowed and denied networks. Denied sender always rejected,
+// sender not in non-empty allow list is unknown, rejected or tagged by Unknown policy.
+type SenderFilter struct {
+	Allow   []netip.Prefix
+	Deny    []netip.Prefix
+	Unknown SenderPolicy
+}
+
+// ParseCIDRs parses networks like 10.0.0.0/8, single ip means network of this ip only
+func ParseCIDRs(nets []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(nets))
+	for _, n := range nets {
+		n = strings.TrimSpace(n)
+		if !strings.Contains(n, "/") {
+			addr, err := netip.ParseAddr(n)
+			if err != nil {
+				return nil, errors.Wrapf(err, "bad network %q", n)
+			}
+			res = append(res, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(n)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad network %q", n)
+		}
+		res = append(res, p.Masked())
+	}
+	return res, nil
+}
+
+// check sender's ip:port, returns false if sender rejected and true for unknown sender accepted with tag policy
+func (f *SenderFilter) check(sender string) (accepted, unknown bool) {
+	ip, ok := senderIP(sender)
+	if !ok {
+		return false, false
+	}
+	for _, p := range f.Deny {
+		if p.Contains(ip) {
+			return false, false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true, false
+	}
+	for _, p := range f.Allow {
+		if p.Contains(ip) {
+			return true, false
+		}
+	}
+	return f.Unknown == SenderTag, true
+}
+
+// accept checks sender like check, nil filter accepts everyone. Rejected sender counted and logged.
+func (f *SenderFilter) accept(sender string, stats *Stats) (accepted, unknown bool) {
+	if f == nil {
+		return true, false
+	}
+	if accepted, unknown = f.check(sender); !accepted {
+		stats.SenderRejected.Add(1)
+		stats.warnSender(sender)
+	}
+	return accepted, unknown
+}
+
+// senderIP extracts ip from sender's "ip:port", ipv4-mapped ipv6 converted to ipv4
+func senderIP(sender string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(sender); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(sender); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// hostResolver makes host name from sender's ip with reverse dns lookup. Lookups done in background, so resolve never
+// waits for dns. Results, failed lookups including, cached.
+type hostResolver struct {
+	lookup func(ctx context.Context, addr string) ([]string, error)
+
+	lock    sync.Mutex
+	cache   map[string]resolvedHost
+	pending map[string]bool // ips with lookup in progress
+}
+
+type resolvedHost struct {
+	name string
+	ts   time.Time
+}
+
+const (
+	resolveTimeout    = time.Second
+	resolveCacheTTL   = 10 * time.Minute
+	resolveCacheMax   = 10000
+	resolveMaxPending = 100 // max concurrent lookups, ip used as-is for others
+)
+
+func newHostResolver() *hostResolver {
+	return &hostResolver{lookup: net.DefaultResolver.LookupAddr, cache: map[string]resolvedHost{}, pending: map[string]bool{}}
+}
+
+// resolve returns cached host name of ip, or ip itself if not resolved yet or lookup failed.
+// Missing or expired name looked up in background, expired one returned till refreshed.
+func (r *hostResolver) resolve(ip string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	h, ok := r.cache[ip]
+	if ok && time.Since(h.ts) < resolveCacheTTL {
+		return h.name
+	}
+	if !r.pending[ip] && len(r.pending) < resolveMaxPending {
+		r.pending[ip] = true
+		go r.update(ip)
+	}
+	if ok {
+		return h.name
+	}
+	return ip
+}
+
+// update looks up ip and caches the result
+func (r *hostResolver) update(ip string) {
+	name := ip
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	if names, err := r.lookup(ctx, ip); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.pending, ip)
+	if len(r.cache) >= resolveCacheMax {
+		clear(r.cache)
+	}
+	r.cache[ip] = resolvedHost{name: name, ts: time.Now()}
+}
+
+// isLocalHost checks if host is missing or localhost, i.e. sent by misconfigured client
+func isLocalHost(host string) bool {
+	switch strings.ToLower(host) {
+	case "", "localhost", "localhost.localdomain":
+		return true
+	}
+	return false
+}
+
+// acceptConns accepts connections till listener closed, connections from accepted senders handled in their own
+// goroutines. Returns after all handlers finished.
+func acceptConns(ctx context.Context, listener net.Listener, senders *SenderFilter, stats *Stats,
+	handle func(conn net.Conn, unknown bool)) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[WARN] accept on %s failed, %v", listener.Addr(), err)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		accepted, unknown := senders.accept(conn.RemoteAddr().String(), stats)
+		if !accepted {
+			_ = conn.Close()
+			continue
+		}
+		wg.Go(func() { handle(conn, unknown) })
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSenderPolicy(t *testing.T) {
+	tbl := []struct {
+		in  string
+		res SenderPolicy
+		err string
+	}{
+		{"", SenderReject, ""},
+		{"reject", SenderReject, ""},
+		{"tag", SenderTag, ""},
+		{"blah", "", `unknown sender policy "blah", expected reject or tag`},
+	}
+	for i, tt := range tbl {
+		res, err := ParseSenderPolicy(tt.in)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.res, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	res, err := ParseCIDRs([]string{"10.0.0.0/8", " 192.168.1.10", "192.168.2.1/24", "fd00::/8", "::ffff:172.16.0.1"})
+	require.NoError(t, err)
+	assert.Equal(t, "[10.0.0.0/8 192.168.1.10/32 192.168.2.0/24 fd00::/8 172.16.0.1/32]", fmt.Sprintf("%v", res))
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/8", "blah"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `bad network "blah"`)
+	_, err = ParseCIDRs([]string{"10.0.0.0/40"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `bad network "10.0.0.0/40"`)
+}
+
+func TestSenderFilter_check(t *testing.T) {
+	allow, err := ParseCIDRs([]string{"10.0.0.0/8", "fd00::/8"})
+	require.NoError(t, err)
+	deny, err := ParseCIDRs([]string{"10.0.1.0/24"})
+	require.NoError(t, err)
+
+	tbl := []struct {
+		filter            SenderFilter
+		sender            string
+		accepted, unknown bool
+	}{
+		{SenderFilter{Allow: allow, Deny: deny}, "10.0.0.1:5514", true, false},
+		{SenderFilter{Allow: allow, Deny: deny}, "[::ffff:10.0.0.1]:5514", true, false},
+		{SenderFilter{Allow: allow, Deny: deny}, "[fd00::1]:5514", true, false},
+		{SenderFilter{Allow: allow, Deny: deny}, "10.0.1.1:5514", false, false},
+		{SenderFilter{Allow: allow, Deny: deny}, "192.168.1.1:5514", false, true},
+		{SenderFilter{Allow: allow, Deny: deny, Unknown: SenderTag}, "192.168.1.1:5514", true, true},
+		{SenderFilter{Allow: allow, Deny: deny, Unknown: SenderTag}, "10.0.1.1:5514", false, false},
+		{SenderFilter{Deny: deny}, "192.168.1.1:5514", true, false},
+		{SenderFilter{Deny: deny}, "10.0.1.2:5514", false, false},
+		{SenderFilter{Deny: deny}, "blah", false, false},
+		{SenderFilter{Allow: allow}, "10.0.0.1", true, false},
+	}
+	for i, tt := range tbl {
+		accepted, unknown := tt.filter.check(tt.sender)
+		assert.Equal(t, tt.accepted, accepted, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.unknown, unknown, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestHostResolver(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	r := newHostResolver()
+	r.lookup = func(_ context.Context, addr string) ([]string, error) {
+		calls.Add(1)
+		<-release
+		if addr == "10.0.0.1" {
+			return []string{"web-1.example.com."}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: addr}
+	}
+
+	assert.Equal(t, "10.0.0.1", r.resolve("10.0.0.1"), "ip while lookup in progress")
+	assert.Equal(t, "10.0.0.2", r.resolve("10.0.0.2"))
+	assert.Equal(t, "10.0.0.1", r.resolve("10.0.0.1"), "not blocked by lookup")
+	close(release)
+	assert.Eventually(t, func() bool { return r.resolve("10.0.0.1") == "web-1.example.com" }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		_, ok := r.cache["10.0.0.2"]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "10.0.0.2", r.resolve("10.0.0.2"), "ip if lookup failed")
+	assert.Equal(t, int32(2), calls.Load(), "single lookup per ip, failed lookup cached")
+
+	r.lock.Lock()
+	r.cache["10.0.0.1"] = resolvedHost{name: "old", ts: time.Now().Add(-resolveCacheTTL)}
+	r.lock.Unlock()
+	assert.Equal(t, "old", r.resolve("10.0.0.1"), "expired name used till refreshed")
+	assert.Eventually(t, func() bool { return r.resolve("10.0.0.1") == "web-1.example.com" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestIsLocalHost(t *testing.T) {
+	for i, h := range []string{"", "localhost", "LocalHost", "localhost.localdomain"} {
+		assert.True(t, isLocalHost(h), fmt.Sprintf("mismatch in #%d", i))
+	}
+	for i, h := range []string{"web-1", "127.0.0.2", "localhost-1"} {
+		assert.False(t, isLocalHost(h), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
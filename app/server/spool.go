@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// Spool keeps batches failed to publish in local directory and publishes them later, in the same order.
+// Each batch is a file with json entry per line. The oldest batches dropped on MaxSize.
+type Spool struct {
+	SpoolParams
+
+	lock    sync.Mutex
+	batches []spoolBatch // from old to new
+	nextSeq uint64
+	stats   SpoolStats
+}
+
+// SpoolParams defines location, limits and retry intervals of spool
+type SpoolParams struct {
+	Path     string
+	MaxSize  int64         // max total size of batches, bytes
+	MinRetry time.Duration // retry interval after the first failure, doubled on each next one
+	MaxRetry time.Duration // max retry interval
+}
+
+// SpoolStats shows spool depth and dropped records
+type SpoolStats struct {
+	Batches int   `json:"batches"`
+	Records int   `json:"records"`
+	Size    int64 `json:"size"`
+	Dropped int64 `json:"dropped"` // records dropped on size limit or broken batches
+}
+
+type spoolBatch struct {
+	name  string
+	size  int64
+	count int
+}
+
+const (
+	defSpoolMaxSize  = 1024 * 1024 * 1024 // 1G
+	defSpoolMinRetry = time.Second
+	defSpoolMaxRetry = time.Minute
+	spoolExt         = ".spool"
+)
+
+// NewSpool makes Spool in params.Path directory, batches left from the previous run loaded
+func NewSpool(params SpoolParams) (*Spool, error) {
+	log.Printf("[INFO] make new spool with %+v", params)
+	if params.MaxSize == 0 {
+		params.MaxSize = defSpoolMaxSize
+	}
+	if params.MinRetry == 0 {
+		params.MinRetry = defSpoolMinRetry
+	}
+	if params.MaxRetry == 0 {
+		params.MaxRetry = defSpoolMaxRetry
+	}
+	if err := os.MkdirAll(params.Path, 0o750); err != nil {
+		return nil, errors.Wrapf(err, "can't make spool directory %s", params.Path)
+	}
+
+	res := &Spool{SpoolParams: params}
+	if tmps, err := filepath.Glob(filepath.Join(params.Path, "*"+spoolExt+".tmp")); err == nil {
+		for _, f := range tmps {
+			_ = os.Remove(f) // partial batch of interrupted Add
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(params.Path, "*"+spoolExt))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't list spool batches in %s", params.Path)
+	}
+	sort.Strings(files) // names are fixed-width hex of seq
+	for _, f := range files {
+		data, e := os.ReadFile(f) //nolint:gosec // path made from spool directory
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't read spool batch %s", f)
+		}
+		name := strings.TrimSuffix(filepath.Base(f), spoolExt)
+		b := spoolBatch{name: name, size: int64(len(data)), count: bytes.Count(data, []byte("\n"))}
+		res.batches = append(res.batches, b)
+		res.stats.Batches++
+		res.stats.Records += b.count
+		res.stats.Size += b.size
+		_, _ = fmt.Sscanf(name, "%x", &res.nextSeq)
+	}
+	if len(res.batches) > 0 {
+		log.Printf("[INFO] spool loaded, %+v", res.stats)
+	}
+	return res, nil
+}
+
+// Add saves batch to the spool, the oldest batches dropped if size exceeds MaxSize
+func (s *Spool) Add(entries []core.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return errors.Wrap(err, "can't encode spool entry")
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nextSeq++
+	b := spoolBatch{name: fmt.Sprintf("%016x", s.nextSeq), size: int64(buf.Len()), count: len(entries)}
+	// write to tmp and rename, spool never sees partial batch
+	tmp := s.batchPath(b.name) + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o640); err != nil {
+		return errors.Wrapf(err, "can't write spool batch %s", b.name)
+	}
+	if err := os.Rename(tmp, s.batchPath(b.name)); err != nil {
+		return errors.Wrapf(err, "can't save spool batch %s", b.name)
+	}
+	s.batches = append(s.batches, b)
+	s.stats.Batches++
+	s.stats.Records += b.count
+	s.stats.Size += b.size
+
+	for s.stats.Size > s.MaxSize && len(s.batches) > 0 {
+		dropped := s.batches[0]
+		s.remove()
+		s.stats.Dropped += int64(dropped.count)
+		log.Printf("[WARN] spool size limit reached, dropped %d records of batch %s", dropped.count, dropped.name)
+	}
+	return nil
+}
+
+// Empty checks if spool has no pending batches
+func (s *Spool) Empty() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.batches) == 0
+}
+
+// Stats returns spool depth and dropped records
+func (s *Spool) Stats() SpoolStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stats
+}
+
+// Flush publishes batches from the oldest one and removes published. Stops on the first publish error.
+// Broken batch can't be published ever, so it is dropped.
+func (s *Spool) Flush(publish func(entries []core.LogEntry) error) (published int, err error) {
+	for {
+		s.lock.Lock()
+		if len(s.batches) == 0 {
+			s.lock.Unlock()
+			return published, nil
+		}
+		b := s.batches[0]
+		s.lock.Unlock()
+
+		entries, rerr := s.read(b)
+		if rerr == nil {
+			if err = publish(entries); err != nil {
+				return published, errors.Wrapf(err, "can't publish spool batch %s", b.name)
+			}
+			published += len(entries)
+		}
+
+		s.lock.Lock()
+		if len(s.batches) > 0 && s.batches[0].name == b.name { // not dropped by Add in the meantime
+			if rerr != nil {
+				log.Printf("[WARN] broken spool batch %s dropped, %v", b.name, rerr)
+				s.stats.Dropped += int64(b.count)
+			}
+			s.remove()
+		}
+		s.lock.Unlock()
+	}
+}
+
+// Run flushes spool in background until ctx done. Retries with backoff while publish fails.
+func (s *Spool) Run(ctx context.Context, publish func(entries []core.LogEntry) error) {
+	delay := s.MinRetry
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		n, err := s.Flush(publish)
+		if err != nil {
+			delay = min(delay*2, s.MaxRetry)
+			log.Printf("[WARN] spool flush failed, retry in %v, %v, %+v", delay, err, s.Stats())
+			continue
+		}
+		delay = s.MinRetry
+		if n > 0 {
+			log.Printf("[INFO] spool flushed %d records, %+v", n, s.Stats())
+		}
+	}
+}
+
+// remove the oldest batch, has to be called under lock
+func (s *Spool) remove() {
+	b := s.batches[0]
+	if err := os.Remove(s.batchPath(b.name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARN] can't remove spool batch %s, %v", b.name, err)
+	}
+	s.batches = s.batches[1:]
+	s.stats.Batches--
+	s.stats.Records -= b.count
+	s.stats.Size -= b.size
+}
+
+func (s *Spool) read(b spoolBatch) ([]core.LogEntry, error) {
+	fh, err := os.Open(s.batchPath(b.name))
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close() //nolint
+
+	res := make([]core.LogEntry, 0, b.count)
+	dec := json.NewDecoder(bufio.NewReader(fh))
+	for dec.More() {
+		e := core.LogEntry{}
+		if err = dec.Decode(&e); err != nil {
+			return nil, errors.Wrapf(err, "can't decode spool batch %s", b.name)
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+func (s *Spool) batchPath(name string) string {
+	return filepath.Join(s.Path, name+spoolExt)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestSpool_AddFlush(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(SpoolParams{Path: dir})
+	require.NoError(t, err)
+	assert.True(t, s.Empty())
+
+	recs := testRecords()
+	require.NoError(t, s.Add(recs[:2]))
+	firstSize := s.Stats().Size
+	require.NoError(t, s.Add(recs[2:]))
+	require.NoError(t, s.Add(nil))
+	assert.False(t, s.Empty())
+	st := s.Stats()
+	assert.Equal(t, 2, st.Batches)
+	assert.Equal(t, 6, st.Records)
+	assert.True(t, st.Size > 0)
+
+	var published []core.LogEntry
+	n, err := s.Flush(func(entries []core.LogEntry) error {
+		if len(published) > 0 {
+			return errors.New("publish error")
+		}
+		published = append(published, entries...)
+		return nil
+	})
+	assert.EqualError(t, err, "can't publish spool batch 0000000000000002: publish error")
+	assert.Equal(t, 2, n)
+	assert.Equal(t, SpoolStats{Batches: 1, Records: 4, Size: st.Size - firstSize}, s.Stats(), "failed batch kept")
+
+	// reload from disk
+	s, err = NewSpool(SpoolParams{Path: dir})
+	require.NoError(t, err)
+	assert.Equal(t, 4, s.Stats().Records)
+	require.NoError(t, s.Add(recs[:1]))
+
+	n, err = s.Flush(func(entries []core.LogEntry) error {
+		published = append(published, entries...)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.True(t, s.Empty())
+	assert.Equal(t, SpoolStats{}, s.Stats())
+	require.Equal(t, 7, len(published))
+	for i, msg := range []string{"msg1", "msg2", "msg3", "msg4", "msg5", "msg6", "msg1"} {
+		assert.Equal(t, msg, published[i].Msg, "in order")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(files), "published batches removed")
+}
+
+func TestSpool_SizeLimit(t *testing.T) {
+	s, err := NewSpool(SpoolParams{Path: t.TempDir(), MaxSize: 700})
+	require.NoError(t, err)
+	for range 5 {
+		require.NoError(t, s.Add(testRecords()[:2])) // about 300 bytes each
+	}
+	st := s.Stats()
+	assert.True(t, st.Size <= 700)
+	assert.Equal(t, 2, st.Batches)
+	assert.Equal(t, int64(6), st.Dropped, "the oldest dropped")
+
+	var published []core.LogEntry
+	_, err = s.Flush(func(entries []core.LogEntry) error {
+		published = append(published, entries...)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(published))
+}
+
+func TestSpool_BrokenBatch(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0000000000000001.spool"), []byte("{bad\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0000000000000002.spool.tmp"), []byte("{}\n"), 0o600))
+	s, err := NewSpool(SpoolParams{Path: dir})
+	require.NoError(t, err)
+	require.NoError(t, s.Add(testRecords()[:1]))
+	assert.Equal(t, "0000000000000002", s.batches[1].name, "seq continued")
+
+	var published []core.LogEntry
+	n, err := s.Flush(func(entries []core.LogEntry) error {
+		published = append(published, entries...)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int64(1), s.Stats().Dropped)
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(files), "tmp removed")
+}
+
+func TestSpool_Run(t *testing.T) {
+	s, err := NewSpool(SpoolParams{Path: t.TempDir(), MinRetry: 10 * time.Millisecond, MaxRetry: 40 * time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, s.Add(testRecords()))
+
+	var lock sync.Mutex
+	attempts := 0
+	var published []core.LogEntry
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx, func(entries []core.LogEntry) error {
+			lock.Lock()
+			defer lock.Unlock()
+			attempts++
+			if attempts < 4 {
+				return errors.New("store down")
+			}
+			published = append(published, entries...)
+			return nil
+		})
+		close(done)
+	}()
+
+	require.Eventually(t, s.Empty, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 4, attempts)
+	assert.Equal(t, 6, len(published))
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// OverflowPolicy defines what to do with a new entry if queue is full
+type OverflowPolicy string
+
+// enum of all overflow policies
+const (
+	OverflowBlock      OverflowPolicy = "block"       // wait for space, stalls sender
+	OverflowDropNewest OverflowPolicy = "drop-newest" // drop the new entry
+	OverflowDropOldest OverflowPolicy = "drop-oldest" // drop the oldest queued entry to make space
+)
+
+const (
+	defQueueSize        = 10000
+	warnInterval        = 10 * time.Second // drop and sender warnings logged not often than this, per kind
+	statsLogInterval    = time.Minute
+	statsStageSyslog    = "syslog"
+	statsStageForwarder = "forwarder"
+	statsStageGelf      = "gelf"
+	statsStageFluent    = "fluent"
+	statsSender         = "sender"
+)
+
+// ParseOverflowPolicy checks policy name, empty means block
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case "":
+		return OverflowBlock, nil
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q, expected block, drop-newest or drop-oldest", s)
+	}
+}
+
+// Stats counts entries on each stage of ingest pipeline and keeps queue gauges. Thread safe.
+type Stats struct {
+	Received       atomic.Int64 // lines and events received by syslog, gelf and fluent servers
+	Ingested       atomic.Int64 // entries accepted by ingest api
+	SenderRejected atomic.Int64 // lines and connections from denied or unknown senders
+	SyslogDropped  atomic.Int64 // lines dropped on full syslog queue
+	Parsed         atomic.Int64 // entries made from lines and messages
+	Rejected       atomic.Int64 // lines, messages and events failed to parse
+	Dropped        atomic.Int64 // entries dropped on full forwarder, gelf or fluent queue
+	Published      atomic.Int64 // entries published to store
+	PublishFailed  atomic.Int64 // entries failed to publish, spooled if spool enabled
+	lock           sync.Mutex
+	queues         map[string]chanGauge
+	spool          *Spool
+	lastWarned     map[string]time.Time
+}
+
+// StatsSnapshot is a point in time copy of Stats
+type StatsSnapshot struct {
+	Received       int64                 `json:"received"`
+	Ingested       int64                 `json:"ingested"`
+	SenderRejected int64                 `json:"sender_rejected"`
+	SyslogDropped  int64                 `json:"syslog_dropped"`
+	Parsed         int64                 `json:"parsed"`
+	Rejected       int64                 `json:"rejected"`
+	Dropped        int64                 `json:"dropped"`
+	Published      int64                 `json:"published"`
+	PublishFailed  int64                 `json:"publish_failed"`
+	Queues         map[string]QueueStats `json:"queues"`
+	Spool          *SpoolStats           `json:"spool,omitempty"`
+}
+
+// QueueStats shows queue length and capacity
+type QueueStats struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+type chanGauge func() QueueStats
+
+// Snapshot returns current counters and queues
+func (s *Stats) Snapshot() StatsSnapshot {
+	res := StatsSnapshot{
+		Received:       s.Received.Load(),
+		Ingested:       s.Ingested.Load(),
+		SenderRejected: s.SenderRejected.Load(),
+		SyslogDropped:  s.SyslogDropped.Load(),
+		Parsed:         s.Parsed.Load(),
+		Rejected:       s.Rejected.Load(),
+		Dropped:        s.Dropped.Load(),
+		Published:      s.Published.Load(),
+		PublishFailed:  s.PublishFailed.Load(),
+		Queues:         map[string]QueueStats{},
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for name, g := range s.queues {
+		res.Queues[name] = g()
+	}
+	if s.spool != nil {
+		st := s.spool.Stats()
+		res.Spool = &st
+	}
+	return res
+}
+
+// String makes log-friendly stats
+func (s StatsSnapshot) String() string {
+	res := fmt.Sprintf("received=%d, ingested=%d, sender-rejected=%d, syslog-dropped=%d, parsed=%d, rejected=%d, dropped=%d, "+
+		"published=%d, publish-failed=%d", s.Received, s.Ingested, s.SenderRejected, s.SyslogDropped, s.Parsed, s.Rejected, s.Dropped, s.Published, s.PublishFailed)
+	names := make([]string, 0, len(s.Queues))
+	for name := range s.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res += fmt.Sprintf(", %s-queue=%d/%d", name, s.Queues[name].Len, s.Queues[name].Cap)
+	}
+	if s.Spool != nil {
+		res += fmt.Sprintf(", spool=%d, spool-dropped=%d", s.Spool.Records, s.Spool.Dropped)
+	}
+	return res
+}
+
+// registerQueue adds gauge for the stage's queue
+func registerQueue[T any](s *Stats, stage string, ch chan T) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.queues == nil {
+		s.queues = map[string]chanGauge{}
+	}
+	s.queues[stage] = func() QueueStats { return QueueStats{Len: len(ch), Cap: cap(ch)} }
+}
+
+func (s *Stats) setSpool(spool *Spool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.spool = spool
+}
+
+// warnDrop logs dropped entries of the stage, rate limited
+func (s *Stats) warnDrop(stage string, policy OverflowPolicy) {
+	if !s.warnAllowed(stage) {
+		return
+	}
+	log.Printf("[WARN] %s queue is full, entries dropped with %s policy, syslog-dropped=%d, dropped=%d",
+		stage, policy, s.SyslogDropped.Load(), s.Dropped.Load())
+}
+
+// warnSender logs rejected sender, rate limited
+func (s *Stats) warnSender(sender string) {
+	if !s.warnAllowed(statsSender) {
+		return
+	}
+	log.Printf("[WARN] rejected denied or unknown sender %s, sender-rejected=%d", sender, s.SenderRejected.Load())
+}
+
+// warnAllowed checks if warning of the kind wasn't logged recently
+func (s *Stats) warnAllowed(kind string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lastWarned == nil {
+		s.lastWarned = map[string]time.Time{}
+	}
+	if time.Since(s.lastWarned[kind]) < warnInterval {
+		return false
+	}
+	s.lastWarned[kind] = time.Now()
+	return true
+}
+
+// send puts v to ch with overflow policy, returns number of dropped entries, the new or the oldest ones
+func send[T any](ch chan T, v T, policy OverflowPolicy) (dropped int) {
+	switch policy {
+	case OverflowDropNewest:
+		select {
+		case ch <- v:
+			return 0
+		default:
+			return 1
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case ch <- v:
+				return dropped
+			default:
+			}
+			select {
+			case <-ch:
+				dropped++
+			default:
+			}
+		}
+	default:
+		ch <- v
+		return 0
+	}
+}
+
+// sendCtx puts v to ch with overflow policy, blocked send released on ctx done. Dropped entries counted and warned.
+func sendCtx[T any](ctx context.Context, ch chan T, v T, policy OverflowPolicy, stats *Stats, stage string) {
+	if policy == OverflowBlock {
+		select {
+		case ch <- v:
+		case <-ctx.Done():
+		}
+		return
+	}
+	if dropped := send(ch, v, policy); dropped > 0 {
+		stats.Dropped.Add(int64(dropped))
+		stats.warnDrop(stage, policy)
+	}
+}
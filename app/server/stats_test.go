@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOverflowPolicy(t *testing.T) {
+	tbl := []struct {
+		inp string
+		res OverflowPolicy
+		err string
+	}{
+		{"", OverflowBlock, ""},
+		{"block", OverflowBlock, ""},
+		{"drop-newest", OverflowDropNewest, ""},
+		{"drop-oldest", OverflowDropOldest, ""},
+		{"drop", "", `unknown overflow policy "drop", expected block, drop-newest or drop-oldest`},
+	}
+	for i, tt := range tbl {
+		res, err := ParseOverflowPolicy(tt.inp)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.res, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestSend(t *testing.T) {
+	ch := make(chan int, 2)
+	assert.Equal(t, 0, send(ch, 1, OverflowDropNewest))
+	assert.Equal(t, 0, send(ch, 2, OverflowDropNewest))
+	assert.Equal(t, 1, send(ch, 3, OverflowDropNewest))
+	assert.Equal(t, 1, send(ch, 4, OverflowDropOldest))
+	assert.Equal(t, 2, <-ch)
+	assert.Equal(t, 4, <-ch, "new one kept, the oldest dropped")
+	assert.Equal(t, 0, send(ch, 5, OverflowBlock))
+	assert.Equal(t, 5, <-ch)
+}
+
+func TestStats_Snapshot(t *testing.T) {
+	s := &Stats{}
+	ch := make(chan int, 10)
+	ch <- 1
+	registerQueue(s, statsStageSyslog, ch)
+	s.Received.Add(10)
+	s.Ingested.Add(5)
+	s.SenderRejected.Add(3)
+	s.Parsed.Add(8)
+	s.Rejected.Add(2)
+	s.Dropped.Add(1)
+	s.Published.Add(7)
+
+	st := s.Snapshot()
+	assert.Equal(t, StatsSnapshot{Received: 10, Ingested: 5, SenderRejected: 3, Parsed: 8, Rejected: 2, Dropped: 1, Published: 7,
+		Queues: map[string]QueueStats{"syslog": {Len: 1, Cap: 10}}}, st)
+	assert.Equal(t, "received=10, ingested=5, sender-rejected=3, syslog-dropped=0, parsed=8, rejected=2, dropped=1, published=7, "+
+		"publish-failed=0, syslog-queue=1/10", st.String())
+
+	spool, err := NewSpool(SpoolParams{Path: t.TempDir()})
+	require.NoError(t, err)
+	s.setSpool(spool)
+	data, err := json.Marshal(s.Snapshot())
+	require.NoError(t, err)
+	assert.Equal(t, `{"received":10,"ingested":5,"sender_rejected":3,"syslog_dropped":0,"parsed":8,"rejected":2,"dropped":1,"published":7,"publish_failed":0,`+
+		`"queues":{"syslog":{"len":1,"cap":10}},"spool":{"batches":0,"records":0,"size":0,"dropped":0}}`, string(data))
+}
@@ -0,0 +1,266 @@
+// Package storetest provides conformance tests for stores, the same checks for any Publisher and DataService.
+package storetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// Store is a store under test, i.e. server.Mongo
+type Store interface {
+	Publish(records []core.LogEntry) error
+	LastPublished() (entry core.LogEntry, err error)
+	Find(req core.Request) ([]core.LogEntry, error)
+}
+
+// MaxLimit is the max number of records any single Find can return
+const MaxLimit = 1000
+
+var baseTS = time.Date(2019, 5, 24, 20, 54, 30, 0, time.Local)
+
+// Run all conformance tests. makeStore called for each test and has to return an empty store.
+func Run(t *testing.T, makeStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Store)
+	}{
+		{"LastPublished", testLastPublished},
+		{"NewestFirst", testNewestFirst},
+		{"Pagination", testPagination},
+		{"RegexLists", testRegexLists},
+		{"Excludes", testExcludes},
+		{"ExcludeGroups", testExcludeGroups},
+		{"TimeRange", testTimeRange},
+		{"LimitCap", testLimitCap},
+		{"Scope", testScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, makeStore(t))
+		})
+	}
+}
+
+// Records makes n records with msg0..msgN messages, hosts h0..h2 sent from 10.0.0.0..10.0.0.2, containers c0..c1
+// and ts one second apart
+func Records(n int) []core.LogEntry {
+	res := make([]core.LogEntry, n)
+	for i := range n {
+		res[i] = core.LogEntry{Host: fmt.Sprintf("h%d", i%3), Sender: fmt.Sprintf("10.0.0.%d", i%3), Container: fmt.Sprintf("c%d", i%2),
+			Msg: fmt.Sprintf("msg%d", i), TS: baseTS.Add(time.Duration(i) * time.Second), Severity: core.SevInfo}
+	}
+	return res
+}
+
+func testLastPublished(t *testing.T, s Store) {
+	last, err := s.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, "", last.ID, "nothing published")
+
+	recs := Records(5)
+	require.NoError(t, s.Publish(recs))
+	last, err = s.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, "msg4", last.Msg)
+	assert.Equal(t, "10.0.0.1", last.Sender)
+	assert.Equal(t, last.ID, recs[4].ID, "ids set on publish")
+
+	recs, err = s.Find(core.Request{})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(recs))
+	lastID := recs[4].ID
+	assert.NotEmpty(t, lastID)
+
+	recs, err = s.Find(core.Request{LastID: lastID})
+	require.NoError(t, err)
+	assert.Equal(t, []core.LogEntry{}, recs, "nothing after the last published")
+
+	require.NoError(t, s.Publish(Records(7)[5:]))
+	last, err = s.LastPublished()
+	require.NoError(t, err)
+	assert.Equal(t, "msg6", last.Msg, "cached last updated on publish")
+
+	recs, err = s.Find(core.Request{LastID: lastID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msg5", "msg6"}, msgs(recs), "new records after the cached last")
+}
+
+func testNewestFirst(t *testing.T, s Store) {
+	require.NoError(t, s.Publish(Records(10)))
+
+	recs, err := s.Find(core.Request{Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msg7", "msg8", "msg9"}, msgs(recs), "the latest records, from old to new")
+
+	recs, err = s.Find(core.Request{LastID: "0", Limit: 2, Hosts: []string{"h0"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msg6", "msg9"}, msgs(recs), "LastID 0 same as empty")
+}
+
+func testPagination(t *testing.T, s Store) {
+	require.NoError(t, s.Publish(Records(10)))
+	require.NoError(t, s.Publish(Records(20)[10:]))
+
+	first, err := s.Find(core.Request{Limit: 20})
+	require.NoError(t, err)
+	require.Equal(t, 20, len(first))
+
+	var res []core.LogEntry
+	lastID, pages := first[0].ID, 0
+	for pages = 0; pages < 20; pages++ {
+		recs, e := s.Find(core.Request{LastID: lastID, Limit: 4})
+		require.NoError(t, e)
+		if len(recs) == 0 {
+			break
+		}
+		assert.True(t, len(recs) <= 4)
+		res = append(res, recs...)
+		lastID = recs[len(recs)-1].ID
+	}
+	assert.Equal(t, 5, pages, "19 records in 5 pages")
+	require.Equal(t, 19, len(res))
+	for i, r := range res {
+		assert.Equal(t, fmt.Sprintf("msg%d", i+1), r.Msg, "in publish order")
+		assert.Equal(t, first[i+1].ID, r.ID)
+		if i > 0 {
+			assert.True(t, r.ID > res[i-1].ID, "ids ordered")
+		}
+	}
+
+	recs, err := s.Find(core.Request{LastID: first[5].ID, Containers: []string{"c1"}, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msg7", "msg9"}, msgs(recs), "filtered page")
+}
+
+func testRegexLists(t *testing.T, s Store) {
+	require.NoError(t, s.Publish(Records(6)))
+	tbl := []struct {
+		req  core.Request
+		msgs []string
+	}{
+		{core.Request{Hosts: []string{"h1"}}, []string{"msg1", "msg4"}},
+		{core.Request{Hosts: []string{"/^h[12]$/"}}, []string{"msg1", "msg2", "msg4", "msg5"}},
+		{core.Request{Hosts: []string{"h0", "/2$/"}}, []string{"msg0", "msg2", "msg3", "msg5"}},
+		{core.Request{Hosts: []string{"h"}}, []string{}},
+		{core.Request{Containers: []string{"/^c/"}, Hosts: []string{"h0"}}, []string{"msg0", "msg3"}},
+		{core.Request{Containers: []string{"/1/"}}, []string{"msg1", "msg3", "msg5"}},
+	}
+	for i, tt := range tbl {
+		recs, err := s.Find(tt.req)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.msgs, msgs(recs), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func testExcludes(t *testing.T, s Store) {
+	require.NoError(t, s.Publish(Records(6)))
+	tbl := []struct {
+		req  core.Request
+		msgs []string
+	}{
+		{core.Request{Excludes: []string{"c0"}}, []string{"msg1", "msg3", "msg5"}},
+		{core.Request{Containers: []string{"/^c/"}, Excludes: []string{"/0$/"}}, []string{"msg1", "msg3", "msg5"}},
+		{core.Request{Containers: []string{"c1"}, Excludes: []string{"c1"}}, []string{}},
+		{core.Request{Hosts: []string{"/^h/"}, ExcludeHosts: []string{"h1"}, Excludes: []string{"c1"}}, []string{"msg0", "msg2"}},
+		{core.Request{ExcludeHosts: []string{"/[01]/"}}, []string{"msg2", "msg5"}},
+	}
+	for i, tt := range tbl {
+		recs, err := s.Find(tt.req)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.msgs, msgs(recs), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func testExcludeGroups(t *testing.T, s Store) {
+	recs := Records(4)
+	recs[0].Group, recs[1].Group, recs[2].Group = "db", "app", "app-ext"
+	require.NoError(t, s.Publish(recs))
+	tbl := []struct {
+		req  core.Request
+		msgs []string
+	}{
+		{core.Request{ExcludeGroups: []string{"app"}}, []string{"msg0", "msg2", "msg3"}},
+		{core.Request{ExcludeGroups: []string{"/^app/"}}, []string{"msg0", "msg3"}},
+		{core.Request{Groups: []string{"/^app/"}, ExcludeGroups: []string{"app-ext"}}, []string{"msg1"}},
+	}
+	for i, tt := range tbl {
+		recs, err := s.Find(tt.req)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.msgs, msgs(recs), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func testTimeRange(t *testing.T, s Store) {
+	require.NoError(t, s.Publish(Records(6)))
+	tbl := []struct {
+		req  core.Request
+		msgs []string
+	}{
+		{core.Request{FromTS: baseTS.Add(4 * time.Second)}, []string{"msg4", "msg5"}},
+		{core.Request{ToTS: baseTS.Add(2 * time.Second)}, []string{"msg0", "msg1"}},
+		{core.Request{FromTS: baseTS.Add(1 * time.Second), ToTS: baseTS.Add(3 * time.Second)}, []string{"msg1", "msg2"}},
+		{core.Request{FromTS: baseTS.Add(1500 * time.Millisecond), ToTS: baseTS.Add(2500 * time.Millisecond)}, []string{"msg2"}},
+		{core.Request{FromTS: baseTS.Add(time.Hour)}, []string{}},
+		{core.Request{FromTS: baseTS.Add(time.Second), ToTS: baseTS.Add(5 * time.Second), Hosts: []string{"h1"}}, []string{"msg1", "msg4"}},
+	}
+	for i, tt := range tbl {
+		recs, err := s.Find(tt.req)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.msgs, msgs(recs), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func testScope(t *testing.T, s Store) {
+	require.NoError(t, s.Publish(Records(6)))
+	tbl := []struct {
+		req  core.Request
+		msgs []string
+	}{
+		{core.Request{ScopeHosts: []string{"h1"}}, []string{"msg1", "msg4"}},
+		{core.Request{ScopeHosts: []string{"/^h[01]$/"}, Hosts: []string{"h1", "h2"}}, []string{"msg1", "msg4"}},
+		{core.Request{ScopeHosts: []string{"h2"}, Hosts: []string{"h1"}}, []string{}},
+		{core.Request{ScopeContainers: []string{"c1"}, Containers: []string{"/^c/"}, Excludes: []string{"c0"}}, []string{"msg1", "msg3", "msg5"}},
+		{core.Request{ScopeHosts: []string{"h0"}, ScopeContainers: []string{"c1"}}, []string{"msg3"}},
+		{core.Request{ScopeContainers: []string{"c1"}, Fields: []string{"level=error"}}, []string{}},
+	}
+	for i, tt := range tbl {
+		recs, err := s.Find(tt.req)
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.msgs, msgs(recs), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func testLimitCap(t *testing.T, s Store) {
+	recs := Records(MaxLimit + 100)
+	for i := 0; i < len(recs); i += 500 {
+		require.NoError(t, s.Publish(recs[i:min(i+500, len(recs))]))
+	}
+
+	res, err := s.Find(core.Request{Limit: MaxLimit * 5})
+	require.NoError(t, err)
+	require.Equal(t, MaxLimit, len(res), "capped")
+	assert.Equal(t, "msg100", res[0].Msg)
+	assert.Equal(t, fmt.Sprintf("msg%d", MaxLimit+99), res[MaxLimit-1].Msg)
+
+	res, err = s.Find(core.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, MaxLimit, len(res), "default limit")
+
+	res, err = s.Find(core.Request{LastID: res[0].ID, Limit: MaxLimit * 5})
+	require.NoError(t, err)
+	assert.Equal(t, MaxLimit-1, len(res), "all after LastID")
+}
+
+func msgs(recs []core.LogEntry) []string {
+	res := []string{}
+	for _, r := range recs {
+		res = append(res, r.Msg)
+	}
+	return res
+}
@@ -3,8 +3,9 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"fmt"
-	"strings"
+	"net"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
@@ -13,32 +14,72 @@ import (
 	"gopkg.in/mcuadros/go-syslog.v2/format"
 )
 
-// Syslog server on TCP & UDP 5514. Should be mapped to 514 in compose
+// Syslog server on TCP & UDP 5514. Should be mapped to 514 in compose.
+// With TLS set it also accepts syslog over tls (RFC 5425) on TLSPort.
+// TCP and TLS connections can use octet-counted or newline delimited framing, detected per connection.
 type Syslog struct {
-	Port   int
-	server *syslog.Server
+	Port           int
+	TLSPort        int
+	TLS            *tls.Config    // optional, enables tls listener
+	MaxMessageSize int            // longer messages truncated, 64K by default
+	QueueSize      int            // size of messages queue, 10000 by default
+	Overflow       OverflowPolicy // what to do with new message if queue is full, block by default
+	Stats          *Stats         // optional, counts received and dropped messages
+	Senders        *SenderFilter  // optional, rejects or tags messages from denied and unknown senders
+	server         *syslog.Server // udp only, tcp and tls served by serveStream
+}
+
+const (
+	defMaxMessageSize = 64 * 1024
+	minMaxMessageSize = 480 // RFC 5424 section 6.1, receivers must accept messages up to 480 octets
+)
+
+// RawMessage is a line received by syslog server, with sender's address and receive time
+type RawMessage struct {
+	Line       string
+	Sender     string // remote address, ip:port
+	ReceivedTS time.Time
+	Unknown    bool // sender not in allow list, accepted with tag policy
 }
 
 // Go starts syslog server in background and returns channel with messages
-func (s *Syslog) Go(ctx context.Context) (<-chan string, error) {
+func (s *Syslog) Go(ctx context.Context) (<-chan RawMessage, error) {
 	log.Printf("[INFO] activate syslog server on %d", s.Port)
-	outCh := make(chan string, 10000) // messages chanel
+	if s.QueueSize <= 0 {
+		s.QueueSize = defQueueSize
+	}
+	if s.Stats == nil {
+		s.Stats = &Stats{}
+	}
+	if s.MaxMessageSize == 0 {
+		s.MaxMessageSize = defMaxMessageSize
+	}
+	if s.MaxMessageSize < minMaxMessageSize {
+		return nil, errors.Errorf("max message size %d is less than %d", s.MaxMessageSize, minMaxMessageSize)
+	}
+	outCh := make(chan RawMessage, s.QueueSize) // messages chanel
+	registerQueue(s.Stats, statsStageSyslog, outCh)
 	inCh := make(syslog.LogPartsChannel)
 	handler := syslog.NewChannelHandler(inCh)
 	s.server = syslog.NewServer()
-	s.server.SetTimeout(1000)
 	s.server.SetFormat(&origFormatter{})
 	s.server.SetHandler(handler)
 	addr := fmt.Sprintf("0.0.0.0:%d", s.Port)
 	if err := s.server.ListenUDP(addr); err != nil {
 		return nil, errors.Wrapf(err, "syslog can't listen to udp on %d", s.Port)
 	}
-	if err := s.server.ListenTCP(addr); err != nil {
-		return nil, errors.Wrapf(err, "syslog can't listen to tcp on %d", s.Port)
+	listeners, err := s.listenStreams(addr)
+	if err != nil {
+		_ = s.server.Kill()
+		return nil, err
 	}
 	if err := s.server.Boot(); err != nil {
 		return nil, errors.Wrap(err, "failed to activate syslog")
 	}
+	streamsDone := make([]<-chan struct{}, 0, len(listeners))
+	for _, l := range listeners {
+		streamsDone = append(streamsDone, s.serveStream(ctx, l, inCh))
+	}
 
 	go func(inCh syslog.LogPartsChannel) {
 		for {
@@ -46,7 +87,21 @@ func (s *Syslog) Go(ctx context.Context) (<-chan string, error) {
 			case <-ctx.Done():
 				return
 			case parts := <-inCh:
-				outCh <- fmt.Sprintf("%s", parts["msg"])
+				sender, _ := parts["client"].(string)
+				s.Stats.Received.Add(1)
+				accepted, unknown := s.checkSender(sender)
+				if !accepted {
+					continue
+				}
+				line := fmt.Sprintf("%s", parts["msg"])
+				if len(line) > s.MaxMessageSize { // udp datagram, stream frames truncated by splitter
+					line = string(truncate([]byte(line), s.MaxMessageSize))
+				}
+				msg := RawMessage{Line: line, Sender: sender, ReceivedTS: time.Now(), Unknown: unknown}
+				if dropped := send(outCh, msg, s.Overflow); dropped > 0 {
+					s.Stats.SyslogDropped.Add(int64(dropped))
+					s.Stats.warnDrop(statsStageSyslog, s.Overflow)
+				}
 			}
 		}
 	}(inCh)
@@ -58,6 +113,9 @@ func (s *Syslog) Go(ctx context.Context) (<-chan string, error) {
 			log.Printf("[WARN] failed to kill syslog server, %v", err)
 		}
 		s.server.Wait()
+		for _, done := range streamsDone {
+			<-done
+		}
 		close(inCh)
 		close(outCh)
 		log.Print("[INFO] syslog server terminated")
@@ -66,6 +124,28 @@ func (s *Syslog) Go(ctx context.Context) (<-chan string, error) {
 	return outCh, nil
 }
 
+// checkSender checks sender with filter, if defined. Rejected sender counted and logged.
+func (s *Syslog) checkSender(sender string) (accepted, unknown bool) {
+	return s.Senders.accept(sender, s.Stats)
+}
+
+// listenStreams listens tcp on addr and tls on TLSPort if TLS set
+func (s *Syslog) listenStreams(addr string) ([]net.Listener, error) {
+	tcpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "syslog can't listen to tcp on %d", s.Port)
+	}
+	if s.TLS == nil {
+		return []net.Listener{tcpListener}, nil
+	}
+	tlsListener, err := s.listenTLS()
+	if err != nil {
+		_ = tcpListener.Close()
+		return nil, err
+	}
+	return []net.Listener{tcpListener, tlsListener}, nil
+}
+
 type origFormatter struct{}
 
 // GetParser parses nothing and returns the original line
@@ -73,7 +153,7 @@ func (f *origFormatter) GetParser(line []byte) format.LogParser {
 	return &origParser{line: line}
 }
 
-// GetSplitFunc no split at all
+// GetSplitFunc no split at all, datagram is a single message
 func (f *origFormatter) GetSplitFunc() bufio.SplitFunc { return nil }
 
 type origParser struct {
@@ -84,15 +164,9 @@ func (p *origParser) Parse() error {
 	return nil
 }
 
+// Dump returns the original line. Leading <PRI> kept as-is, core.NewEntry extracts facility and severity from it
 func (p *origParser) Dump() format.LogParts {
-	s := string(p.line)
-	// line may start with <id> we don't need it
-	if strings.HasPrefix(s, "<") && strings.Contains(s, ">") {
-		if pos := strings.Index(s, ">"); pos >= 0 {
-			s = s[pos+1:]
-		}
-	}
-	return format.LogParts{"msg": s}
+	return format.LogParts{"msg": string(p.line)}
 }
 
 func (p *origParser) Location(*time.Location) {}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"unicode/utf8"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const (
+	truncatedMarker     = "...[truncated]"
+	maxOctetCountDigits = 10
+)
+
+type framing int
+
+const (
+	framingUnknown framing = iota
+	framingOctetCounted
+	framingNewline
+)
+
+// serveStream accepts tcp or tls syslog connections and sends received messages to inCh.
+// Returned channel closed after listener and all connections closed on ctx done.
+func (s *Syslog) serveStream(ctx context.Context, listener net.Listener, inCh chan<- format.LogParts) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		if err := listener.Close(); err != nil {
+			log.Printf("[WARN] failed to close syslog listener, %v", err)
+		}
+	}()
+
+	go func() {
+		defer close(done)
+		acceptConns(ctx, listener, s.Senders, s.Stats, func(conn net.Conn, _ bool) { s.readStream(ctx, conn, inCh) })
+	}()
+	return done
+}
+
+// readStream reads messages from connection till it closed or ctx done. Framing detected by the first frame,
+// see frameSplitter. Tls connection authenticated with handshake first.
+func (s *Syslog) readStream(ctx context.Context, conn net.Conn, inCh chan<- format.LogParts) {
+	defer conn.Close() // nolint
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	sender := conn.RemoteAddr().String()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := handshakeTLS(ctx, tlsConn); err != nil {
+			log.Printf("[WARN] %v", err)
+			return
+		}
+	}
+
+	splitter := &frameSplitter{maxSize: s.MaxMessageSize}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), s.MaxMessageSize+maxOctetCountDigits+1) // max message with its length prefix
+	scanner.Split(splitter.split)
+	for scanner.Scan() {
+		select {
+		case inCh <- format.LogParts{"msg": scanner.Text(), "client": sender}:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		log.Printf("[WARN] syslog connection from %s closed, %v", sender, err)
+	}
+}
+
+// frameSplitter splits syslog stream of a single connection to messages. Framing detected by the first frame,
+// octet-counted "MSG-LEN SP MSG" (RFC 6587 3.4.1, RFC 5425) if it starts with length, space and "<" of PRI,
+// newline delimited otherwise. Single LF after octet-counted frame, sent by some clients, skipped.
+// Messages longer than maxSize truncated with truncatedMarker and the rest of the frame discarded.
+type frameSplitter struct {
+	maxSize  int
+	framing  framing
+	skip     int  // bytes of truncated octet-counted frame left to discard
+	skipLine bool // discard till the end of truncated line
+	skipLF   bool // frame completed, LF trailer allowed
+}
+
+func (f *frameSplitter) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if f.skip > 0 && len(data) > 0 {
+		n := min(f.skip, len(data))
+		f.skip -= n
+		return n, nil, nil
+	}
+	if f.skipLine && len(data) > 0 {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			f.skipLine = false
+			return i + 1, nil, nil
+		}
+		return len(data), nil, nil
+	}
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if f.framing == framingUnknown {
+		size, hdrLen, err := octetCount(data, atEOF)
+		switch {
+		case err != nil:
+			f.framing = framingNewline
+		case size == 0 && hdrLen == 0, len(data) == hdrLen && !atEOF:
+			return 0, nil, nil // need more data to detect
+		case len(data) > hdrLen && data[hdrLen] == '<':
+			f.framing = framingOctetCounted
+		default:
+			f.framing = framingNewline // i.e. "2 in line" without PRI
+		}
+	}
+
+	if f.framing == framingNewline {
+		return f.splitLine(data, atEOF)
+	}
+	return f.splitOctetCounted(data, atEOF)
+}
+
+func (f *frameSplitter) splitOctetCounted(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if f.skipLF && len(data) > 0 {
+		f.skipLF = false
+		if data[0] == '\n' {
+			return 1, nil, nil
+		}
+	}
+	size, hdrLen, err := octetCount(data, atEOF)
+	if err != nil {
+		return 0, nil, err
+	}
+	if hdrLen == 0 {
+		return 0, nil, nil // need more data
+	}
+
+	end := hdrLen + min(size, f.maxSize)
+	if len(data) < end {
+		if atEOF {
+			return 0, nil, errors.Errorf("truncated frame, expected %d bytes, got %d", size, len(data)-hdrLen)
+		}
+		return 0, nil, nil // need more data
+	}
+	f.skipLF = true
+	if size > f.maxSize {
+		f.skip = size - f.maxSize
+		return end, truncate(data[hdrLen:end], f.maxSize), nil
+	}
+	return end, bytes.TrimRight(data[hdrLen:end], "\r\n"), nil
+}
+
+func (f *frameSplitter) splitLine(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	i := bytes.IndexByte(data, '\n')
+	switch {
+	case i == 0:
+		return 1, nil, nil // skip empty line
+	case i > 0:
+		line := bytes.TrimSuffix(data[:i], []byte("\r"))
+		if len(line) > f.maxSize {
+			return i + 1, truncate(line, f.maxSize), nil
+		}
+		return i + 1, line, nil
+	case len(data) > f.maxSize:
+		f.skipLine = true
+		return len(data), truncate(data, f.maxSize), nil
+	case atEOF:
+		return len(data), data, nil // last line without newline
+	}
+	return 0, nil, nil // need more data
+}
+
+// octetCount parses "MSG-LEN SP" prefix and returns message size and prefix length.
+// Zero size and prefix length mean more data needed.
+func octetCount(data []byte, atEOF bool) (size, hdrLen int, err error) {
+	sp := bytes.IndexByte(data, ' ')
+	if sp < 0 {
+		if atEOF || len(data) > maxOctetCountDigits {
+			return 0, 0, errors.Errorf("bad frame, no octet count in %.16q", data)
+		}
+		return 0, 0, nil
+	}
+	if sp == 0 {
+		return 0, 0, errors.New("bad frame, empty octet count")
+	}
+	if sp > maxOctetCountDigits || data[0] == '0' {
+		return 0, 0, errors.Errorf("bad frame, invalid octet count %.16q", data[:sp])
+	}
+	for _, c := range data[:sp] {
+		if c < '0' || c > '9' {
+			return 0, 0, errors.Errorf("bad frame, invalid octet count %.16q", data[:sp])
+		}
+	}
+	size, err = strconv.Atoi(string(data[:sp]))
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "bad frame, invalid octet count %.16q", data[:sp])
+	}
+	return size, sp + 1, nil
+}
+
+// truncate cuts message to maxSize bytes including truncatedMarker, on utf8 rune boundary
+func truncate(msg []byte, maxSize int) []byte {
+	n := maxSize - len(truncatedMarker)
+	for n > 0 && !utf8.RuneStart(msg[n]) {
+		n--
+	}
+	res := make([]byte, 0, n+len(truncatedMarker))
+	return append(append(res, msg[:n]...), truncatedMarker...)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslog_Framing(t *testing.T) {
+	s := Syslog{Port: 15513, MaxMessageSize: 500}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := s.Go(ctx)
+	require.NoError(t, err)
+
+	octet, err := net.Dial("tcp", "127.0.0.1:15513")
+	require.NoError(t, err)
+	defer octet.Close() // nolint
+	lines, err := net.Dial("tcp", "127.0.0.1:15513")
+	require.NoError(t, err)
+	defer lines.Close() // nolint
+
+	multiline := "<27>May 30 18:03:29 dev-1 docker/c1[1187]: panic: oops\n\tmain.go:12\n\tmain.go:42"
+	long := "<30>May 30 18:03:30 dev-1 docker/c1[1187]: " + strings.Repeat("x", 1000)
+	_, err = fmt.Fprintf(octet, "%d %s%d %s", len(multiline), multiline, len(long), long)
+	require.NoError(t, err)
+	assert.Equal(t, multiline, (<-ch).Line, "embedded newlines kept")
+	msg := (<-ch).Line
+	assert.Len(t, msg, 500)
+	assert.Equal(t, long[:486]+"...[truncated]", msg)
+	_, err = fmt.Fprint(octet, "5 hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", (<-ch).Line, "the rest of truncated frame discarded")
+
+	_, err = fmt.Fprintf(lines, "<30>May 30 18:03:27 dev-1 docker/c1[1187]: line1\n%s\n2 in line\n", long)
+	require.NoError(t, err)
+	assert.Equal(t, "<30>May 30 18:03:27 dev-1 docker/c1[1187]: line1", (<-ch).Line)
+	assert.Equal(t, long[:486]+"...[truncated]", (<-ch).Line)
+	assert.Equal(t, "2 in line", (<-ch).Line, "framing detected by the first frame")
+
+	_, err = (&Syslog{Port: 15514, MaxMessageSize: 100}).Go(ctx)
+	assert.EqualError(t, err, "max message size 100 is less than 480")
+}
+
+func TestSyslog_frameSplitter(t *testing.T) {
+	tbl := []struct {
+		data string
+		res  []string
+		err  string
+	}{
+		{"5 <1>ab6 <2>abc", []string{"<1>ab", "<2>abc"}, ""},
+		{"12 <1>l1\nline2\n5 <2>ab", []string{"<1>l1\nline2", "<2>ab"}, ""},
+		{"25 <1>" + strings.Repeat("a", 22) + "5 <2>ab", []string{"<1>aaa...[truncated]", "<2>ab"}, ""},
+		{"5 <1>ab\n5 <2>ab\n", []string{"<1>ab", "<2>ab"}, ""},
+		{"5 <1>ab\n\n5 <2>ab", []string{"<1>ab"}, `bad frame, invalid octet count "\n5"`},
+		{"5 <1>h", nil, "truncated frame, expected 5 bytes, got 4"},
+		{"5 <1>ab world", []string{"<1>ab"}, "bad frame, empty octet count"},
+		{"5 <1>ab+5 hello", []string{"<1>ab"}, `bad frame, invalid octet count "+5"`},
+		{"5 <1>ab99999999999 x", []string{"<1>ab"}, `bad frame, invalid octet count "99999999999"`},
+		{"5 hello6 world!", []string{"5 hello6 world!"}, ""},
+		{"2 in line\n3 abc", []string{"2 in line", "3 abc"}, ""},
+		{"12 ", []string{"12 "}, ""},
+		{"line1\r\n\nline2\n3 abc", []string{"line1", "line2", "3 abc"}, ""},
+		{"<30>h1 c1: msg\nlast", []string{"<30>h1 c1: msg", "last"}, ""},
+		{"2019/05/24 msg", []string{"2019/05/24 msg"}, ""},
+		{"0 x\n", []string{"0 x"}, ""},
+		{strings.Repeat("b", 30) + "\nline2", []string{"bbbbbb...[truncated]", "line2"}, ""},
+		{strings.Repeat("b", 100) + "\nline2", []string{"bbbbbb...[truncated]", "line2"}, ""},
+		{"", nil, ""},
+	}
+
+	for i, tt := range tbl {
+		splitter := &frameSplitter{maxSize: 20}
+		scanner := bufio.NewScanner(strings.NewReader(tt.data))
+		scanner.Buffer(make([]byte, 8), 31) // small buffer to check partial reads
+		scanner.Split(splitter.split)
+		var res []string
+		for scanner.Scan() {
+			res = append(res, scanner.Text())
+		}
+		assert.Equal(t, tt.res, res, fmt.Sprintf("mismatch in #%d", i))
+		if tt.err != "" {
+			assert.EqualError(t, scanner.Err(), tt.err, fmt.Sprintf("mismatch in #%d", i))
+			continue
+		}
+		assert.NoError(t, scanner.Err(), fmt.Sprintf("mismatch in #%d", i))
+	}
+}
+
+func TestSyslog_truncate(t *testing.T) {
+	assert.Equal(t, "abcdef...[truncated]", string(truncate([]byte(strings.Repeat("abcdefgh", 5)), 20)))
+	assert.Equal(t, "абв...[truncated]", string(truncate([]byte(strings.Repeat("абвгд", 5)), 20)), "cut on rune boundary")
+}
@@ -37,8 +37,16 @@ func TestSyslog(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 72, n)
 
-	assert.Equal(t, "May 30 18:03:27 dev-1 docker[1187]: 2017/10/02 04:05:24.509511 [INFO] message1", <-ch)
-	assert.Equal(t, "May 30 18:03:28 dev-1 docker[1187]: 2017/10/02 04:05:24 [INFO] message2", <-ch)
+	n, err = fmt.Fprintf(conn, "<27>May 30 18:03:29 dev-1 docker[1187]: message3\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 49, n)
+
+	msg := <-ch
+	assert.Equal(t, "May 30 18:03:27 dev-1 docker[1187]: 2017/10/02 04:05:24.509511 [INFO] message1", msg.Line)
+	assert.Equal(t, conn.LocalAddr().String(), msg.Sender)
+	assert.WithinDuration(t, time.Now(), msg.ReceivedTS, time.Second)
+	assert.Equal(t, "May 30 18:03:28 dev-1 docker[1187]: 2017/10/02 04:05:24 [INFO] message2", (<-ch).Line)
+	assert.Equal(t, "<27>May 30 18:03:29 dev-1 docker[1187]: message3", (<-ch).Line, "priority kept")
 	mu.Unlock()
 
 	time.Sleep(time.Millisecond * 400)
@@ -50,3 +58,40 @@ func TestSyslog_Failed(t *testing.T) {
 	assert.NotNil(t, err)
 	t.Log(err)
 }
+
+func TestSyslog_Senders(t *testing.T) {
+	deny, err := ParseCIDRs([]string{"127.0.0.2"})
+	require.NoError(t, err)
+	allow, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	st := &Stats{}
+	s := Syslog{Port: 15516, Stats: st, Senders: &SenderFilter{Allow: allow, Deny: deny, Unknown: SenderTag}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := s.Go(ctx)
+	require.NoError(t, err)
+
+	denied, err := net.DialUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.2")}, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 15516})
+	require.NoError(t, err)
+	defer denied.Close() // nolint
+	_, err = fmt.Fprint(denied, "<30>May 30 18:03:27 dev-1 docker/c1[1187]: denied")
+	require.NoError(t, err)
+
+	tcpDenied, err := (&net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}).Dial("tcp", "127.0.0.1:15516")
+	require.NoError(t, err)
+	defer tcpDenied.Close() // nolint
+	_ = tcpDenied.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = tcpDenied.Read(make([]byte, 1))
+	assert.Error(t, err, "connection of denied sender closed")
+
+	conn, err := net.Dial("udp", "127.0.0.1:15516")
+	require.NoError(t, err)
+	defer conn.Close() // nolint
+	_, err = fmt.Fprint(conn, "<30>May 30 18:03:27 dev-1 docker/c1[1187]: unknown")
+	require.NoError(t, err)
+
+	msg := <-ch
+	assert.Equal(t, "<30>May 30 18:03:27 dev-1 docker/c1[1187]: unknown", msg.Line)
+	assert.True(t, msg.Unknown, "not in allow list, tagged")
+	assert.Equal(t, int64(2), st.SenderRejected.Load(), "udp message and tcp connection rejected")
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// listenTLS listens syslog over tls (RFC 5425) on TLSPort
+func (s *Syslog) listenTLS() (net.Listener, error) {
+	listener, err := tls.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", s.TLSPort), s.TLS)
+	if err != nil {
+		return nil, errors.Wrapf(err, "syslog can't listen to tls on %d", s.TLSPort)
+	}
+	log.Printf("[INFO] activate syslog tls server on %d", s.TLSPort)
+	return listener, nil
+}
+
+// handshakeTLS authenticates tls connection before reading messages, client certificate checked with server's config
+func handshakeTLS(ctx context.Context, conn *tls.Conn) error {
+	sender := conn.RemoteAddr().String()
+	_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return errors.Wrapf(err, "syslog tls handshake with %s failed", sender)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		log.Printf("[DEBUG] syslog tls connection from %s, client certificate %q", sender, certs[0].Subject.CommonName)
+	}
+	return nil
+}
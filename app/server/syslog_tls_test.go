@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslog_TLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server", 1)
+	ca.issue(t, dir, "client", 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tlsConfig, err := NewServerTLS(ctx, TLSParams{CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile: filepath.Join(dir, "server-key.pem"), ClientCAFile: filepath.Join(dir, "ca.pem")})
+	require.NoError(t, err)
+
+	s := Syslog{Port: 15511, TLSPort: 16514, TLS: tlsConfig}
+	ch, err := s.Go(ctx)
+	require.NoError(t, err)
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	require.NoError(t, err)
+	conn, err := tls.Dial("tcp", "localhost:16514",
+		&tls.Config{RootCAs: ca.pool(), Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+	defer conn.Close() // nolint
+
+	msgs := []string{"<30>2019-05-24T20:54:30-05:00 dev-1 docker/c1[1187]: message1",
+		"<27>2019-05-24T20:54:31-05:00 dev-1 docker/c1[1187]: message2\nwith two lines\n"}
+	for _, m := range msgs {
+		_, err = fmt.Fprintf(conn, "%d %s", len(m), m)
+		require.NoError(t, err)
+	}
+
+	msg := <-ch
+	assert.Equal(t, msgs[0], msg.Line)
+	assert.Equal(t, conn.LocalAddr().String(), msg.Sender)
+	assert.Equal(t, "<27>2019-05-24T20:54:31-05:00 dev-1 docker/c1[1187]: message2\nwith two lines", (<-ch).Line)
+
+	// no client certificate
+	noCertConn, err := tls.Dial("tcp", "localhost:16514", &tls.Config{RootCAs: ca.pool(), MinVersion: tls.VersionTLS12})
+	if err == nil { // tls 1.3 client learns about rejected certificate on read
+		_, _ = fmt.Fprint(noCertConn, "5 hello")
+		_ = noCertConn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = bufio.NewReader(noCertConn).ReadByte()
+		_ = noCertConn.Close()
+	}
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate required")
+
+	// bad frame closes connection
+	_, err = fmt.Fprint(conn, "hello world")
+	require.NoError(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = bufio.NewReader(conn).ReadByte()
+	assert.Error(t, err, "connection closed by server")
+
+	select {
+	case m := <-ch:
+		t.Fatalf("unexpected message %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	for range ch { //nolint:revive // drain till closed
+	}
+}
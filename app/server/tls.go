@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// TLSParams defines server certificate and optional CA bundle to verify client certificates
+type TLSParams struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string        // optional, requires client certificate signed by this CA
+	ReloadInterval time.Duration // how often cert files checked for change, 10s by default
+}
+
+const defTLSReloadInterval = 10 * time.Second
+
+// certReloader keeps server certificate and reloads it on SIGHUP or cert/key file change
+type certReloader struct {
+	TLSParams
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewServerTLS makes tls config with certificate reloaded on SIGHUP or file change until ctx done
+func NewServerTLS(ctx context.Context, params TLSParams) (*tls.Config, error) {
+	if params.ReloadInterval == 0 {
+		params.ReloadInterval = defTLSReloadInterval
+	}
+	r := &certReloader{TLSParams: params}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	res := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: r.getCertificate}
+
+	if params.ClientCAFile != "" {
+		data, err := os.ReadFile(params.ClientCAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read client CA %s", params.ClientCAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates in client CA %s", params.ClientCAFile)
+		}
+		res.ClientCAs, res.ClientAuth = pool, tls.RequireAndVerifyClientCert
+	}
+
+	go r.watch(ctx)
+	return res, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// reload loads cert and key, the current certificate kept on error
+func (r *certReloader) reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return errors.Wrapf(err, "can't load certificate %s", r.CertFile)
+	}
+	r.lock.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.lock.Unlock()
+	return nil
+}
+
+// watch reloads certificate on SIGHUP or if cert or key file changed
+func (r *certReloader) watch(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	ticker := time.NewTicker(r.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			log.Printf("[INFO] SIGHUP received, reload certificate %s", r.CertFile)
+		case <-ticker.C:
+			modTime, err := r.filesModTime()
+			r.lock.RLock()
+			changed := err == nil && !modTime.Equal(r.modTime)
+			r.lock.RUnlock()
+			if !changed {
+				continue
+			}
+			log.Printf("[INFO] certificate %s changed, reload", r.CertFile)
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("[WARN] failed to reload certificate, the current one kept, %v", err)
+		}
+	}
+}
+
+// filesModTime returns the latest modification time of cert and key
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var res time.Time
+	for _, f := range []string{r.CertFile, r.KeyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "can't check %s", f)
+		}
+		if fi.ModTime().After(res) {
+			res = fi.ModTime()
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server", 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.pem"), []byte("bad"), 0o600))
+
+	tbl := []struct {
+		params TLSParams
+		err    string
+	}{
+		{TLSParams{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server-key.pem")}, ""},
+		{TLSParams{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server-key.pem"),
+			ClientCAFile: filepath.Join(dir, "ca.pem")}, ""},
+		{TLSParams{CertFile: filepath.Join(dir, "nope.pem"), KeyFile: filepath.Join(dir, "server-key.pem")}, "can't check"},
+		{TLSParams{CertFile: filepath.Join(dir, "bad.pem"), KeyFile: filepath.Join(dir, "server-key.pem")}, "can't load certificate"},
+		{TLSParams{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server-key.pem"),
+			ClientCAFile: filepath.Join(dir, "nope.pem")}, "can't read client CA"},
+		{TLSParams{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server-key.pem"),
+			ClientCAFile: filepath.Join(dir, "bad.pem")}, "no certificates in client CA"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i, tt := range tbl {
+		res, err := NewServerTLS(ctx, tt.params)
+		if tt.err != "" {
+			require.Error(t, err, "mismatch in #%d", i)
+			assert.Contains(t, err.Error(), tt.err, "mismatch in #%d", i)
+			continue
+		}
+		require.NoError(t, err, "mismatch in #%d", i)
+		cert, err := res.GetCertificate(nil)
+		require.NoError(t, err, "mismatch in #%d", i)
+		assert.Equal(t, int64(1), cert.Leaf.SerialNumber.Int64(), "mismatch in #%d", i)
+		if tt.params.ClientCAFile != "" {
+			assert.Equal(t, tls.RequireAndVerifyClientCert, res.ClientAuth, "mismatch in #%d", i)
+		} else {
+			assert.Equal(t, tls.NoClientCert, res.ClientAuth, "mismatch in #%d", i)
+		}
+	}
+}
+
+func TestNewServerTLS_ReloadOnChange(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	params := TLSParams{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server-key.pem"),
+		ReloadInterval: 10 * time.Millisecond}
+	res, err := NewServerTLS(ctx, params)
+	require.NoError(t, err)
+
+	ca.issue(t, dir, "server", 2)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(params.CertFile, future, future))
+	assert.Eventually(t, func() bool {
+		cert, e := res.GetCertificate(nil)
+		return e == nil && cert.Leaf.SerialNumber.Int64() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// broken file keeps the current certificate
+	require.NoError(t, os.WriteFile(params.CertFile, []byte("bad"), 0o600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(params.CertFile, future, future))
+	time.Sleep(50 * time.Millisecond)
+	cert, err := res.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cert.Leaf.SerialNumber.Int64())
+}
+
+func TestNewServerTLS_ReloadOnSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	params := TLSParams{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server-key.pem"),
+		ReloadInterval: time.Hour}
+	res, err := NewServerTLS(ctx, params)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond) // let watcher subscribe to signal
+
+	ca.issue(t, dir, "server", 2)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		cert, e := res.GetCertificate(nil)
+		return e == nil && cert.Leaf.SerialNumber.Int64() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRest_RunTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server", 1)
+	ca.issue(t, dir, "client", 2)
+	other := newTestCA(t, t.TempDir())
+	other.issue(t, dir, "other", 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tlsConfig, err := NewServerTLS(ctx, TLSParams{CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile: filepath.Join(dir, "server-key.pem"), ClientCAFile: filepath.Join(dir, "ca.pem")})
+	require.NoError(t, err)
+
+	srv := RestServer{DataService: &mockDataService{}, Port: 10443, TLS: tlsConfig}
+	done := make(chan error)
+	go func() { done <- srv.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	get := func(cert string) (string, error) {
+		cfg := &tls.Config{RootCAs: ca.pool(), MinVersion: tls.VersionTLS12}
+		if cert != "" {
+			c, e := tls.LoadX509KeyPair(filepath.Join(dir, cert+".pem"), filepath.Join(dir, cert+"-key.pem"))
+			require.NoError(t, e)
+			cfg.Certificates = []tls.Certificate{c}
+		}
+		client := http.Client{Transport: &http.Transport{TLSClientConfig: cfg}, Timeout: time.Second}
+		resp, e := client.Get("https://localhost:10443/ping")
+		if e != nil {
+			return "", e
+		}
+		defer resp.Body.Close() // nolint
+		body, e := io.ReadAll(resp.Body)
+		return string(body), e
+	}
+
+	body, err := get("client")
+	require.NoError(t, err)
+	assert.Equal(t, "pong", body)
+
+	_, err = get("")
+	assert.Error(t, err, "no client certificate")
+	_, err = get("other")
+	assert.Error(t, err, "client certificate signed by unknown CA")
+
+	resp, err := http.Get("http://localhost:10443/ping")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "plain http rejected")
+
+	cancel()
+	assert.EqualError(t, <-done, "http: Server closed")
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA makes self-signed CA and writes it to dir/ca.pem
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(100),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue writes certificate for localhost signed by CA to dir/name.pem and its key to dir/name-key.pem
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDer)
+	writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	res := x509.NewCertPool()
+	res.AddCert(ca.cert)
+	return res
+}
+
+func writePEM(t *testing.T, fileName, typ string, der []byte) {
+	require.NoError(t, os.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
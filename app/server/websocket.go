@@ -0,0 +1,402 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint:gosec // required by websocket handshake
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+// wsControl is a message from websocket client. Filter replaces request, sends records after its LastID from store
+// and continues live tail. Pause stops live tail, resume continues it after the last sent record.
+type wsControl struct {
+	Type    string       `json:"type"` // filter, pause or resume
+	Request core.Request `json:"request"`
+}
+
+// wsEvent is a message to websocket client
+type wsEvent struct {
+	Type  string         `json:"type"` // entry, lagged or error
+	Entry *core.LogEntry `json:"entry,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// websocket frame opcodes and close codes, see RFC 6455
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsCloseNormal    = 1000
+	wsCloseProtocol  = 1002
+	wsCloseTooBig    = 1009
+	wsCloseTryLater  = 1013
+	wsMaxMessageSize = 64 * 1024
+	wsWriteTimeout   = 10 * time.Second // slow client not reading for this long dropped
+	wsGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var errWSClosed = errors.New("websocket closed")
+
+// wsConn is a minimal server side websocket connection, text messages only
+type wsConn struct {
+	conn  net.Conn
+	rd    *bufio.Reader
+	wlock sync.Mutex
+}
+
+// GET /v1/ws?q=query&last_id=id, WebSocket live tail. Sends records after last_id from store and then records
+// pushed as published, as {"type":"entry","entry":{...}} messages. Client changes filters or pauses live tail
+// with control messages, i.e. {"type":"filter","request":{"hosts":["h1"]}} or {"type":"pause"}.
+// Subscriber failed to keep up skips missed records and gets {"type":"lagged"}, client not reading at all dropped.
+func (s *RestServer) wsCtrl(w http.ResponseWriter, r *http.Request) {
+	req, err := s.applyQuery(r, core.Request{LastID: r.URL.Query().Get("last_id")})
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, err.Error()) // pass position to the client
+		return
+	}
+	conn, err := upgradeWS(w, r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, log.Default(), http.StatusBadRequest, err, "failed to upgrade to websocket")
+		return
+	}
+	defer conn.close(wsCloseNormal, "")
+	log.Printf("[DEBUG] websocket started for %+v", req)
+
+	ctrl := make(chan wsControl)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(ctrl)
+		for {
+			data, err := conn.read()
+			if err != nil {
+				log.Printf("[DEBUG] websocket terminated, %v", err)
+				return
+			}
+			msg := wsControl{}
+			if err = json.Unmarshal(data, &msg); err != nil {
+				if err = conn.writeJSON(wsEvent{Type: "error", Error: "bad control message, " + err.Error()}); err != nil {
+					return
+				}
+				continue
+			}
+			select {
+			case ctrl <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var sub *Subscription
+	defer func() {
+		if sub != nil {
+			sub.Close()
+		}
+	}()
+
+	// send skips records sent already, like in tail
+	send := func(recs []core.LogEntry) error {
+		for i := range recs {
+			if recs[i].ID != "" && recs[i].ID <= req.LastID {
+				continue
+			}
+			if err := conn.writeJSON(wsEvent{Type: "entry", Entry: &recs[i]}); err != nil {
+				return err
+			}
+			req.LastID = recs[i].ID
+		}
+		return nil
+	}
+
+	// follow subscribes for req and sends the page of records after req.LastID from store
+	follow := func() error {
+		if sub != nil {
+			sub.Close()
+		}
+		if sub, err = s.Hub.Subscribe(req); err != nil {
+			return conn.writeJSON(wsEvent{Type: "error", Error: err.Error()})
+		}
+		if req.LastID == "" {
+			return nil
+		}
+		recs, e := s.DataService.Find(req)
+		if e != nil {
+			return conn.writeJSON(wsEvent{Type: "error", Error: e.Error()})
+		}
+		if err = send(recs); err != nil {
+			return err
+		}
+		if len(recs) >= req.Limit { // more records missed than a single page
+			return conn.writeJSON(wsEvent{Type: "lagged"})
+		}
+		return nil
+	}
+
+	if req.Limit == 0 || req.Limit > s.Limit {
+		req.Limit = s.Limit
+	}
+	if err = follow(); err != nil {
+		return
+	}
+
+	interval := s.Heartbeat
+	if interval == 0 {
+		interval = 15 * time.Second
+	}
+	ping := time.NewTicker(interval)
+	defer ping.Stop()
+	for {
+		var entries <-chan core.LogEntry // nil if paused
+		if sub != nil {
+			entries = sub.Entries()
+		}
+
+		select {
+		case msg, ok := <-ctrl:
+			if !ok {
+				return
+			}
+			switch msg.Type {
+			case "filter":
+				newReq, e := msg.Request.WithQuery(msg.Request.Query)
+				if cred, ok := credential(r); ok {
+					newReq = cred.Apply(newReq)
+				}
+				if e == nil {
+					_, e = core.NewMatcher(newReq)
+				}
+				if e != nil { // keep the current filter
+					err = conn.writeJSON(wsEvent{Type: "error", Error: e.Error()})
+					break
+				}
+				if newReq.LastID == "" {
+					newReq.LastID = req.LastID // keep position, only new records after filter change
+				}
+				if newReq.Limit == 0 || newReq.Limit > s.Limit {
+					newReq.Limit = s.Limit
+				}
+				req = newReq
+				err = follow()
+			case "pause":
+				if sub != nil {
+					sub.Close()
+					sub = nil
+				}
+			case "resume":
+				if sub == nil {
+					err = follow()
+				}
+			default:
+				err = conn.writeJSON(wsEvent{Type: "error", Error: "unknown control message " + msg.Type})
+			}
+		case rec, ok := <-entries:
+			if !ok { // lagged, missed records skipped
+				if sub, err = s.Hub.Subscribe(req); err == nil {
+					err = conn.writeJSON(wsEvent{Type: "lagged"})
+				}
+				break
+			}
+			err = send([]core.LogEntry{rec})
+		case <-ping.C:
+			err = conn.write(wsOpPing, nil)
+		}
+		if err != nil {
+			log.Printf("[DEBUG] websocket dropped, %v", err)
+			if !errors.Is(err, errWSClosed) {
+				conn.close(wsCloseTryLater, "write failed")
+			}
+			return
+		}
+	}
+}
+
+// upgradeWS checks websocket handshake and hijacks connection
+func upgradeWS(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing websocket key")
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't hijack connection")
+	}
+	_ = conn.SetDeadline(time.Time{})   // reset server's timeouts
+	h := sha1.Sum([]byte(key + wsGUID)) //nolint:gosec // required by websocket handshake
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n"
+	if _, err = conn.Write([]byte(resp)); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "can't write handshake")
+	}
+	return &wsConn{conn: conn, rd: brw.Reader}, nil
+}
+
+// read returns the next text or binary message, replies to pings and close
+func (c *wsConn) read() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, masked, err := readWSFrame(c.rd)
+		if err != nil {
+			return nil, err
+		}
+		if !masked { // client frames have to be masked
+			c.close(wsCloseProtocol, "unmasked frame")
+			return nil, errWSClosed
+		}
+		switch op {
+		case wsOpPing:
+			if err = c.write(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.close(wsCloseNormal, "")
+			return nil, errWSClosed
+		case wsOpText, wsOpBinary, wsOpContinuation:
+		default:
+			c.close(wsCloseProtocol, "unknown opcode")
+			return nil, errWSClosed
+		}
+		if len(msg)+len(payload) > wsMaxMessageSize {
+			c.close(wsCloseTooBig, "message too big")
+			return nil, errWSClosed
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) writeJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(wsOpText, data)
+}
+
+// write sends a single frame, fails if client doesn't read for wsWriteTimeout
+func (c *wsConn) write(op byte, payload []byte) error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return writeWSFrame(c.conn, op, payload, nil)
+}
+
+// close sends close frame and closes connection, safe to call more than once
+func (c *wsConn) close(code uint16, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	_ = c.write(wsOpClose, append(payload, reason...))
+	_ = c.conn.Close()
+}
+
+// readWSFrame reads a single frame and unmasks payload
+func readWSFrame(rd io.Reader) (fin bool, op byte, payload []byte, masked bool, err error) {
+	hdr := make([]byte, 2)
+	if _, err = io.ReadFull(rd, hdr); err != nil {
+		return false, 0, nil, false, err
+	}
+	fin, op, masked = hdr[0]&0x80 != 0, hdr[0]&0x0f, hdr[1]&0x80 != 0
+	size := uint64(hdr[1] & 0x7f)
+	switch size {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(rd, ext); err != nil {
+			return false, 0, nil, false, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(rd, ext); err != nil {
+			return false, 0, nil, false, err
+		}
+		size = binary.BigEndian.Uint64(ext)
+	}
+	if size > wsMaxMessageSize {
+		return false, 0, nil, false, errors.Errorf("websocket frame too big, %d bytes", size)
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(rd, mask); err != nil {
+			return false, 0, nil, false, err
+		}
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(rd, payload); err != nil {
+		return false, 0, nil, false, err
+	}
+	for i := range payload {
+		if masked {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, masked, nil
+}
+
+// writeWSFrame writes a single final frame, masked if mask defined
+func writeWSFrame(w io.Writer, op byte, payload, mask []byte) error {
+	buf := []byte{0x80 | op}
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = binary.BigEndian.AppendUint16(append(buf, maskBit|126), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint64(append(buf, maskBit|127), uint64(n))
+	}
+	if mask != nil {
+		buf = append(buf, mask...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	_, err := w.Write(append(buf, payload...))
+	return err
+}
+
+// headerContains checks if comma separated header has the token, case-insensitive
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/dkll/app/core"
+)
+
+func TestRest_wsCtrl(t *testing.T) {
+	mem, hub := NewMemory(100), NewHub(10)
+	srv := RestServer{DataService: mem, Hub: hub, Limit: 100, Heartbeat: 50 * time.Millisecond}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+	publish := func(recs []core.LogEntry) {
+		require.NoError(t, mem.Publish(recs))
+		hub.Publish(recs)
+	}
+
+	recs := testRecords()
+	require.NoError(t, mem.Publish(recs[:3]))
+	c := wsDial(t, ts.URL, "/v1/ws?last_id="+recs[0].ID+"&q="+url.QueryEscape("host=h1"))
+	defer c.conn.Close()
+	assert.Equal(t, "msg2", c.nextEntry(t).Msg, "from store after last_id")
+	waitSubscribers(t, hub, 1)
+
+	publish(recs[3:4])
+	assert.Equal(t, "msg4", c.nextEntry(t).Msg, "pushed")
+
+	c.send(t, `{"type":"filter","request":{"hosts":["h2"]}}`)
+	time.Sleep(50 * time.Millisecond)
+	publish(recs[4:])
+	assert.Equal(t, "msg6", c.nextEntry(t).Msg, "filter changed")
+
+	c.send(t, `{"type":"pause"}`)
+	waitSubscribers(t, hub, 0)
+	more := testRecords()
+	publish(more)
+	c.send(t, `{"type":"resume"}`)
+	e := c.nextEntry(t)
+	assert.Equal(t, "msg3", e.Msg, "missed on pause sent from store")
+	assert.Equal(t, more[2].ID, e.ID)
+	e = c.nextEntry(t)
+	assert.Equal(t, "msg6", e.Msg)
+	assert.Equal(t, more[5].ID, e.ID)
+
+	c.send(t, `{"type":"filter","request":{"hosts":["/[bad/"]}}`)
+	assert.Contains(t, c.next(t).Error, "bad regex")
+	c.send(t, `{"type":"blah"}`)
+	assert.Equal(t, wsEvent{Type: "error", Error: "unknown control message blah"}, c.next(t))
+	c.send(t, `not json`)
+	assert.Contains(t, c.next(t).Error, "bad control message")
+
+	publish(recs[:3])
+	assert.Equal(t, "msg3", c.nextEntry(t).Msg, "the previous filter kept")
+	assert.True(t, c.pings > 0, "pinged")
+	require.NoError(t, writeWSFrame(c.conn, wsOpClose, []byte{0x03, 0xe8}, []byte{1, 2, 3, 4}))
+	waitSubscribers(t, hub, 0)
+}
+
+func TestRest_wsCtrlLagged(t *testing.T) {
+	mem, hub := NewMemory(100), NewHub(1)
+	srv := RestServer{DataService: mem, Hub: hub, Limit: 100}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	c := wsDial(t, ts.URL, "/v1/ws")
+	defer c.conn.Close()
+	waitSubscribers(t, hub, 1)
+	recs := testRecords()
+	require.NoError(t, mem.Publish(recs))
+	hub.Publish(recs)
+	msgs := []string{}
+	for ev := c.next(t); ev.Type != "lagged"; ev = c.next(t) {
+		msgs = append(msgs, ev.Entry.Msg)
+	}
+	assert.True(t, len(msgs) > 0 && len(msgs) < len(recs), "the rest skipped, %v", msgs)
+	assert.Equal(t, "msg1", msgs[0])
+	waitSubscribers(t, hub, 1)
+	recs = testRecords()[:1]
+	require.NoError(t, mem.Publish(recs))
+	hub.Publish(recs)
+	assert.Equal(t, "msg1", c.nextEntry(t).Msg, "subscribed again")
+}
+
+func TestRest_wsCtrlNotUpgraded(t *testing.T) {
+	srv := RestServer{DataService: NewMemory(10), Hub: NewHub(0), Limit: 100}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/ws")
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+type wsTestClient struct {
+	conn  net.Conn
+	rd    *bufio.Reader
+	pings int
+}
+
+func wsDial(t *testing.T, srvURL, path string) *wsTestClient {
+	u, err := url.Parse(srvURL)
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", path, u.Host)
+	require.NoError(t, err)
+	rd := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(rd, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"), "RFC 6455 example")
+	return &wsTestClient{conn: conn, rd: rd}
+}
+
+func (c *wsTestClient) send(t *testing.T, msg string) {
+	require.NoError(t, writeWSFrame(c.conn, wsOpText, []byte(msg), []byte{1, 2, 3, 4}))
+}
+
+// next returns the next event, pings skipped
+func (c *wsTestClient) next(t *testing.T) wsEvent {
+	require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for {
+		_, op, payload, masked, err := readWSFrame(c.rd)
+		require.NoError(t, err)
+		assert.False(t, masked, "server frames not masked")
+		if op == wsOpPing {
+			c.pings++
+			continue
+		}
+		require.Equal(t, byte(wsOpText), op, string(payload))
+		ev := wsEvent{}
+		require.NoError(t, json.Unmarshal(payload, &ev))
+		return ev
+	}
+}
+
+func (c *wsTestClient) nextEntry(t *testing.T) core.LogEntry {
+	ev := c.next(t)
+	require.Equal(t, "entry", ev.Type, "%+v", ev)
+	return *ev.Entry
+}
+
+func waitSubscribers(t *testing.T, hub *Hub, n int) {
+	require.Eventually(t, func() bool { return hub.Subscribers() == n }, time.Second, 5*time.Millisecond,
+		fmt.Sprintf("expected %d subscribers", n))
+}
+
+func TestWSFrames(t *testing.T) {
+	tbl := []struct {
+		size int
+		mask []byte
+	}{
+		{0, nil}, {10, nil}, {125, []byte{1, 2, 3, 4}}, {126, nil}, {1000, []byte{5, 6, 7, 8}}, {wsMaxMessageSize, nil},
+	}
+	for i, tt := range tbl {
+		buf := strings.Builder{}
+		payload := []byte(strings.Repeat("x", tt.size))
+		require.NoError(t, writeWSFrame(&buf, wsOpBinary, payload, tt.mask), fmt.Sprintf("mismatch in #%d", i))
+		fin, op, res, masked, err := readWSFrame(strings.NewReader(buf.String()))
+		require.NoError(t, err, fmt.Sprintf("mismatch in #%d", i))
+		assert.True(t, fin, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, byte(wsOpBinary), op, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, tt.mask != nil, masked, fmt.Sprintf("mismatch in #%d", i))
+		assert.Equal(t, payload, res, fmt.Sprintf("mismatch in #%d", i))
+	}
+
+	buf := strings.Builder{}
+	require.NoError(t, writeWSFrame(&buf, wsOpBinary, make([]byte, wsMaxMessageSize+1), nil))
+	_, _, _, _, err := readWSFrame(strings.NewReader(buf.String()))
+	assert.EqualError(t, err, "websocket frame too big, 65537 bytes")
+}